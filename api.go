@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/patrobinson/go-fish/state"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -18,6 +22,10 @@ type apiConfig struct {
 	ListenAddress    string                  `json:"listenAddress"`
 	Backend          backendConfig           `json:"backendConfig"`
 	MonitoringConfig monitoringConfiguration `json:"monitoringConfig"`
+	// ProcessRuleCommands are the executables process rules submitted through the API may run, process rules can't be used without them
+	ProcessRuleCommands []string `json:"processRuleCommands,omitempty"`
+	// AdminToken is the bearer token required to delete keys from a state, deleting is disabled without it
+	AdminToken string `json:"adminToken,omitempty"`
 }
 
 type api struct {
@@ -25,14 +33,18 @@ type api struct {
 	Router          *mux.Router
 	httpServer      *http.Server
 	mService        monitoringService
+	adminToken      string
 	apiReady        bool
 }
 
 // Start starts the API server and blocks
 func (a *api) Start(config apiConfig) {
 	a.pipelineManager = &pipelineManager{
-		backendConfig: config.Backend,
+		backendConfig:           config.Backend,
+		restrictProcessCommands: true,
+		processCommands:         config.ProcessRuleCommands,
 	}
+	a.adminToken = config.AdminToken
 	err := a.pipelineManager.Init()
 	if err != nil {
 		log.Fatal(err)
@@ -53,7 +65,16 @@ func (a *api) Start(config apiConfig) {
 	}
 
 	a.Router.Path("/pipelines/{id}").Methods("GET").HandlerFunc(a.GetPipelines)
+	a.Router.Path("/pipelines/{id}").Methods("PUT").HandlerFunc(a.UpdatePipeline)
 	a.Router.Path("/pipelines").Methods("POST").HandlerFunc(a.CreatePipeline)
+	a.Router.Path("/pipelines/{id}/status").Methods("GET").HandlerFunc(a.GetPipelineStatus)
+	a.Router.Path("/pipelines/{id}/states/{name}").Methods("GET").HandlerFunc(a.GetStateKeys)
+	a.Router.Path("/pipelines/{id}/states/{name}/keys/{key:.+}").Methods("GET").HandlerFunc(a.GetStateKey)
+	a.Router.Path("/pipelines/{id}/states/{name}/keys/{key:.+}").Methods("DELETE").HandlerFunc(a.DeleteStateKey)
+	a.Router.Path("/pipelines/{id}/revisions").Methods("GET").HandlerFunc(a.GetRevisions)
+	a.Router.Path("/pipelines/{id}/revisions/{rev:[0-9]+}").Methods("GET").HandlerFunc(a.GetRevision)
+	a.Router.Path("/pipelines/{id}/revisions/{rev:[0-9]+}/diff").Methods("GET").HandlerFunc(a.DiffRevisions)
+	a.Router.Path("/pipelines/{id}/rollback/{rev:[0-9]+}").Methods("POST").HandlerFunc(a.RollbackPipeline)
 	go func(a *api) {
 		err = a.httpServer.ListenAndServe()
 		if err != nil && err != http.ErrServerClosed {
@@ -116,7 +137,7 @@ func (a *api) CreatePipeline(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Debugln("Creating pipeline with config", string(body))
-	pipeline, err := a.pipelineManager.NewPipeline(body, a.mService)
+	pipeline, err := a.pipelineManager.NewPipeline(body, a.mService, requestChange(r))
 	if err != nil {
 		log.Errorln("Error creating pipeline", err)
 		w.WriteHeader(400)
@@ -124,13 +145,6 @@ func (a *api) CreatePipeline(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Debugln("Created pipeline", pipeline.ID)
-	err = a.pipelineManager.Store(pipeline)
-	if err != nil {
-		log.Errorln("Error storing pipeline", err)
-		w.WriteHeader(500)
-		w.Write([]byte(err.Error()))
-		return
-	}
 	go func() {
 		err := pipeline.StartPipeline()
 		if err != nil {
@@ -142,6 +156,301 @@ func (a *api) CreatePipeline(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(pipeline.ID.String()))
 }
 
+// UpdatePipeline reloads the rules of a running Pipeline with a new config
+func (a *api) UpdatePipeline(w http.ResponseWriter, r *http.Request) {
+	pipelineID := mux.Vars(r)["id"]
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Errorln("Error reading body", err)
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if len(body) == 0 {
+		log.Errorln("Empty body received")
+		w.WriteHeader(400)
+		w.Write([]byte("No pipeline config received"))
+		return
+	}
+	log.Debugln("Reloading pipeline", pipelineID, "with config", string(body))
+	pipeline, err := a.pipelineManager.Reload(pipelineID, body, requestChange(r))
+	if err == errPipelineNotFound {
+		w.WriteHeader(404)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Errorln("Error reloading pipeline", err)
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	log.Debugln("Reloaded pipeline", pipeline.ID)
+	w.Write([]byte(pipeline.ID.String()))
+}
+
+// GetPipelineStatus gets whether a Pipeline is running and the status of its states
+func (a *api) GetPipelineStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := a.pipelineManager.Status(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	response, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// GetStateKeys lists the keys of a Pipeline's state in order, in pages of the limit query parameter (100 by default),
+// starting after the key in the after query parameter and filtered by the prefix query parameter.
+// For a state that keeps a single count it gets the count, without resetting it.
+func (a *api) GetStateKeys(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.getState(w, r)
+	if !ok {
+		return
+	}
+	defer releaseState(s)
+	var kv state.KeyValue
+	switch s := s.(type) {
+	case state.KeyValue:
+		kv = s
+	case state.WindowCounter:
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strconv.Itoa(s.Value())))
+		return
+	case state.DistinctCounter:
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strconv.FormatUint(s.Count(), 10)))
+		return
+	default:
+		w.WriteHeader(400)
+		w.Write([]byte("State can't list keys"))
+		return
+	}
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 1000 {
+			w.WriteHeader(400)
+			w.Write([]byte("Invalid limit, it must be between 1 and 1000"))
+			return
+		}
+		limit = n
+	}
+	page, err := listKeys(kv, r.URL.Query().Get("prefix"), r.URL.Query().Get("after"), limit)
+	if err != nil {
+		log.Errorln("Error listing keys", err)
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	response, _ := json.Marshal(page)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// GetStateKey gets the value of a key in a Pipeline's state, or its count if the state only keeps counts
+func (a *api) GetStateKey(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.getState(w, r)
+	if !ok {
+		return
+	}
+	defer releaseState(s)
+	key := []byte(mux.Vars(r)["key"])
+	switch s := s.(type) {
+	case state.KeyValue:
+		value := s.Get(key)
+		if value == nil {
+			w.WriteHeader(404)
+			w.Write([]byte(errKeyNotFound.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(value)
+	case state.KeyedCounter:
+		count, err := s.Count(key)
+		if err != nil {
+			log.Errorln("Error getting count", err)
+			w.WriteHeader(500)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strconv.FormatInt(count, 10)))
+	default:
+		w.WriteHeader(400)
+		w.Write([]byte("State can't get keys"))
+	}
+}
+
+// DeleteStateKey deletes a key from a Pipeline's state, it requires the admin token
+func (a *api) DeleteStateKey(w http.ResponseWriter, r *http.Request) {
+	if !a.authorised(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(401)
+		return
+	}
+	s, ok := a.getState(w, r)
+	if !ok {
+		return
+	}
+	defer releaseState(s)
+	kv, ok := s.(state.KeyValue)
+	if !ok {
+		w.WriteHeader(400)
+		w.Write([]byte("State can't delete keys"))
+		return
+	}
+	vars := mux.Vars(r)
+	log.Infof("Deleting key %s from state %s of pipeline %s", vars["key"], vars["name"], vars["id"])
+	kv.Delete([]byte(vars["key"]))
+	w.WriteHeader(204)
+}
+
+// getState writes an error response and returns false if the Pipeline isn't running or has no such state.
+// The state must be released when the request is done with it.
+func (a *api) getState(w http.ResponseWriter, r *http.Request) (state.State, bool) {
+	s, err := a.pipelineManager.State(mux.Vars(r)["id"], mux.Vars(r)["name"])
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte(err.Error()))
+		return nil, false
+	}
+	return s, true
+}
+
+// releaseState closes a state got by getState, which closes it if its Pipeline has stopped during the request
+func releaseState(s state.State) {
+	if err := s.Close(); err != nil {
+		log.Errorln("Error closing state", err)
+	}
+}
+
+// authorised returns whether the request has the admin token as its bearer token
+func (a *api) authorised(r *http.Request) bool {
+	authorization := r.Header.Get("Authorization")
+	if a.adminToken == "" || !strings.HasPrefix(authorization, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) == 1
+}
+
+// GetRevisions gets the number, timestamp, author and comment of every revision of a Pipeline's config
+func (a *api) GetRevisions(w http.ResponseWriter, r *http.Request) {
+	pipelineID := mux.Vars(r)["id"]
+	revisions, err := a.pipelineManager.Revisions([]byte(pipelineID))
+	if err != nil {
+		log.Errorln("Error getting revisions", err)
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if len(revisions) == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	for i := range revisions {
+		revisions[i].Config = nil
+	}
+	response, _ := json.Marshal(revisions)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// GetRevision gets a revision of a Pipeline's config
+func (a *api) GetRevision(w http.ResponseWriter, r *http.Request) {
+	rev, ok := a.getRevision(w, mux.Vars(r)["id"], mux.Vars(r)["rev"])
+	if !ok {
+		return
+	}
+	response, _ := json.Marshal(rev)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// DiffRevisions gets a line diff between a revision of a Pipeline's config and the revision in
+// the from query parameter, which defaults to the previous revision
+func (a *api) DiffRevisions(w http.ResponseWriter, r *http.Request) {
+	pipelineID := mux.Vars(r)["id"]
+	to, ok := a.getRevision(w, pipelineID, mux.Vars(r)["rev"])
+	if !ok {
+		return
+	}
+	from := &revision{Number: to.Number - 1}
+	if fromNumber := r.URL.Query().Get("from"); fromNumber != "" {
+		from, ok = a.getRevision(w, pipelineID, fromNumber)
+		if !ok {
+			return
+		}
+	} else if from.Number > 0 {
+		from, ok = a.getRevision(w, pipelineID, strconv.Itoa(from.Number))
+		if !ok {
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(diffConfigs(*from, *to)))
+}
+
+// RollbackPipeline redeploys an earlier revision of a running Pipeline's config
+func (a *api) RollbackPipeline(w http.ResponseWriter, r *http.Request) {
+	pipelineID := mux.Vars(r)["id"]
+	number, err := strconv.Atoi(mux.Vars(r)["rev"])
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	log.Debugln("Rolling back pipeline", pipelineID, "to revision", number)
+	pipeline, err := a.pipelineManager.Rollback(pipelineID, number, requestChange(r))
+	if err == errPipelineNotFound || err == errRevisionNotFound {
+		w.WriteHeader(404)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Errorln("Error rolling back pipeline", err)
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	log.Debugln("Rolled back pipeline", pipeline.ID, "as revision", pipeline.Revision)
+	w.Write([]byte(strconv.Itoa(pipeline.Revision)))
+}
+
+// getRevision writes an error response and returns false if the revision can't be found
+func (a *api) getRevision(w http.ResponseWriter, pipelineID string, number string) (*revision, bool) {
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return nil, false
+	}
+	rev, err := a.pipelineManager.GetRevision([]byte(pipelineID), n)
+	if err != nil {
+		log.Errorln("Error getting revision", err)
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return nil, false
+	}
+	if rev == nil {
+		w.WriteHeader(404)
+		w.Write([]byte(errRevisionNotFound.Error()))
+		return nil, false
+	}
+	return rev, true
+}
+
+// requestChange gets the optional author and comment of a change to a Pipeline's config from the request headers
+func requestChange(r *http.Request) revision {
+	return revision{
+		Author:  r.Header.Get("X-Author"),
+		Comment: r.Header.Get("X-Comment"),
+	}
+}
+
 func parseAPIServerConfig(config []byte) apiConfig {
 	var c apiConfig
 	json.Unmarshal(config, &c)
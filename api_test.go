@@ -2,15 +2,18 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/patrobinson/go-fish/state"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -47,6 +50,10 @@ var pConfig = []byte(`
 var a api
 
 func TestMain(m *testing.M) {
+	// The test binary is started as a rule process by TestProcessRule
+	if os.Getenv("GO_FISH_RULE_PROCESS") == "1" {
+		serveTestProcessRule()
+	}
 	log.SetLevel(log.DebugLevel)
 	a = api{}
 	go a.Start(apiConfig{
@@ -81,13 +88,13 @@ func TestGetPipelines(t *testing.T) {
 		MonitoringService: "", // Noop service
 	}
 	mService, err := mConfig.init(mux.NewRouter())
-	pipeline, err := a.pipelineManager.NewPipeline(pConfig, mService)
+	pipeline, err := a.pipelineManager.NewPipeline(pConfig, mService, revision{})
 	if err != nil {
 		t.Fatalf("Error creating pipeline %s", err)
 	}
 
 	pID, _ := (*pipeline).ID.MarshalText()
-	t.Logf(fmt.Sprintf("Getting /pipelines/%s", pID))
+	t.Logf("Getting /pipelines/%s", pID)
 	req, _ := http.NewRequest("GET", fmt.Sprintf("/pipelines/%s", pID), nil)
 	response := executeRequest(req)
 	if response.Code != 200 {
@@ -116,3 +123,169 @@ func TestCreatePipeline(t *testing.T) {
 	}
 	a.Shutdown()
 }
+
+func TestGetPipelineStatus(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/pipelines", bytes.NewReader(pConfig))
+	response := executeRequest(req)
+	if response.Code != 201 {
+		t.Fatalf("Expected 201 Created, got: %d", response.Code)
+	}
+	pID := response.Body.String()
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/pipelines/%s/status", pID), nil)
+	response = executeRequest(req)
+	var status pipelineStatus
+	if err := json.Unmarshal(response.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Error decoding status %s: %s", response.Body.String(), err)
+	}
+	if response.Code != 200 || status.ID != pID || status.Revision != 1 {
+		t.Errorf("Expected the pipeline's status, got: %d %s", response.Code, response.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/pipelines/unknown/status", nil)
+	if response = executeRequest(req); response.Code != 404 {
+		t.Errorf("Expected 404 Not Found for an unknown pipeline, got: %d", response.Code)
+	}
+	a.Shutdown()
+}
+
+var statefulPConfig = []byte(`{
+	"eventFolder": "testdata/eventTypes",
+	"sources": {"fileInput": {"type": "File", "file_config": {"path": "testdata/pipelines/input"}}},
+	"rules": {
+		"statefulRule": {"source": "fileInput", "state": "lookup", "plugin": "testdata/rules/stateful.so", "sink": "fileOutput"}
+	},
+	"states": {
+		"lookup": {"type": "KV", "kvConfig": {"dbFileName": "api_state_test.db", "bucketName": "lookup"}},
+		"logins": {"type": "KeyedCount"},
+		"requests": {"type": "Count"}
+	},
+	"sinks": {"fileOutput": {"type": "File", "file_config": {"path": "testdata/pipelines/output"}}}
+}`)
+
+func TestStateAPI(t *testing.T) {
+	defer os.Remove("api_state_test.db")
+	req, _ := http.NewRequest("POST", "/pipelines", bytes.NewReader(statefulPConfig))
+	response := executeRequest(req)
+	if response.Code != 201 {
+		t.Fatalf("Expected 201 Created, got: %d %s", response.Code, response.Body.String())
+	}
+	pID := response.Body.String()
+	lookup, _ := a.pipelineManager.State(pID, "lookup")
+	for _, key := range []string{"role/admin", "role/dev", "role/ops", "user/bob"} {
+		lookup.(state.KeyValue).Set([]byte(key), []byte(key+" value"))
+	}
+	logins, _ := a.pipelineManager.State(pID, "logins")
+	logins.(state.KeyedCounter).Add([]byte("bob"), 3)
+	releaseState(logins)
+	requests, _ := a.pipelineManager.State(pID, "requests")
+	requests.(state.WindowCounter).Increment()
+	requests.(state.WindowCounter).Increment()
+	releaseState(requests)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/pipelines/%s/states/%s", pID, path), nil)
+		return executeRequest(req)
+	}
+	var page keyPage
+	response = get("lookup?prefix=role/&limit=2")
+	json.Unmarshal(response.Body.Bytes(), &page)
+	if !reflect.DeepEqual(page, keyPage{Keys: []string{"role/admin", "role/dev"}, Next: "role/dev"}) {
+		t.Errorf("Expected the first page of roles, got: %d %s", response.Code, response.Body.String())
+	}
+	page = keyPage{}
+	response = get("lookup?prefix=role/&limit=2&after=role/dev")
+	json.Unmarshal(response.Body.Bytes(), &page)
+	if !reflect.DeepEqual(page, keyPage{Keys: []string{"role/ops"}}) {
+		t.Errorf("Expected the last page of roles, got: %d %s", response.Code, response.Body.String())
+	}
+	if response = get("lookup?limit=0"); response.Code != 400 {
+		t.Errorf("Expected 400 Bad Request for an invalid limit, got: %d", response.Code)
+	}
+	if response = get("logins"); response.Code != 400 {
+		t.Errorf("Expected 400 Bad Request listing a KeyedCount state, got: %d", response.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if response = get("requests"); response.Code != 200 || response.Body.String() != "2" {
+			t.Errorf("Expected the count of a Count state not to be reset by reading it, got: %d %s", response.Code, response.Body.String())
+		}
+	}
+	if response = get("unknown"); response.Code != 404 {
+		t.Errorf("Expected 404 Not Found for an unknown state, got: %d", response.Code)
+	}
+
+	if response = get("lookup/keys/user/bob"); response.Code != 200 || response.Body.String() != "user/bob value" {
+		t.Errorf("Expected the value of user/bob, got: %d %s", response.Code, response.Body.String())
+	}
+	if response = get("logins/keys/bob"); response.Code != 200 || response.Body.String() != "3" {
+		t.Errorf("Expected the count of bob, got: %d %s", response.Code, response.Body.String())
+	}
+
+	a.adminToken = "secret"
+	defer func() { a.adminToken = "" }()
+	for token, code := range map[string]int{"": 401, "Bearer wrong": 401, "secret": 401, "Bearer secret": 204} {
+		req, _ = http.NewRequest("DELETE", fmt.Sprintf("/pipelines/%s/states/lookup/keys/user/bob", pID), nil)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		if response = executeRequest(req); response.Code != code {
+			t.Errorf("Expected %d deleting with authorization %q, got: %d", code, token, response.Code)
+		}
+	}
+	if response = get("lookup/keys/user/bob"); response.Code != 404 {
+		t.Errorf("Expected a deleted key to be 404 Not Found, got: %d %s", response.Code, response.Body.String())
+	}
+
+	// A state got for a request stays open until it's released, even if the pipeline stops
+	a.pipelineManager.Lock()
+	p := a.pipelineManager.pipelines[pID]
+	a.pipelineManager.Unlock()
+	p.closeStates()
+	if value := lookup.(state.KeyValue).Get([]byte("role/dev")); string(value) != "role/dev value" {
+		t.Errorf("Expected the state to stay open until it's released, got %q", value)
+	}
+	releaseState(lookup)
+	if response = get("lookup/keys/role/dev"); response.Code != 404 {
+		t.Errorf("Expected a closed state to be 404 Not Found, got: %d %s", response.Code, response.Body.String())
+	}
+	a.Shutdown()
+}
+
+func TestPipelineRevisions(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/pipelines", bytes.NewReader(pConfig))
+	req.Header.Set("X-Author", "alice")
+	req.Header.Set("X-Comment", "Initial config")
+	response := executeRequest(req)
+	if response.Code != 201 {
+		t.Fatalf("Expected 201 Created, got: %d", response.Code)
+	}
+	pID := response.Body.String()
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/pipelines/%s/revisions", pID), nil)
+	response = executeRequest(req)
+	var revisions []revision
+	if err := json.Unmarshal(response.Body.Bytes(), &revisions); err != nil {
+		t.Fatalf("Error decoding revisions %s: %s", response.Body.String(), err)
+	}
+	if len(revisions) != 1 || revisions[0].Number != 1 || revisions[0].Author != "alice" || revisions[0].Comment != "Initial config" || revisions[0].Config != nil {
+		t.Errorf("Expected a single revision without config, got %s", response.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/pipelines/%s/revisions/1/diff", pID), nil)
+	response = executeRequest(req)
+	if response.Code != 200 || !strings.HasPrefix(response.Body.String(), "--- revision 0\n+++ revision 1\n+{\n") {
+		t.Errorf("Expected a diff adding the config, got: %d %s", response.Code, response.Body.String())
+	}
+
+	for _, path := range []string{"/revisions/2", "/revisions/1/diff?from=2", "/rollback/2"} {
+		method := "GET"
+		if strings.HasPrefix(path, "/rollback") {
+			method = "POST"
+		}
+		req, _ = http.NewRequest(method, fmt.Sprintf("/pipelines/%s%s", pID, path), nil)
+		if response = executeRequest(req); response.Code != 404 {
+			t.Errorf("Expected 404 Not Found for %s, got: %d", path, response.Code)
+		}
+	}
+	a.Shutdown()
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -16,8 +20,11 @@ import (
 
 type backend interface {
 	Init() error
-	Store(*pipeline) error
+	// Store stores the pipeline's config as its latest config and as a new revision, numbering the revision
+	Store(*pipeline, *revision) error
 	Get(uuid []byte) ([]byte, error)
+	Revisions(uuid []byte) ([]revision, error)
+	GetRevision(uuid []byte, number int) (*revision, error)
 }
 
 type backendConfig struct {
@@ -70,10 +77,21 @@ type boltDBBackend struct {
 func (bb *boltDBBackend) Init() error {
 	var err error
 	bb.db, err = startBoltDB(bb.DatabaseName, bb.BucketName)
-	return err
+	if err != nil {
+		return err
+	}
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bb.revisionsBucket())
+		return err
+	})
 }
 
-func (bb *boltDBBackend) Store(p *pipeline) error {
+// revisionsBucket holds a bucket of revisions for each pipeline, keyed by revision number
+func (bb *boltDBBackend) revisionsBucket() []byte {
+	return []byte(bb.BucketName + "Revisions")
+}
+
+func (bb *boltDBBackend) Store(p *pipeline, rev *revision) error {
 	key, err := (*p).ID.MarshalText()
 	if err != nil {
 		return err
@@ -85,6 +103,22 @@ func (bb *boltDBBackend) Store(p *pipeline) error {
 		if b == nil {
 			return errors.New("Bucket does not exist")
 		}
+		revisions, err := tx.Bucket(bb.revisionsBucket()).CreateBucketIfNotExists(key)
+		if err != nil {
+			return err
+		}
+		number, err := revisions.NextSequence()
+		if err != nil {
+			return err
+		}
+		rev.Number = int(number)
+		revValue, err := json.Marshal(rev)
+		if err != nil {
+			return err
+		}
+		if err = revisions.Put(revisionKey(rev.Number), revValue); err != nil {
+			return err
+		}
 		return b.Put(key, value)
 	})
 }
@@ -99,6 +133,49 @@ func (bb *boltDBBackend) Get(uuid []byte) ([]byte, error) {
 	return value, err
 }
 
+func (bb *boltDBBackend) Revisions(uuid []byte) ([]revision, error) {
+	var revs []revision
+	err := bb.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bb.revisionsBucket()).Bucket(uuid)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, value []byte) error {
+			var rev revision
+			if err := json.Unmarshal(value, &rev); err != nil {
+				return err
+			}
+			revs = append(revs, rev)
+			return nil
+		})
+	})
+	return revs, err
+}
+
+func (bb *boltDBBackend) GetRevision(uuid []byte, number int) (*revision, error) {
+	var rev *revision
+	err := bb.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bb.revisionsBucket()).Bucket(uuid)
+		if b == nil {
+			return nil
+		}
+		value := b.Get(revisionKey(number))
+		if value == nil {
+			return nil
+		}
+		rev = &revision{}
+		return json.Unmarshal(value, rev)
+	})
+	return rev, err
+}
+
+// revisionKey is big endian so revisions are iterated in order
+func revisionKey(number int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(number))
+	return key
+}
+
 // DynamoDB
 type dynamoDBConfig struct {
 	Region    string `json:"region"`
@@ -128,14 +205,43 @@ func (ddb *dynamoDBBackend) Init() error {
 	ddb.svc = dynamodb.New(session)
 	return nil
 }
-func (ddb *dynamoDBBackend) Store(p *pipeline) error {
+
+// Store updates the pipeline's item with the latest config and revision number, failing if another
+// writer stored a revision first, before writing the revision as its own item keyed by the
+// pipeline's UUID and the revision number. A failed revision write only leaves a gap in the history
+func (ddb *dynamoDBBackend) Store(p *pipeline, rev *revision) error {
 	key, err := (*p).ID.MarshalText()
 	if err != nil {
 		return err
 	}
 
+	latest, err := ddb.getItem(key)
+	if err != nil {
+		return err
+	}
+	rev.Number = 1
+	condition := "attribute_not_exists(#revision)"
+	var values map[string]*dynamodb.AttributeValue
+	if number, ok := latest["Revision"]; ok && number.N != nil {
+		previous, err := strconv.Atoi(*number.N)
+		if err != nil {
+			return err
+		}
+		rev.Number = previous + 1
+		condition = "#revision = :previous"
+		values = map[string]*dynamodb.AttributeValue{
+			":previous": {
+				N: number.N,
+			},
+		}
+	}
+	revValue, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+
 	value := (*p).Config
-	dynamoValue := &dynamodb.PutItemInput{
+	err = ddb.putItem(&dynamodb.PutItemInput{
 		TableName: aws.String(ddb.TableName),
 		Item: map[string]*dynamodb.AttributeValue{
 			"UUID": {
@@ -144,26 +250,86 @@ func (ddb *dynamoDBBackend) Store(p *pipeline) error {
 			"Config": {
 				B: value,
 			},
+			"Revision": {
+				N: aws.String(strconv.Itoa(rev.Number)),
+			},
 		},
+		ConditionExpression: aws.String(condition),
+		ExpressionAttributeNames: map[string]*string{
+			"#revision": aws.String("Revision"),
+		},
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		return err
 	}
-	return try.Do(func(attempt int) (bool, error) {
-		_, err := ddb.svc.PutItem(dynamoValue)
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == dynamodb.ErrCodeProvisionedThroughputExceededException ||
-				awsErr.Code() == dynamodb.ErrCodeInternalServerError &&
-					attempt < ddb.Retries {
-				// Backoff time as recommended by https://docs.aws.amazon.com/general/latest/gr/api-retries.html
-				time.Sleep(time.Duration(2^attempt*100) * time.Millisecond)
-				return true, err
-			}
-		}
-		return false, err
+
+	return ddb.putItem(&dynamodb.PutItemInput{
+		TableName: aws.String(ddb.TableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"UUID": {
+				B: dynamoRevisionKey(key, rev.Number),
+			},
+			"Revision": {
+				B: revValue,
+			},
+		},
 	})
 }
 
 func (ddb *dynamoDBBackend) Get(uuid []byte) ([]byte, error) {
+	item, err := ddb.getItem(uuid)
+	if err != nil || item["Config"] == nil {
+		return nil, err
+	}
+	return item["Config"].B, nil
+}
+
+func (ddb *dynamoDBBackend) Revisions(uuid []byte) ([]revision, error) {
+	latest, err := ddb.getItem(uuid)
+	if err != nil || latest["Revision"] == nil || latest["Revision"].N == nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(*latest["Revision"].N)
+	if err != nil {
+		return nil, err
+	}
+	var revs []revision
+	for number := 1; number <= count; number++ {
+		rev, err := ddb.GetRevision(uuid, number)
+		if err != nil {
+			return nil, err
+		}
+		if rev != nil {
+			revs = append(revs, *rev)
+		}
+	}
+	return revs, nil
+}
+
+func (ddb *dynamoDBBackend) GetRevision(uuid []byte, number int) (*revision, error) {
+	item, err := ddb.getItem(dynamoRevisionKey(uuid, number))
+	if err != nil || item["Revision"] == nil {
+		return nil, err
+	}
+	rev := &revision{}
+	return rev, json.Unmarshal(item["Revision"].B, rev)
+}
+
+func dynamoRevisionKey(uuid []byte, number int) []byte {
+	return []byte(fmt.Sprintf("%s/%d", uuid, number))
+}
+
+func (ddb *dynamoDBBackend) putItem(input *dynamodb.PutItemInput) error {
+	return ddb.retry(func() error {
+		_, err := ddb.svc.PutItem(input)
+		return err
+	})
+}
+
+func (ddb *dynamoDBBackend) getItem(uuid []byte) (map[string]*dynamodb.AttributeValue, error) {
 	var item *dynamodb.GetItemOutput
-	err := try.Do(func(attempt int) (bool, error) {
+	err := ddb.retry(func() error {
 		var err error
 		item, err = ddb.svc.GetItem(&dynamodb.GetItemInput{
 			TableName: aws.String(ddb.TableName),
@@ -173,6 +339,17 @@ func (ddb *dynamoDBBackend) Get(uuid []byte) ([]byte, error) {
 				},
 			},
 		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item.Item, nil
+}
+
+func (ddb *dynamoDBBackend) retry(call func() error) error {
+	return try.Do(func(attempt int) (bool, error) {
+		err := call()
 		if awsErr, ok := err.(awserr.Error); ok {
 			if awsErr.Code() == dynamodb.ErrCodeProvisionedThroughputExceededException ||
 				awsErr.Code() == dynamodb.ErrCodeInternalServerError &&
@@ -184,5 +361,4 @@ func (ddb *dynamoDBBackend) Get(uuid []byte) ([]byte, error) {
 		}
 		return false, err
 	})
-	return item.Item["Config"].B, err
 }
@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
@@ -14,7 +15,9 @@ import (
 type mockDynamoDB struct {
 	dynamodbiface.DynamoDBAPI
 	tableExist bool
-	item       map[string]*dynamodb.AttributeValue
+	items      map[string]map[string]*dynamodb.AttributeValue
+	// failPut, when set, returns an error for the items it matches instead of storing them
+	failPut func(item map[string]*dynamodb.AttributeValue) error
 }
 
 func (m *mockDynamoDB) DescribeTable(*dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
@@ -25,13 +28,37 @@ func (m *mockDynamoDB) DescribeTable(*dynamodb.DescribeTableInput) (*dynamodb.De
 }
 
 func (m *mockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
-	m.item = input.Item
+	if m.items == nil {
+		m.items = make(map[string]map[string]*dynamodb.AttributeValue)
+	}
+	if m.failPut != nil {
+		if err := m.failPut(input.Item); err != nil {
+			return nil, err
+		}
+	}
+	key := string(input.Item["UUID"].B)
+	if input.ConditionExpression != nil && !m.conditionHolds(m.items[key], input) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	m.items[key] = input.Item
 	return nil, nil
 }
 
+// conditionHolds evaluates the few condition expressions the backend uses
+func (m *mockDynamoDB) conditionHolds(existing map[string]*dynamodb.AttributeValue, input *dynamodb.PutItemInput) bool {
+	switch *input.ConditionExpression {
+	case "attribute_not_exists(#revision)":
+		return existing == nil || existing["Revision"] == nil
+	case "#revision = :previous":
+		return existing != nil && existing["Revision"] != nil && existing["Revision"].N != nil &&
+			*existing["Revision"].N == *input.ExpressionAttributeValues[":previous"].N
+	}
+	return false
+}
+
 func (m *mockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
 	return &dynamodb.GetItemOutput{
-		Item: m.item,
+		Item: m.items[string(input.Key["UUID"].B)],
 	}, nil
 }
 
@@ -71,7 +98,7 @@ func TestDynamoGetStoredPipeline(t *testing.T) {
 		TableName: "go-fish",
 		Retries:   0,
 	}
-	err := backend.Store(&p)
+	err := backend.Store(&p, &revision{})
 	if err != nil {
 		t.Fatalf("Error storing pipeline %s", err)
 	}
@@ -83,3 +110,101 @@ func TestDynamoGetStoredPipeline(t *testing.T) {
 		t.Errorf("Expected config %s\nGot %s", pipelineConfig, storedBackend)
 	}
 }
+
+func TestDynamoPipelineRevisions(t *testing.T) {
+	id := uuid.New()
+	idVal, _ := id.MarshalText()
+	backend := &dynamoDBBackend{
+		svc: &mockDynamoDB{
+			tableExist: true,
+		},
+		TableName: "go-fish",
+	}
+	for _, config := range []string{`{"name":"a"}`, `{"name":"b"}`} {
+		rev := &revision{Author: "bob", Config: []byte(config)}
+		if err := backend.Store(&pipeline{ID: id, Config: []byte(config)}, rev); err != nil {
+			t.Fatalf("Error storing pipeline %s", err)
+		}
+	}
+
+	revisions, err := backend.Revisions(idVal)
+	if err != nil {
+		t.Fatalf("Error retrieving revisions %s", err)
+	}
+	if len(revisions) != 2 || revisions[0].Number != 1 || revisions[1].Number != 2 || revisions[1].Author != "bob" {
+		t.Errorf("Expected two numbered revisions, got %v", revisions)
+	}
+	rev, err := backend.GetRevision(idVal, 1)
+	if err != nil || rev == nil || string(rev.Config) != `{"name":"a"}` {
+		t.Errorf("Expected the first config, got %v %v", rev, err)
+	}
+	if rev, _ = backend.GetRevision(idVal, 3); rev != nil {
+		t.Errorf("Expected no third revision, got %v", rev)
+	}
+	if stored, _ := backend.Get(idVal); string(stored) != `{"name":"b"}` {
+		t.Errorf("Expected the latest config, got %s", stored)
+	}
+}
+
+func TestDynamoStoreFailedRevisionWrite(t *testing.T) {
+	id := uuid.New()
+	idVal, _ := id.MarshalText()
+	svc := &mockDynamoDB{tableExist: true}
+	backend := &dynamoDBBackend{
+		svc:       svc,
+		TableName: "go-fish",
+	}
+	store := func(config string) error {
+		return backend.Store(&pipeline{ID: id, Config: []byte(config)}, &revision{Author: "bob", Config: []byte(config)})
+	}
+	if err := store(`{"name":"a"}`); err != nil {
+		t.Fatalf("Error storing pipeline %s", err)
+	}
+
+	svc.failPut = func(item map[string]*dynamodb.AttributeValue) error {
+		if item["Config"] == nil {
+			return errors.New("Revision write failed")
+		}
+		return nil
+	}
+	if err := store(`{"name":"b"}`); err == nil {
+		t.Errorf("Expected the failed revision write to be returned")
+	}
+
+	svc.failPut = nil
+	if err := store(`{"name":"c"}`); err != nil {
+		t.Fatalf("Expected storing after a failed revision write to succeed, got %s", err)
+	}
+	if stored, _ := backend.Get(idVal); string(stored) != `{"name":"c"}` {
+		t.Errorf("Expected the latest config, got %s", stored)
+	}
+	revisions, err := backend.Revisions(idVal)
+	if err != nil {
+		t.Fatalf("Error retrieving revisions %s", err)
+	}
+	if len(revisions) != 2 || revisions[0].Number != 1 || revisions[1].Number != 3 {
+		t.Errorf("Expected revisions 1 and 3, got %v", revisions)
+	}
+}
+
+func TestDynamoStoreConcurrentRevision(t *testing.T) {
+	id := uuid.New()
+	idVal, _ := id.MarshalText()
+	svc := &mockDynamoDB{tableExist: true}
+	backend := &dynamoDBBackend{
+		svc:       svc,
+		TableName: "go-fish",
+	}
+	if err := backend.Store(&pipeline{ID: id, Config: []byte(`{"name":"a"}`)}, &revision{}); err != nil {
+		t.Fatalf("Error storing pipeline %s", err)
+	}
+	// Another writer stores revision 2 between our read and write
+	svc.failPut = func(item map[string]*dynamodb.AttributeValue) error {
+		svc.failPut = nil
+		svc.items[string(idVal)]["Revision"] = &dynamodb.AttributeValue{N: aws.String("2")}
+		return nil
+	}
+	if err := backend.Store(&pipeline{ID: id, Config: []byte(`{"name":"b"}`)}, &revision{}); err == nil {
+		t.Errorf("Expected a conflicting store to fail")
+	}
+}
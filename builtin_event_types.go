@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/patrobinson/go-fish/event"
+	"github.com/patrobinson/go-fish/schema"
+)
+
+// eventTypeConfig declares an event type decoded by go-fish itself, without a plugin
+type eventTypeConfig struct {
+	Type string `json:"type"`
+	// RequiredFields must be present for an event to match this type
+	RequiredFields []string `json:"requiredFields,omitempty"`
+	// FieldValues must be equal to the string value of the field for an event to match this type
+	FieldValues map[string]string `json:"fieldValues,omitempty"`
+	// Columns names the fields of a csv event, if Header is set they're read from the first line instead.
+	// If both are set the first line is skipped and Columns names the fields.
+	Columns   []string `json:"columns,omitempty"`
+	Header    bool     `json:"header,omitempty"`
+	Delimiter string   `json:"delimiter,omitempty"`
+	// Pattern is a regular expression whose named capture groups become the fields of a regex event
+	Pattern string `json:"pattern,omitempty"`
+	// Registry is where the schemas of avro and protobuf events are loaded from
+	Registry *schema.RegistryConfig `json:"registry,omitempty"`
+}
+
+func newBuiltinEventType(name string, config eventTypeConfig) (eventType, error) {
+	matcher := fieldMatcher{
+		requiredFields: config.RequiredFields,
+		fieldValues:    config.FieldValues,
+	}
+	switch config.Type {
+	case "json":
+		return &jsonEventType{
+			name:         name,
+			fieldMatcher: matcher,
+		}, nil
+	case "csv":
+		delimiter := ','
+		if config.Delimiter != "" {
+			runes := []rune(config.Delimiter)
+			if len(runes) != 1 {
+				return nil, fmt.Errorf("Invalid csv delimiter for %s: %s", name, config.Delimiter)
+			}
+			delimiter = runes[0]
+		}
+		if len(config.Columns) == 0 && !config.Header {
+			return nil, fmt.Errorf("Event type %s requires columns or a header", name)
+		}
+		return &csvEventType{
+			name:         name,
+			fieldMatcher: matcher,
+			columns:      config.Columns,
+			header:       config.Header,
+			delimiter:    delimiter,
+		}, nil
+	case "regex":
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern for %s: %s", name, err)
+		}
+		return &regexEventType{
+			name:         name,
+			fieldMatcher: matcher,
+			pattern:      pattern,
+		}, nil
+	case "logfmt":
+		return &logfmtEventType{
+			name:         name,
+			fieldMatcher: matcher,
+		}, nil
+	case "avro", "protobuf":
+		if config.Registry == nil {
+			return nil, fmt.Errorf("Event type %s requires a schema registry", name)
+		}
+		registry, err := schema.NewRegistry(*config.Registry)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid schema registry for %s: %s", name, err)
+		}
+		schemaType := schema.Avro
+		if config.Type == "protobuf" {
+			schemaType = schema.Protobuf
+		}
+		return &schemaEventType{
+			name:         name,
+			fieldMatcher: matcher,
+			registry:     registry,
+			schemaType:   schemaType,
+			decoders:     make(map[int]schemaDecoder),
+		}, nil
+	}
+	return nil, fmt.Errorf("Invalid event type for %s: %s", name, config.Type)
+}
+
+// createEventTypes creates the built in event types declared in the pipeline config, sorted by name
+func createEventTypes(configs map[string]eventTypeConfig) ([]eventType, error) {
+	var names []string
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var eventTypes []eventType
+	for _, name := range names {
+		et, err := newBuiltinEventType(name, configs[name])
+		if err != nil {
+			return nil, err
+		}
+		eventTypes = append(eventTypes, et)
+	}
+	return eventTypes, nil
+}
+
+// fieldMatcher checks a decoded event has the required fields and values
+type fieldMatcher struct {
+	requiredFields []string
+	fieldValues    map[string]string
+}
+
+func (m fieldMatcher) match(evt event.Generic) (event.Event, error) {
+	for _, field := range m.requiredFields {
+		if _, ok := evt.Get(field); !ok {
+			return nil, fmt.Errorf("Event is missing required field %s", field)
+		}
+	}
+	for field, expected := range m.fieldValues {
+		value, ok := evt.Get(field)
+		if !ok || fmt.Sprint(value) != expected {
+			return nil, fmt.Errorf("Event field %s is not %s", field, expected)
+		}
+	}
+	return evt, nil
+}
+
+type jsonEventType struct {
+	fieldMatcher
+	name string
+}
+
+func (j *jsonEventType) Name() string {
+	return j.name
+}
+
+func (j *jsonEventType) Decode(data []byte) (event.Event, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return nil, errors.New("Event is not a JSON object")
+	}
+	return j.match(event.Generic{
+		Type:   j.name,
+		Fields: fields,
+	})
+}
+
+type csvEventType struct {
+	fieldMatcher
+	sync.Mutex
+	name    string
+	columns []string
+	header  bool
+	// headerRead is set once the header has been decoded
+	headerRead bool
+	delimiter  rune
+}
+
+func (c *csvEventType) Name() string {
+	return c.name
+}
+
+// forSource copies an event type with a header, so that each source reads its own header
+func (c *csvEventType) forSource() *csvEventType {
+	return &csvEventType{
+		fieldMatcher: c.fieldMatcher,
+		name:         c.name,
+		columns:      c.columns,
+		header:       true,
+		delimiter:    c.delimiter,
+	}
+}
+
+// Decode decodes a single CSV record. When the event type has a header,
+// the first record decoded is the header and doesn't produce an event.
+func (c *csvEventType) Decode(data []byte) (event.Event, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = c.delimiter
+	reader.FieldsPerRecord = -1
+	record, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	if c.header && !c.headerRead {
+		c.headerRead = true
+		if c.columns == nil {
+			c.columns = record
+		}
+		c.Unlock()
+		return nil, errors.New("Read CSV header")
+	}
+	columns := c.columns
+	c.Unlock()
+
+	if len(record) != len(columns) {
+		return nil, fmt.Errorf("Expected %d CSV fields, got %d", len(columns), len(record))
+	}
+	fields := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		fields[column] = record[i]
+	}
+	return c.match(event.Generic{
+		Type:   c.name,
+		Fields: fields,
+	})
+}
+
+type regexEventType struct {
+	fieldMatcher
+	name    string
+	pattern *regexp.Regexp
+}
+
+func (r *regexEventType) Name() string {
+	return r.name
+}
+
+func (r *regexEventType) Decode(data []byte) (event.Event, error) {
+	match := r.pattern.FindSubmatch(data)
+	if match == nil {
+		return nil, fmt.Errorf("Event does not match %s", r.pattern)
+	}
+	fields := make(map[string]interface{})
+	for i, name := range r.pattern.SubexpNames() {
+		if name != "" {
+			fields[name] = string(match[i])
+		}
+	}
+	return r.match(event.Generic{
+		Type:   r.name,
+		Fields: fields,
+	})
+}
+
+type logfmtEventType struct {
+	fieldMatcher
+	name string
+}
+
+func (l *logfmtEventType) Name() string {
+	return l.name
+}
+
+func (l *logfmtEventType) Decode(data []byte) (event.Event, error) {
+	fields, err := parseLogfmt(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return l.match(event.Generic{
+		Type:   l.name,
+		Fields: fields,
+	})
+}
+
+// parseLogfmt parses space separated key=value pairs, values may be double quoted.
+// Keys without a value are set to true, but at least one key=value pair is required.
+func parseLogfmt(line string) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	pairs := 0
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' {
+			if line[i] == '"' {
+				return nil, fmt.Errorf("Invalid logfmt key at %d", i)
+			}
+			i++
+		}
+		key := line[start:i]
+		if i >= len(line) || line[i] != '=' {
+			fields[key] = true
+			continue
+		}
+		if key == "" {
+			return nil, fmt.Errorf("Invalid logfmt key at %d", i)
+		}
+		i++
+		pairs++
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("Unterminated logfmt value for %s", key)
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid logfmt value for %s: %v", key, err)
+			}
+			fields[key] = value
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		fields[key] = line[start:i]
+	}
+	if pairs == 0 {
+		return nil, errors.New("Event has no logfmt key=value pairs")
+	}
+	return fields, nil
+}
+
+type schemaDecoder interface {
+	Decode([]byte) (interface{}, error)
+}
+
+// schemaEventType decodes Avro or Protobuf events in the Confluent wire format,
+// a zero magic byte and the big endian schema ID followed by the encoded event
+type schemaEventType struct {
+	fieldMatcher
+	sync.Mutex
+	name       string
+	registry   schema.Registry
+	schemaType string
+	decoders   map[int]schemaDecoder
+}
+
+func (s *schemaEventType) Name() string {
+	return s.name
+}
+
+func (s *schemaEventType) Decode(data []byte) (event.Event, error) {
+	id, payload, err := schema.ParseWireFormat(data)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := s.decoder(id)
+	if err != nil {
+		return nil, err
+	}
+	value, err := decoder.Decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Schema %d does not decode to a record", id)
+	}
+	return s.match(event.Generic{
+		Type:   s.name,
+		Fields: fields,
+	})
+}
+
+// decoder returns the parsed schema for an ID, parsing it the first time it's seen
+func (s *schemaEventType) decoder(id int) (schemaDecoder, error) {
+	s.Lock()
+	defer s.Unlock()
+	if decoder, ok := s.decoders[id]; ok {
+		return decoder, nil
+	}
+
+	definition, err := s.registry.Schema(id)
+	if err != nil {
+		return nil, err
+	}
+	if definition.SchemaType != s.schemaType {
+		return nil, fmt.Errorf("Schema %d is %s, expected %s", id, definition.SchemaType, s.schemaType)
+	}
+	var decoder schemaDecoder
+	if s.schemaType == schema.Protobuf {
+		decoder, err = schema.ParseProtobuf(definition.Definition)
+	} else {
+		decoder, err = schema.ParseAvro(definition.Definition)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid schema %d: %s", id, err)
+	}
+	s.decoders[id] = decoder
+	return decoder, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/patrobinson/go-fish/event"
+	"github.com/patrobinson/go-fish/schema"
+)
+
+func TestJSONEventType(t *testing.T) {
+	et, err := newBuiltinEventType("cloudTrail", eventTypeConfig{
+		Type:           "json",
+		RequiredFields: []string{"eventID", "userIdentity.type"},
+		FieldValues:    map[string]string{"eventSource": "iam.amazonaws.com"},
+	})
+	if err != nil {
+		t.Fatalf("Error creating event type: %s", err)
+	}
+
+	evt, err := et.Decode([]byte(`{"eventID": "1", "eventSource": "iam.amazonaws.com", "userIdentity": {"type": "IAMUser"}}`))
+	if err != nil {
+		t.Fatalf("Expected event to match, got %s", err)
+	}
+	if evt.TypeName() != "cloudTrail" {
+		t.Errorf("Expected event type name cloudTrail, got %s", evt.TypeName())
+	}
+	if userType, _ := evt.(event.Generic).Get("userIdentity.type"); userType != "IAMUser" {
+		t.Errorf("Expected nested field to be IAMUser, got %v", userType)
+	}
+
+	invalid := []string{
+		`{"eventID": "1", "eventSource": "iam.amazonaws.com"}`,
+		`{"eventID": "1", "eventSource": "s3.amazonaws.com", "userIdentity": {"type": "IAMUser"}}`,
+		`["eventID"]`,
+		`a`,
+	}
+	for _, data := range invalid {
+		if _, err := et.Decode([]byte(data)); err == nil {
+			t.Errorf("Expected %s not to match", data)
+		}
+	}
+}
+
+func TestNewPipelineWithInvalidEventType(t *testing.T) {
+	err := validateConfig(pipelineConfig{
+		EventTypes: map[string]eventTypeConfig{
+			"cloudTrail": {Type: "xml"},
+		},
+	})
+	if err == nil || err.Error() != "Invalid event type for cloudTrail: xml" {
+		t.Errorf("Expected pipeline with invalid event type to raise error, but got %v", err)
+	}
+}
+
+func decodeFields(t *testing.T, et eventType, data string) map[string]interface{} {
+	evt, err := et.Decode([]byte(data))
+	if err != nil {
+		t.Fatalf("Expected %s to decode, got %s", data, err)
+	}
+	return evt.(event.Generic).Fields
+}
+
+func TestCSVEventType(t *testing.T) {
+	et, err := newBuiltinEventType("logins", eventTypeConfig{Type: "csv", Header: true})
+	if err != nil {
+		t.Fatalf("Error creating event type: %s", err)
+	}
+	if _, err := et.Decode([]byte("user,ip")); err == nil {
+		t.Error("Expected the header not to be decoded as an event")
+	}
+	fields := decodeFields(t, et, `bob,"10.0.0.1"`)
+	if fields["user"] != "bob" || fields["ip"] != "10.0.0.1" {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+	if _, err := et.Decode([]byte("bob,10.0.0.1,extra")); err == nil {
+		t.Error("Expected a record with the wrong number of fields not to match")
+	}
+
+	renamed, err := newBuiltinEventType("logins", eventTypeConfig{Type: "csv", Header: true, Columns: []string{"login", "address"}})
+	if err != nil {
+		t.Fatalf("Error creating event type: %s", err)
+	}
+	if _, err := renamed.Decode([]byte("user,ip")); err == nil {
+		t.Error("Expected the header to be skipped when columns are set")
+	}
+	fields = decodeFields(t, renamed, "bob,10.0.0.1")
+	if fields["login"] != "bob" || fields["address"] != "10.0.0.1" {
+		t.Errorf("Expected the fields to be named by the columns, got %v", fields)
+	}
+
+	et, _ = newBuiltinEventType("logins", eventTypeConfig{Type: "csv", Columns: []string{"user", "ip"}, Delimiter: "|"})
+	if fields := decodeFields(t, et, "alice|10.0.0.2"); fields["user"] != "alice" {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+}
+
+func TestRegexEventType(t *testing.T) {
+	et, err := newBuiltinEventType("apacheAccess", eventTypeConfig{
+		Type:    "regex",
+		Pattern: `^(?P<ip>\S+) \S+ (?P<user>\S+) \[(?P<time>[^\]]+)\] "(?P<method>\S+) (?P<path>\S+) [^"]*" (?P<status>\d+)`,
+	})
+	if err != nil {
+		t.Fatalf("Error creating event type: %s", err)
+	}
+	fields := decodeFields(t, et, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`)
+	if fields["ip"] != "127.0.0.1" || fields["user"] != "frank" || fields["path"] != "/apache_pb.gif" || fields["status"] != "200" {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+	if _, err := et.Decode([]byte("not an access log")); err == nil {
+		t.Error("Expected a line that doesn't match the pattern not to be decoded")
+	}
+}
+
+func TestLogfmtEventType(t *testing.T) {
+	et, err := newBuiltinEventType("app", eventTypeConfig{
+		Type:        "logfmt",
+		FieldValues: map[string]string{"level": "error"},
+	})
+	if err != nil {
+		t.Fatalf("Error creating event type: %s", err)
+	}
+	fields := decodeFields(t, et, `level=error msg="login \"failed\"" user=bob retry`)
+	if fields["msg"] != `login "failed"` || fields["user"] != "bob" || fields["retry"] != true {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+	for _, data := range []string{`level=info msg=ok`, `just some text`, `level="error`} {
+		if _, err := et.Decode([]byte(data)); err == nil {
+			t.Errorf("Expected %s not to match", data)
+		}
+	}
+}
+
+func TestAvroEventType(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			fmt.Fprint(w, `{"schema": "{\"type\": \"record\", \"name\": \"Login\", \"fields\": [{\"name\": \"user\", \"type\": \"string\"}]}"}`)
+		case "/schemas/ids/2":
+			fmt.Fprint(w, `{"schema": "syntax = \"proto3\";", "schemaType": "PROTOBUF"}`)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer registry.Close()
+
+	et, err := newBuiltinEventType("logins", eventTypeConfig{
+		Type:        "avro",
+		FieldValues: map[string]string{"user": "bob"},
+		Registry:    &schema.RegistryConfig{URL: registry.URL},
+	})
+	if err != nil {
+		t.Fatalf("Error creating event type: %s", err)
+	}
+	if fields := decodeFields(t, et, "\x00\x00\x00\x00\x01\x06bob"); fields["user"] != "bob" {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+
+	invalid := []string{
+		"\x00\x00\x00\x00\x01\x0aalice",
+		"\x00\x00\x00\x00\x02\x00",
+		"\x00\x00\x00\x00\x03\x06bob",
+		`{"user": "bob"}`,
+	}
+	for _, data := range invalid {
+		if _, err := et.Decode([]byte(data)); err == nil {
+			t.Errorf("Expected %q not to match", data)
+		}
+	}
+
+	if _, err := newBuiltinEventType("alerts", eventTypeConfig{Type: "protobuf"}); err == nil {
+		t.Error("Expected protobuf event type without a registry to raise an error")
+	}
+}
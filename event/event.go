@@ -1,6 +1,37 @@
 package event
 
+import (
+	"strings"
+)
+
 // Event is the interface event structures must implement
 type Event interface {
 	TypeName() string
 }
+
+// Generic is an event decoded by one of the built in event types into a map of fields
+type Generic struct {
+	Type   string
+	Fields map[string]interface{}
+}
+
+// TypeName returns the name of the event type that decoded the event
+func (g Generic) TypeName() string {
+	return g.Type
+}
+
+// Get returns the value of a field, nested fields are referenced with a dot separated path e.g. userIdentity.type
+func (g Generic) Get(path string) (interface{}, bool) {
+	var value interface{} = g.Fields
+	for _, key := range strings.Split(path, ".") {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = fields[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"path"
 	"path/filepath"
 	"plugin"
 
 	"github.com/patrobinson/go-fish/event"
+	"github.com/patrobinson/go-fish/input"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -17,6 +21,9 @@ type eventType interface {
 
 func getEventTypes(eventFolder string) ([]eventType, error) {
 	var events []eventType
+	if eventFolder == "" {
+		return events, nil
+	}
 
 	evtGlob := path.Join(eventFolder, "/*.so")
 	evt, err := filepath.Glob(evtGlob)
@@ -49,17 +56,129 @@ func getEventTypes(eventFolder string) ([]eventType, error) {
 	return events, nil
 }
 
-func matchEventType(eventTypes []eventType, rawEvt interface{}) (event.Event, error) {
-	var evt event.Event
-	for _, et := range eventTypes {
-		byteEvt, ok := rawEvt.([]byte)
-		if !ok {
-			return evt, errors.New("Could not decode raw event to byte array")
+// eventTypeRouter decodes the records of a source as one of the event types it carries
+type eventTypeRouter struct {
+	eventTypes    []eventType
+	byName        map[string]eventType
+	discriminator input.DiscriminatorConfig
+}
+
+func newEventTypeRouter(eventTypes []eventType, config input.SourceConfig) (*eventTypeRouter, error) {
+	router := &eventTypeRouter{
+		byName:        make(map[string]eventType),
+		discriminator: config.Discriminator,
+	}
+	sourceEventTypes := make([]eventType, len(eventTypes))
+	for i, et := range eventTypes {
+		if csv, ok := et.(*csvEventType); ok && csv.header {
+			et = csv.forSource()
+		}
+		sourceEventTypes[i] = et
+		if _, ok := router.byName[et.Name()]; !ok {
+			router.byName[et.Name()] = et
+		}
+	}
+
+	router.eventTypes = sourceEventTypes
+	if len(config.EventTypes) > 0 {
+		router.eventTypes = nil
+		for _, name := range config.EventTypes {
+			et, ok := router.byName[name]
+			if !ok {
+				return nil, fmt.Errorf("Source carries unknown event type %s", name)
+			}
+			router.eventTypes = append(router.eventTypes, et)
+		}
+	}
+
+	switch config.Discriminator.Type {
+	case "", "prefix":
+	case "field":
+		if config.Discriminator.Field == "" {
+			return nil, errors.New("Field discriminator requires a field")
+		}
+	default:
+		return nil, fmt.Errorf("Invalid discriminator type %s", config.Discriminator.Type)
+	}
+	// A csv header must come from the source's records of that event type, rather than whichever record is tried first
+	if config.Discriminator.Type == "" && len(router.eventTypes) > 1 {
+		for _, et := range router.eventTypes {
+			if csv, ok := et.(*csvEventType); ok && csv.header {
+				return nil, fmt.Errorf("Event type %s has a header, so it must be the source's only event type or be selected by a discriminator", et.Name())
+			}
+		}
+	}
+	for _, name := range config.Discriminator.Values {
+		if _, ok := router.byName[name]; !ok {
+			return nil, fmt.Errorf("Discriminator selects unknown event type %s", name)
+		}
+	}
+	if name := config.Discriminator.Default; name != "" {
+		if _, ok := router.byName[name]; !ok {
+			return nil, fmt.Errorf("Discriminator selects unknown event type %s", name)
+		}
+	}
+	return router, nil
+}
+
+// discriminate returns the name of the event type selected by the discriminator
+func (r *eventTypeRouter) discriminate(rawEvt []byte) string {
+	switch r.discriminator.Type {
+	case "field":
+		var fields map[string]interface{}
+		if err := json.Unmarshal(rawEvt, &fields); err == nil {
+			if value, ok := (event.Generic{Fields: fields}).Get(r.discriminator.Field); ok {
+				if name, ok := r.discriminator.Values[fmt.Sprint(value)]; ok {
+					return name
+				}
+			}
 		}
+	case "prefix":
+		longest := -1
+		var selected string
+		for prefix, name := range r.discriminator.Values {
+			if len(prefix) > longest && bytes.HasPrefix(rawEvt, []byte(prefix)) {
+				longest = len(prefix)
+				selected = name
+			}
+		}
+		if longest >= 0 {
+			return selected
+		}
+	}
+	return r.discriminator.Default
+}
+
+// match decodes the event with the type selected by the discriminator,
+// or the first of the source's event types to successfully decode it
+func (r *eventTypeRouter) match(rawEvt interface{}, pipelineName string, mService monitoringService) (event.Event, error) {
+	byteEvt, ok := rawEvt.([]byte)
+	if !ok {
+		return nil, errors.New("Could not decode raw event to byte array")
+	}
+
+	if r.discriminator.Type != "" {
+		name := r.discriminate(byteEvt)
+		if name == "" {
+			mService.incrDecodeFailure(pipelineName, "")
+			return nil, errors.New("No Event Type selected by discriminator")
+		}
+		evt, err := r.byName[name].Decode(byteEvt)
+		if err != nil {
+			mService.incrDecodeFailure(pipelineName, name)
+			return nil, fmt.Errorf("Error decoding event as %s: %v", name, err)
+		}
+		mService.incrEventTypeMatched(pipelineName, name)
+		return evt, nil
+	}
+
+	for _, et := range r.eventTypes {
 		if evt, err := et.Decode(byteEvt); err == nil {
 			log.Debugf("Matched event to type %s", et.Name())
+			mService.incrEventTypeMatched(pipelineName, et.Name())
 			return evt, nil
 		}
 	}
-	return evt, errors.New("No Event Type matched")
+	mService.incrDecodeFailure(pipelineName, "")
+	return nil, errors.New("No Event Type matched")
 }
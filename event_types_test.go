@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/patrobinson/go-fish/event"
+	"github.com/patrobinson/go-fish/input"
+)
+
+func makeTestEventTypes(t *testing.T) []eventType {
+	eventTypes, err := createEventTypes(map[string]eventTypeConfig{
+		"iamEvent": {Type: "json", FieldValues: map[string]string{"eventSource": "iam.amazonaws.com"}},
+		"s3Event":  {Type: "json"},
+		"logins":   {Type: "csv", Columns: []string{"user", "ip"}},
+	})
+	if err != nil {
+		t.Fatalf("Error creating event types: %s", err)
+	}
+	return eventTypes
+}
+
+func TestEventTypeRouterSourceEventTypes(t *testing.T) {
+	router, err := newEventTypeRouter(makeTestEventTypes(t), input.SourceConfig{
+		EventTypes: []string{"logins"},
+	})
+	if err != nil {
+		t.Fatalf("Error creating router: %s", err)
+	}
+	mService := makeMonitoringService()
+	if _, err := router.match([]byte(`{"eventSource": "s3.amazonaws.com"}`), "test", mService); err == nil {
+		t.Error("Expected event types the source doesn't carry not to be tried")
+	}
+	evt, err := router.match([]byte("bob,10.0.0.1"), "test", mService)
+	if err != nil || evt.TypeName() != "logins" {
+		t.Errorf("Expected event to be decoded as logins, got %v %v", evt, err)
+	}
+}
+
+func TestEventTypeRouterFieldDiscriminator(t *testing.T) {
+	router, err := newEventTypeRouter(makeTestEventTypes(t), input.SourceConfig{
+		Discriminator: input.DiscriminatorConfig{
+			Type:  "field",
+			Field: "eventSource",
+			Values: map[string]string{
+				"iam.amazonaws.com": "iamEvent",
+				"s3.amazonaws.com":  "s3Event",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error creating router: %s", err)
+	}
+	mService := makeMonitoringService()
+	evt, err := router.match([]byte(`{"eventSource": "s3.amazonaws.com"}`), "test", mService)
+	if err != nil || evt.TypeName() != "s3Event" {
+		t.Errorf("Expected event to be decoded as s3Event, got %v %v", evt, err)
+	}
+	if _, err := router.match([]byte(`{"eventSource": "ec2.amazonaws.com"}`), "test", mService); err == nil {
+		t.Error("Expected an event the discriminator has no value for not to be decoded")
+	}
+}
+
+func TestEventTypeRouterPrefixDiscriminator(t *testing.T) {
+	router, err := newEventTypeRouter(makeTestEventTypes(t), input.SourceConfig{
+		Discriminator: input.DiscriminatorConfig{
+			Type:    "prefix",
+			Values:  map[string]string{"{": "s3Event", `{"eventSource":"iam`: "iamEvent"},
+			Default: "logins",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error creating router: %s", err)
+	}
+	mService := makeMonitoringService()
+	cases := map[string]string{
+		`{"eventSource":"iam.amazonaws.com"}`: "iamEvent",
+		`{"eventSource":"s3.amazonaws.com"}`:  "s3Event",
+		`alice,10.0.0.2`:                      "logins",
+	}
+	for data, expected := range cases {
+		evt, err := router.match([]byte(data), "test", mService)
+		if err != nil || evt.TypeName() != expected {
+			t.Errorf("Expected %s to be decoded as %s, got %v %v", data, expected, evt, err)
+		}
+	}
+}
+
+func TestEventTypeRouterUnknownEventType(t *testing.T) {
+	configs := []input.SourceConfig{
+		{EventTypes: []string{"cloudTrail"}},
+		{Discriminator: input.DiscriminatorConfig{Type: "prefix", Values: map[string]string{"{": "cloudTrail"}}},
+		{Discriminator: input.DiscriminatorConfig{Type: "header"}},
+	}
+	for _, config := range configs {
+		if _, err := newEventTypeRouter(makeTestEventTypes(t), config); err == nil {
+			t.Errorf("Expected source config %v to be invalid", config)
+		}
+	}
+}
+
+func TestEventTypeRouterCSVHeader(t *testing.T) {
+	eventTypes, err := createEventTypes(map[string]eventTypeConfig{
+		"s3Event": {Type: "json"},
+		"logins":  {Type: "csv", Header: true},
+	})
+	if err != nil {
+		t.Fatalf("Error creating event types: %s", err)
+	}
+	if _, err := newEventTypeRouter(eventTypes, input.SourceConfig{}); err == nil {
+		t.Error("Expected a csv event type with a header to be invalid when every event type is tried")
+	}
+
+	mService := makeMonitoringService()
+	sourceConfig := input.SourceConfig{EventTypes: []string{"logins"}}
+	firstSource, err := newEventTypeRouter(eventTypes, sourceConfig)
+	if err != nil {
+		t.Fatalf("Error creating router: %s", err)
+	}
+	secondSource, err := newEventTypeRouter(eventTypes, sourceConfig)
+	if err != nil {
+		t.Fatalf("Error creating router: %s", err)
+	}
+	firstSource.match([]byte("user,ip"), "test", mService)
+	secondSource.match([]byte("ip,user"), "test", mService)
+	first, err := firstSource.match([]byte("bob,10.0.0.1"), "test", mService)
+	if err != nil || first.(event.Generic).Fields["user"] != "bob" {
+		t.Errorf("Expected the first source to use its own header, got %v %v", first, err)
+	}
+	second, err := secondSource.match([]byte("10.0.0.2,alice"), "test", mService)
+	if err != nil || second.(event.Generic).Fields["user"] != "alice" {
+		t.Errorf("Expected the second source to use its own header, got %v %v", second, err)
+	}
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/patrobinson/go-fish/event"
+)
+
+// expression is a boolean condition over the fields of an event, parsed by parseExpression.
+//
+// Fields are referenced by dot separated paths e.g. userIdentity.type, which look up map keys and
+// struct fields by name or json tag. A path through a list looks up the rest of the path in each item.
+// Literals are double or single quoted strings, numbers, lists e.g. ["a", "b"], true, false and null.
+// Operators are == != < <= > >=, =~ and !~ to match a regular expression, in and not in to
+// test membership of a list or a substring of a string, and and, or and not, or && || and !.
+// A list on the left of =~ or !~ matches if any item matches.
+type expression interface {
+	eval(evt interface{}) interface{}
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (l literal) eval(interface{}) interface{} {
+	return l.value
+}
+
+type fieldRef struct {
+	path string
+}
+
+func (f fieldRef) eval(evt interface{}) interface{} {
+	value, _ := lookupField(evt, f.path)
+	return value
+}
+
+type listExpr []expression
+
+func (l listExpr) eval(evt interface{}) interface{} {
+	values := make([]interface{}, len(l))
+	for i, item := range l {
+		values[i] = item.eval(evt)
+	}
+	return values
+}
+
+type notExpr struct {
+	operand expression
+}
+
+func (n notExpr) eval(evt interface{}) interface{} {
+	return !truthy(n.operand.eval(evt))
+}
+
+type logicalExpr struct {
+	and         bool
+	left, right expression
+}
+
+func (l logicalExpr) eval(evt interface{}) interface{} {
+	left := truthy(l.left.eval(evt))
+	if left != l.and {
+		return left
+	}
+	return truthy(l.right.eval(evt))
+}
+
+type comparison struct {
+	op          string
+	left, right expression
+	pattern     *regexp.Regexp
+}
+
+func (c comparison) eval(evt interface{}) interface{} {
+	left := c.left.eval(evt)
+	switch c.op {
+	case "=~":
+		return c.matches(left)
+	case "!~":
+		return !c.matches(left)
+	}
+
+	right := c.right.eval(evt)
+	switch c.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	case "in":
+		return contains(right, left)
+	case "not in":
+		return !contains(right, left)
+	}
+
+	result, ok := compare(left, right)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	}
+	return result >= 0
+}
+
+func (c comparison) matches(value interface{}) bool {
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			if c.matches(item) {
+				return true
+			}
+		}
+		return false
+	}
+	if value == nil {
+		return false
+	}
+	return c.pattern.MatchString(stringValue(value))
+}
+
+// compare orders two numbers or two strings, other values can't be ordered
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	x, ok := a.(string)
+	y, ok2 := b.(string)
+	if !ok || !ok2 {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	}
+	if n, ok := toNumber(value); ok {
+		return n != 0
+	}
+	return true
+}
+
+func equal(a, b interface{}) bool {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		return ok && x == y
+	}
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return stringValue(a) == stringValue(b)
+}
+
+// contains tests if value is an item of a list or a substring of a string
+func contains(container, value interface{}) bool {
+	switch c := container.(type) {
+	case []interface{}:
+		for _, item := range c {
+			if equal(item, value) {
+				return true
+			}
+		}
+	case string:
+		return value != nil && strings.Contains(c, stringValue(value))
+	}
+	return false
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// stringValue formats a field value as a string, without exponents for large numbers
+func stringValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if n, ok := toNumber(value); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// lookupField returns the value of the field at a dot separated path in an event
+func lookupField(evt interface{}, path string) (interface{}, bool) {
+	if g, ok := evt.(event.Generic); ok {
+		evt = g.Fields
+	}
+	return lookupValue(reflect.ValueOf(evt), strings.Split(path, "."))
+}
+
+func lookupValue(v reflect.Value, path []string) (interface{}, bool) {
+	for i, key := range path {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil, false
+			}
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return nil, false
+			}
+			v = v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+		case reflect.Struct:
+			v = structField(v, key)
+		case reflect.Slice, reflect.Array:
+			if index, err := strconv.Atoi(key); err == nil {
+				if index < 0 || index >= v.Len() {
+					return nil, false
+				}
+				v = v.Index(index)
+				continue
+			}
+			values := []interface{}{}
+			for j := 0; j < v.Len(); j++ {
+				if value, ok := lookupValue(v.Index(j), path[i:]); ok {
+					values = append(values, value)
+				}
+			}
+			return values, true
+		default:
+			return nil, false
+		}
+		if !v.IsValid() {
+			return nil, false
+		}
+	}
+	return toValue(v), true
+}
+
+// structField finds an exported field by its name or json tag, falling back to a case insensitive match of the name
+func structField(v reflect.Value, name string) reflect.Value {
+	t := v.Type()
+	fallback := -1
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Name == name || tag == name {
+			return v.Field(i)
+		}
+		if fallback < 0 && strings.EqualFold(field.Name, name) {
+			fallback = i
+		}
+	}
+	if fallback < 0 {
+		return reflect.Value{}
+	}
+	return v.Field(fallback)
+}
+
+// toValue converts a value found by reflection, lists are converted to []interface{} so they can be compared
+func toValue(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8 {
+		values := make([]interface{}, v.Len())
+		for i := range values {
+			values[i] = toValue(v.Index(i))
+		}
+		return values
+	}
+	return v.Interface()
+}
+
+type expressionToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeExpression(source string) ([]expressionToken, error) {
+	var tokens []expressionToken
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(source) && source[end] != c {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("Unterminated string at %d", i)
+			}
+			text := source[i+1 : end]
+			if c == '"' {
+				var err error
+				if text, err = strconv.Unquote(source[i : end+1]); err != nil {
+					return nil, fmt.Errorf("Invalid string at %d: %v", i, err)
+				}
+			} else {
+				text = strings.Replace(text, `\'`, `'`, -1)
+			}
+			tokens = append(tokens, expressionToken{text: text, quoted: true})
+			i = end + 1
+		case isIdentifier(c) || c == '-' && i+1 < len(source) && unicode.IsDigit(rune(source[i+1])):
+			start := i
+			for i++; i < len(source) && isIdentifier(source[i]); i++ {
+			}
+			tokens = append(tokens, expressionToken{text: source[start:i]})
+		default:
+			operator := string(c)
+			if i+1 < len(source) && twoCharOperators[source[i:i+2]] {
+				operator = source[i : i+2]
+			} else if !strings.ContainsRune("!<>()[],", rune(c)) {
+				return nil, fmt.Errorf("Unexpected %q at %d", operator, i)
+			}
+			tokens = append(tokens, expressionToken{text: operator})
+			i += len(operator)
+		}
+	}
+	return tokens, nil
+}
+
+var twoCharOperators = map[string]bool{
+	"==": true, "!=": true, "<=": true, ">=": true, "=~": true, "!~": true, "&&": true, "||": true,
+}
+
+func isIdentifier(c byte) bool {
+	return c == '_' || c == '.' || c == '$' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+type expressionParser struct {
+	tokens []expressionToken
+	pos    int
+}
+
+// parseExpression parses a condition, see expression for the syntax
+func parseExpression(source string) (expression, error) {
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &expressionParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("Unexpected %q", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+// peek returns the next unquoted token
+func (p *expressionParser) peek() string {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].quoted {
+		return ""
+	}
+	return p.tokens[p.pos].text
+}
+
+func (p *expressionParser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" || p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpr{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseAnd() (expression, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" || p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpr{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseNot() (expression, error) {
+	if p.peek() == "not" || p.peek() == "!" {
+		p.pos++
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *expressionParser) parseComparison() (expression, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "in":
+		p.pos++
+	case "not":
+		if p.pos+1 >= len(p.tokens) || p.tokens[p.pos+1].text != "in" {
+			return left, nil
+		}
+		op = "not in"
+		p.pos += 2
+	case "=~", "!~":
+		p.pos++
+		if p.pos >= len(p.tokens) || !p.tokens[p.pos].quoted {
+			return nil, fmt.Errorf("Expected a regular expression string after %s", op)
+		}
+		pattern, err := regexp.Compile(p.tokens[p.pos].text)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression: %v", err)
+		}
+		p.pos++
+		return comparison{op: op, left: left, pattern: pattern}, nil
+	default:
+		return left, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return comparison{op: op, left: left, right: right}, nil
+}
+
+func (p *expressionParser) parseOperand() (expression, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("Unexpected end of expression")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	if token.quoted {
+		return literal{token.text}, nil
+	}
+	switch token.text {
+	case "(":
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("Expected )")
+		}
+		p.pos++
+		return expr, nil
+	case "[":
+		list := listExpr{}
+		for p.peek() != "]" {
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			if p.peek() == "," {
+				p.pos++
+			} else if p.peek() != "]" {
+				return nil, fmt.Errorf("Expected , or ] in list")
+			}
+		}
+		p.pos++
+		return list, nil
+	case "true":
+		return literal{true}, nil
+	case "false":
+		return literal{false}, nil
+	case "null":
+		return literal{nil}, nil
+	}
+	if c := token.text[0]; c == '-' || unicode.IsDigit(rune(c)) {
+		n, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number %s", token.text)
+		}
+		return literal{n}, nil
+	}
+	if !isIdentifier(token.text[0]) {
+		return nil, fmt.Errorf("Unexpected %q", token.text)
+	}
+	return fieldRef{path: token.text}, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/patrobinson/go-fish/event"
+	"github.com/patrobinson/go-fish/output"
+	"github.com/patrobinson/go-fish/ruleHelpers"
+)
+
+// expressionOutput is the template of the OutputEvent created when an expression rule's condition is true.
+// Name, Level, Source and EventType are literal values, the other fields are paths to event fields.
+type expressionOutput struct {
+	Name  string `json:"name"`
+	Level string `json:"level,omitempty"`
+	// Source defaults to the name of the rule
+	Source string `json:"source,omitempty"`
+	// EventType defaults to the TypeName() of the event
+	EventType string `json:"eventType,omitempty"`
+	Entity    string `json:"entity,omitempty"`
+	EventId   string `json:"eventId,omitempty"`
+	SourceIP  string `json:"sourceIP,omitempty"`
+	// EventTime is a time, unix timestamp or RFC3339 string field, defaulting to the time the event is processed
+	EventTime string `json:"eventTime,omitempty"`
+	// Body maps keys of the OutputEvent Body to event fields
+	Body map[string]string `json:"body,omitempty"`
+}
+
+// expressionRule is a stateless rule that outputs an event whenever its condition is true
+type expressionRule struct {
+	rulehelpers.BasicRule
+	name      string
+	condition expression
+	output    expressionOutput
+	level     output.Level
+}
+
+func newExpressionRule(name string, config ruleConfig) (*expressionRule, error) {
+	if config.Output == nil || config.Output.Name == "" {
+		return nil, errors.New("Expression rule requires an output name")
+	}
+	condition, err := parseExpression(config.Condition)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid condition: %v", err)
+	}
+	level := output.InfoLevel
+	if config.Output.Level != "" {
+		if level, err = output.ParseLevel(config.Output.Level); err != nil {
+			return nil, err
+		}
+	}
+	return &expressionRule{
+		name:      name,
+		condition: condition,
+		output:    *config.Output,
+		level:     level,
+	}, nil
+}
+
+func (r *expressionRule) Process(evt interface{}) interface{} {
+	if !truthy(r.condition.eval(evt)) {
+		return nil
+	}
+	outputEvent := output.OutputEvent{
+		Source:    r.output.Source,
+		EventTime: time.Now(),
+		EventType: r.output.EventType,
+		Name:      r.output.Name,
+		Level:     r.level,
+		Entity:    fieldString(evt, r.output.Entity),
+		EventId:   fieldString(evt, r.output.EventId),
+		SourceIP:  fieldString(evt, r.output.SourceIP),
+	}
+	if outputEvent.Source == "" {
+		outputEvent.Source = r.name
+	}
+	if e, ok := evt.(event.Event); ok && outputEvent.EventType == "" {
+		outputEvent.EventType = e.TypeName()
+	}
+	if eventTime, ok := fieldTime(evt, r.output.EventTime); ok {
+		outputEvent.EventTime = eventTime
+	}
+	if len(r.output.Body) > 0 {
+		outputEvent.Body = make(map[string]interface{}, len(r.output.Body))
+		for key, path := range r.output.Body {
+			if value, ok := lookupField(evt, path); ok {
+				outputEvent.Body[key] = value
+			}
+		}
+	}
+	return outputEvent
+}
+
+func (r *expressionRule) String() string {
+	return r.name
+}
+
+func fieldString(evt interface{}, path string) string {
+	if path == "" {
+		return ""
+	}
+	value, _ := lookupField(evt, path)
+	return stringValue(value)
+}
+
+func fieldTime(evt interface{}, path string) (time.Time, bool) {
+	if path == "" {
+		return time.Time{}, false
+	}
+	value, _ := lookupField(evt, path)
+	if t, ok := value.(time.Time); ok {
+		return t, true
+	}
+	if seconds, ok := toNumber(value); ok {
+		return time.Unix(0, int64(seconds*float64(time.Second))), true
+	}
+	if s, ok := value.(string); ok {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
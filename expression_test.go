@@ -0,0 +1,161 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/patrobinson/go-fish/event"
+	"github.com/patrobinson/go-fish/output"
+)
+
+type certificate struct {
+	MessageType string `json:"message_type"`
+	Data        struct {
+		Seen       float64
+		AllDomains []string `json:"all_domains"`
+		Chain      []struct{ CN string }
+	} `json:"data"`
+}
+
+func (c certificate) TypeName() string { return "certStream" }
+
+func TestExpressionEval(t *testing.T) {
+	evt := event.Generic{Type: "cloudTrail", Fields: map[string]interface{}{
+		"eventName":    "ConsoleLogin",
+		"errorCode":    nil,
+		"userIdentity": map[string]interface{}{"type": "IAMUser", "userName": "bob"},
+		"responseElements": map[string]interface{}{
+			"ConsoleLogin": "Failure",
+		},
+		"additionalEventData": map[string]interface{}{"MFAUsed": "No"},
+		"count":               float64(3),
+		"tags":                []interface{}{"prod", "web"},
+	}}
+
+	expressions := map[string]bool{
+		`eventName == "ConsoleLogin"`:                                            true,
+		`eventName != 'ConsoleLogin'`:                                            false,
+		`userIdentity.type == "IAMUser" and additionalEventData.MFAUsed == "No"`: true,
+		`userIdentity.type == "Root" or count >= 3`:                              true,
+		`count < 3 || count > 3`:                                                 false,
+		`count == 3.0 && count <= -1`:                                            false,
+		`not (count > 5)`:                                                        true,
+		`!responseElements.ConsoleLogin`:                                         false,
+		`userIdentity.userName =~ "^b.b$"`:                                       true,
+		`userIdentity.userName !~ "^b"`:                                          false,
+		`userIdentity.userName in ["alice", "bob"]`:                              true,
+		`userIdentity.userName not in ["alice", "bob"]`:                          false,
+		`"we" in tags`:                                                           false,
+		`"web" in tags`:                                                          true,
+		`"Con" in eventName`:                                                     true,
+		`tags =~ "^pr"`:                                                          true,
+		`errorCode == null and missing == null`:                                  true,
+		`missing`:                                                                false,
+		`eventName > "A"`:                                                        true,
+		`count > "A"`:                                                            false,
+	}
+	for source, expected := range expressions {
+		expr, err := parseExpression(source)
+		if err != nil {
+			t.Errorf("Error parsing %s: %s", source, err)
+			continue
+		}
+		if result := truthy(expr.eval(evt)); result != expected {
+			t.Errorf("Expected %s to be %t", source, expected)
+		}
+	}
+}
+
+func TestExpressionStructFields(t *testing.T) {
+	cert := certificate{MessageType: "certificate_update"}
+	cert.Data.AllDomains = []string{"example.com", "www.example.com"}
+	cert.Data.Chain = []struct{ CN string }{{CN: "Let's Encrypt"}, {CN: "ISRG Root"}}
+
+	expressions := map[string]bool{
+		`MessageType != "heartbeat" and Data.AllDomains =~ "^www.*"`: true,
+		`message_type == "certificate_update"`:                       true,
+		`data.all_domains.0 == "example.com"`:                        true,
+		`"ISRG Root" in Data.Chain.CN`:                               true,
+		`Data.Missing == null`:                                       true,
+	}
+	for source, expected := range expressions {
+		expr, err := parseExpression(source)
+		if err != nil {
+			t.Errorf("Error parsing %s: %s", source, err)
+			continue
+		}
+		if result := truthy(expr.eval(&cert)); result != expected {
+			t.Errorf("Expected %s to be %t", source, expected)
+		}
+	}
+}
+
+func TestInvalidExpressions(t *testing.T) {
+	for _, source := range []string{
+		`eventName ==`,
+		`eventName = "a"`,
+		`(eventName == "a"`,
+		`eventName == "a" "b"`,
+		`eventName =~ name`,
+		`eventName =~ "("`,
+		`eventName in ["a" "b"]`,
+		`eventName == "a`,
+	} {
+		if _, err := parseExpression(source); err == nil {
+			t.Errorf("Expected %s to be invalid", source)
+		}
+	}
+}
+
+func TestExpressionRule(t *testing.T) {
+	rule, err := newRule("domainCertIssued", ruleConfig{
+		Type:      expressionRuleType,
+		Condition: `MessageType != "heartbeat" and Data.AllDomains =~ "^www.*"`,
+		Output: &expressionOutput{
+			Name:      "DomainNameSeenInCertificate",
+			Level:     "warn",
+			Entity:    "Data.AllDomains.1",
+			EventTime: "Data.Seen",
+			Body:      map[string]string{"Domains": "Data.AllDomains"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Error creating expression rule: %s", err)
+	}
+
+	cert := certificate{MessageType: "certificate_update"}
+	cert.Data.Seen = 1526000000
+	cert.Data.AllDomains = []string{"example.com", "www.example.com"}
+	expected := output.OutputEvent{
+		Source:    "domainCertIssued",
+		EventTime: time.Unix(1526000000, 0),
+		EventType: "certStream",
+		Name:      "DomainNameSeenInCertificate",
+		Level:     output.WarnLevel,
+		Entity:    "www.example.com",
+		Body:      map[string]interface{}{"Domains": []interface{}{"example.com", "www.example.com"}},
+	}
+	if result := rule.Process(cert); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+
+	cert.MessageType = "heartbeat"
+	if result := rule.Process(cert); result != nil {
+		t.Errorf("Expected no output for a heartbeat, got %v", result)
+	}
+}
+
+func TestValidateExpressionRule(t *testing.T) {
+	rules := map[string]ruleConfig{
+		"Invalid expression rule a: Expression rule requires an output name":         {Type: expressionRuleType, Condition: "true"},
+		"Invalid expression rule a: Invalid condition: Unexpected end of expression": {Type: expressionRuleType, Output: &expressionOutput{Name: "a"}},
+		`Invalid expression rule a: not a valid Level: "critical"`:                   {Type: expressionRuleType, Condition: "true", Output: &expressionOutput{Name: "a", Level: "critical"}},
+		"Expression rule a can't use a state":                                        {Type: expressionRuleType, Condition: "true", Output: &expressionOutput{Name: "a"}, State: "s"},
+	}
+	for expected, rule := range rules {
+		if err := rule.validate("a"); err == nil || err.Error() != expected {
+			t.Errorf("Expected %s, got %v", expected, err)
+		}
+	}
+}
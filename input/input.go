@@ -16,6 +16,19 @@ type SourceConfig struct {
 	FileConfig    FileConfig    `json:"file_config,omitempty"`
 	KinesisConfig KinesisConfig `json:"kinesis_config,omitempty"`
 	KafkaConfig   KafkaConfig   `json:"kafka_config,omitempty"`
+	// EventTypes limits the event types records from this source are decoded as, in the order they're tried
+	EventTypes    []string            `json:"eventTypes,omitempty"`
+	Discriminator DiscriminatorConfig `json:"discriminator,omitempty"`
+}
+
+// DiscriminatorConfig selects the event type of a record from its content, rather than trying each event type in turn.
+// A "field" discriminator looks up Values by the value of a JSON field, a "prefix" discriminator by the longest matching prefix of the record.
+type DiscriminatorConfig struct {
+	Type   string            `json:"type,omitempty"`
+	Field  string            `json:"field,omitempty"`
+	Values map[string]string `json:"values,omitempty"`
+	// Default is the event type of records the discriminator has no value for
+	Default string `json:"default,omitempty"`
 }
 
 // SourceIface provides an interface for creating input sources
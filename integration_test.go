@@ -96,7 +96,7 @@ func setupPipeline(source input.Source, sink output.Sink, config []byte, databas
 		MonitoringService: "", // Noop service
 	}
 	mService, _ := mConfig.init(mux.NewRouter())
-	p, err := pManager.NewPipeline(config, mService)
+	p, err := pManager.NewPipeline(config, mService, revision{})
 	if err != nil {
 		return nil, err
 	}
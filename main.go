@@ -58,7 +58,7 @@ func startFromConfig(configFile string) {
 	if err != nil {
 		log.Fatalf("Failed to create noop monitoring service %s", err)
 	}
-	pipeline, err := pManager.NewPipeline(config, mService)
+	pipeline, err := pManager.NewPipeline(config, mService, revision{})
 	if err != nil {
 		log.Fatal(err)
 	}
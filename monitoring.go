@@ -28,6 +28,10 @@ type monitoringService interface {
 	init(*mux.Router) error
 	incrPipelines(string)
 	incrEventReceived(string)
+	incrEventThrottled(pipelineName string, sinkName string)
+	incrEventTypeMatched(pipelineName string, eventType string)
+	incrDecodeFailure(pipelineName string, eventType string)
+	setStateKeys(pipelineName string, stateName string, live int, expired int)
 }
 
 func (m *monitoringConfiguration) init(r *mux.Router) (monitoringService, error) {
@@ -47,14 +51,23 @@ func (m *monitoringConfiguration) init(r *mux.Router) (monitoringService, error)
 
 type noopMonitoringService struct{}
 
-func (n *noopMonitoringService) init(_ *mux.Router) error { return nil }
-func (n *noopMonitoringService) incrPipelines(string)     {}
-func (n *noopMonitoringService) incrEventReceived(string) {}
+func (n *noopMonitoringService) init(_ *mux.Router) error              { return nil }
+func (n *noopMonitoringService) incrPipelines(string)                  {}
+func (n *noopMonitoringService) incrEventReceived(string)              {}
+func (n *noopMonitoringService) incrEventThrottled(string, string)     {}
+func (n *noopMonitoringService) incrEventTypeMatched(string, string)   {}
+func (n *noopMonitoringService) incrDecodeFailure(string, string)      {}
+func (n *noopMonitoringService) setStateKeys(string, string, int, int) {}
 
 type prometheusMonitoringService struct {
 	Namespace string
 	pipelines *prometheus.GaugeVec
 	events    *prometheus.CounterVec
+	throttled *prometheus.CounterVec
+	matched   *prometheus.CounterVec
+	failures  *prometheus.CounterVec
+	liveKeys  *prometheus.GaugeVec
+	expired   *prometheus.GaugeVec
 }
 
 func (p *prometheusMonitoringService) init(r *mux.Router) error {
@@ -70,10 +83,36 @@ func (p *prometheusMonitoringService) init(r *mux.Router) error {
 		Name: p.Namespace + `EventsReceived`,
 		Help: "The number of events received",
 	}, []string{"pipelineName"})
+	p.throttled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: p.Namespace + `EventsThrottled`,
+		Help: "The number of output events throttled",
+	}, []string{"pipelineName", "sinkName"})
+	p.matched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: p.Namespace + `EventTypeMatches`,
+		Help: "The number of events decoded by each event type",
+	}, []string{"pipelineName", "eventType"})
+	p.failures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: p.Namespace + `EventDecodeFailures`,
+		Help: "The number of events that could not be decoded, by the event type selected for them",
+	}, []string{"pipelineName", "eventType"})
+
+	p.liveKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: p.Namespace + `StateLiveKeys`,
+		Help: "The number of keys in each state that haven't expired",
+	}, []string{"pipelineName", "stateName"})
+	p.expired = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: p.Namespace + `StateExpiredKeys`,
+		Help: "The number of keys in each state that have expired since it was initialised",
+	}, []string{"pipelineName", "stateName"})
 
 	metrics := []prometheus.Collector{
 		p.pipelines,
 		p.events,
+		p.throttled,
+		p.matched,
+		p.failures,
+		p.liveKeys,
+		p.expired,
 	}
 	for _, metric := range metrics {
 		err := prometheus.Register(metric)
@@ -94,6 +133,24 @@ func (p *prometheusMonitoringService) incrEventReceived(pipelineName string) {
 	p.events.With(prometheus.Labels{"pipelineName": pipelineName}).Add(float64(1))
 }
 
+func (p *prometheusMonitoringService) incrEventThrottled(pipelineName string, sinkName string) {
+	p.throttled.With(prometheus.Labels{"pipelineName": pipelineName, "sinkName": sinkName}).Add(float64(1))
+}
+
+func (p *prometheusMonitoringService) incrEventTypeMatched(pipelineName string, eventType string) {
+	p.matched.With(prometheus.Labels{"pipelineName": pipelineName, "eventType": eventType}).Add(float64(1))
+}
+
+func (p *prometheusMonitoringService) incrDecodeFailure(pipelineName string, eventType string) {
+	p.failures.With(prometheus.Labels{"pipelineName": pipelineName, "eventType": eventType}).Add(float64(1))
+}
+
+func (p *prometheusMonitoringService) setStateKeys(pipelineName string, stateName string, live int, expired int) {
+	labels := prometheus.Labels{"pipelineName": pipelineName, "stateName": stateName}
+	p.liveKeys.With(labels).Set(float64(live))
+	p.expired.With(labels).Set(float64(expired))
+}
+
 type cloudWatchMonitoringService struct {
 	Namespace string
 	// What granularity we should send metrics to CW at. Note setting this to 1 will cost quite a bit of money
@@ -104,8 +161,10 @@ type cloudWatchMonitoringService struct {
 }
 
 type cloudWatchMetrics struct {
-	pipelines      float64
-	eventsReceived float64
+	pipelines       float64
+	eventsReceived  float64
+	eventsThrottled float64
+	decodeFailures  float64
 	sync.Mutex
 }
 
@@ -172,6 +231,30 @@ func (cw *cloudWatchMonitoringService) flush() {
 					Timestamp:  &metricTimestamp,
 					Value:      aws.Float64(metric.eventsReceived),
 				},
+				&cloudwatch.MetricDatum{
+					Dimensions: []*cloudwatch.Dimension{
+						{
+							Name:  aws.String("Pipeline"),
+							Value: &pipeline,
+						},
+					},
+					MetricName: aws.String("EventsThrottled"),
+					Unit:       aws.String("Count"),
+					Timestamp:  &metricTimestamp,
+					Value:      aws.Float64(metric.eventsThrottled),
+				},
+				&cloudwatch.MetricDatum{
+					Dimensions: []*cloudwatch.Dimension{
+						{
+							Name:  aws.String("Pipeline"),
+							Value: &pipeline,
+						},
+					},
+					MetricName: aws.String("EventDecodeFailures"),
+					Unit:       aws.String("Count"),
+					Timestamp:  &metricTimestamp,
+					Value:      aws.Float64(metric.decodeFailures),
+				},
 			},
 		})
 		metric.Unlock()
@@ -199,3 +282,27 @@ func (cw *cloudWatchMonitoringService) incrEventReceived(pipelineName string) {
 	defer cw.pipelineMetrics[pipelineName].Unlock()
 	cw.pipelineMetrics[pipelineName].eventsReceived += float64(1)
 }
+
+func (cw *cloudWatchMonitoringService) incrEventThrottled(pipelineName string, _ string) {
+	if _, ok := cw.pipelineMetrics[pipelineName]; !ok {
+		cw.pipelineMetrics[pipelineName] = &cloudWatchMetrics{}
+	}
+	cw.pipelineMetrics[pipelineName].Lock()
+	defer cw.pipelineMetrics[pipelineName].Unlock()
+	cw.pipelineMetrics[pipelineName].eventsThrottled += float64(1)
+}
+
+// Per event type matches aren't sent to CloudWatch, as each event type would be a separate metric
+func (cw *cloudWatchMonitoringService) incrEventTypeMatched(string, string) {}
+
+func (cw *cloudWatchMonitoringService) incrDecodeFailure(pipelineName string, _ string) {
+	if _, ok := cw.pipelineMetrics[pipelineName]; !ok {
+		cw.pipelineMetrics[pipelineName] = &cloudWatchMetrics{}
+	}
+	cw.pipelineMetrics[pipelineName].Lock()
+	defer cw.pipelineMetrics[pipelineName].Unlock()
+	cw.pipelineMetrics[pipelineName].decodeFailures += float64(1)
+}
+
+// State key counts aren't sent to CloudWatch, as each state would be a separate metric
+func (cw *cloudWatchMonitoringService) setStateKeys(string, string, int, int) {}
@@ -0,0 +1,160 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DedupConfig defines the configuration of a Dedup output.
+// Events with the same values for KeyFields are suppressed for TTLSeconds after the first is forwarded.
+// TTLSeconds defaults to 300, and can't be negative.
+type DedupConfig struct {
+	KeyFields  []string `json:"keyFields,omitempty"`
+	TTLSeconds int      `json:"ttlSeconds"`
+	// Rollup forwards the last suppressed event when the window ends, with Occurrences
+	// set to the total number of occurrences seen in the window
+	Rollup bool   `json:"rollup,omitempty"`
+	Sink   string `json:"sink"`
+}
+
+// DedupOutput suppresses repeated events before passing them to another sink
+type DedupOutput struct {
+	DedupConfig
+	sink    *chan interface{}
+	windows map[string]*dedupWindow
+	wg      *sync.WaitGroup
+}
+
+type dedupWindow struct {
+	expires     time.Time
+	last        OutputEvent
+	occurrences int
+	suppressed  int
+}
+
+func (d *DedupOutput) Init(...interface{}) error {
+	if len(d.KeyFields) == 0 {
+		d.KeyFields = []string{"EventId"}
+	}
+	if d.TTLSeconds == 0 {
+		d.TTLSeconds = 300
+	}
+	if d.TTLSeconds <= 0 {
+		return fmt.Errorf("Dedup output requires a positive ttlSeconds")
+	}
+	d.windows = make(map[string]*dedupWindow)
+	d.wg = &sync.WaitGroup{}
+	return nil
+}
+
+// Downstream returns the name of the sink events are forwarded to
+func (d *DedupOutput) Downstream() []string {
+	return []string{d.DedupConfig.Sink}
+}
+
+// SetDownstream provides the input channel of the sink events are forwarded to
+func (d *DedupOutput) SetDownstream(sinks map[string]*chan interface{}) {
+	d.sink = sinks[d.DedupConfig.Sink]
+}
+
+func (d *DedupOutput) Sink(input *chan interface{}) {
+	d.wg.Add(1)
+	defer d.wg.Done()
+
+	expiry := time.NewTicker(d.sweepInterval())
+	defer expiry.Stop()
+	for {
+		select {
+		case i, ok := <-*input:
+			if !ok {
+				d.expire(true)
+				return
+			}
+			if i == nil {
+				continue
+			}
+			if d.suppress(i) {
+				continue
+			}
+			*d.sink <- i
+		case <-expiry.C:
+			d.expire(false)
+		}
+	}
+}
+
+func (d *DedupOutput) sweepInterval() time.Duration {
+	ttl := time.Duration(d.TTLSeconds) * time.Second
+	if ttl < time.Second {
+		return ttl
+	}
+	return time.Second
+}
+
+// key returns the values of the key fields, and false if they're all empty so the event can't be deduplicated
+func (d *DedupOutput) key(evt *OutputEvent) (string, bool) {
+	var values []string
+	found := false
+	for _, field := range d.KeyFields {
+		value, _ := evt.fieldValue(field)
+		values = append(values, value)
+		found = found || value != ""
+	}
+	return strings.Join(values, "\x00"), found
+}
+
+// suppress records the event and returns true if it's a repeat within the TTL.
+// Events without any key field values are never suppressed.
+func (d *DedupOutput) suppress(i interface{}) bool {
+	evt, ok := asOutputEvent(i)
+	if !ok {
+		return false
+	}
+	key, ok := d.key(evt)
+	if !ok {
+		return false
+	}
+	occurrences := evt.Occurrences
+	if occurrences < 1 {
+		occurrences = 1
+	}
+
+	window, ok := d.windows[key]
+	if ok && time.Now().Before(window.expires) {
+		window.last = *evt
+		window.occurrences += occurrences
+		window.suppressed++
+		log.Debugf("Suppressed duplicate event %v", key)
+		return true
+	}
+	d.windows[key] = &dedupWindow{
+		expires:     time.Now().Add(time.Duration(d.TTLSeconds) * time.Second),
+		occurrences: occurrences,
+	}
+	return false
+}
+
+// expire ends suppression windows whose TTL has passed, or all of them when closing
+func (d *DedupOutput) expire(all bool) {
+	for key, window := range d.windows {
+		if !all && time.Now().Before(window.expires) {
+			continue
+		}
+		delete(d.windows, key)
+		if d.Rollup && window.suppressed > 0 {
+			rollup := window.last
+			rollup.Occurrences = window.occurrences
+			*d.sink <- rollup
+		}
+	}
+}
+
+// Close waits for events in flight and rollups to be passed downstream
+func (d *DedupOutput) Close() error {
+	d.wg.Wait()
+	return nil
+}
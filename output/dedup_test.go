@@ -0,0 +1,72 @@
+package output
+
+import (
+	"testing"
+)
+
+func TestDedupOutput(t *testing.T) {
+	dedup := &DedupOutput{
+		DedupConfig: DedupConfig{
+			KeyFields:  []string{"Name", "Entity"},
+			TTLSeconds: 60,
+			Rollup:     true,
+			Sink:       "file",
+		},
+	}
+	dedup.Init()
+	downstream := make(chan interface{}, 10)
+	dedup.SetDownstream(map[string]*chan interface{}{"file": &downstream})
+
+	input := make(chan interface{})
+	go dedup.Sink(&input)
+	input <- OutputEvent{Name: "IAMUserCreated", Entity: "user/bob", EventId: "1", Occurrences: 1}
+	input <- OutputEvent{Name: "IAMUserCreated", Entity: "user/bob", EventId: "2", Occurrences: 1}
+	input <- &OutputEvent{Name: "IAMUserCreated", Entity: "user/alice", EventId: "3", Occurrences: 1}
+	input <- OutputEvent{Name: "IAMUserCreated", Entity: "user/bob", EventId: "4", Occurrences: 2}
+	close(input)
+	dedup.Close()
+	close(downstream)
+
+	var received []*OutputEvent
+	for i := range downstream {
+		evt, _ := asOutputEvent(i)
+		received = append(received, evt)
+	}
+	if len(received) != 3 {
+		t.Fatalf("Expected 2 events and a rollup, got %d", len(received))
+	}
+	if received[0].EventId != "1" || received[1].EventId != "3" {
+		t.Errorf("Expected the first occurrence of each event to be forwarded, got %v and %v", received[0], received[1])
+	}
+	if received[2].EventId != "4" || received[2].Occurrences != 4 {
+		t.Errorf("Expected a rollup of the last event with 4 occurrences, got %v", received[2])
+	}
+}
+
+func TestDedupOutputEmptyKey(t *testing.T) {
+	dedup := &DedupOutput{DedupConfig: DedupConfig{Sink: "file"}}
+	dedup.Init()
+	downstream := make(chan interface{}, 10)
+	dedup.SetDownstream(map[string]*chan interface{}{"file": &downstream})
+
+	input := make(chan interface{})
+	go dedup.Sink(&input)
+	input <- OutputEvent{Name: "IAMUserCreated", Entity: "user/bob"}
+	input <- OutputEvent{Name: "IAMUserDeleted", Entity: "user/alice"}
+	input <- OutputEvent{Name: "IAMUserCreated", Entity: "user/bob", EventId: "1"}
+	input <- OutputEvent{Name: "IAMUserCreated", Entity: "user/bob", EventId: "1"}
+	close(input)
+	dedup.Close()
+	close(downstream)
+
+	if len(downstream) != 3 {
+		t.Errorf("Expected events without an EventId to be forwarded and duplicates to be suppressed, got %d events", len(downstream))
+	}
+}
+
+func TestDedupOutputInvalidTTL(t *testing.T) {
+	dedup := &DedupOutput{DedupConfig: DedupConfig{TTLSeconds: -1, Sink: "file"}}
+	if err := dedup.Init(); err == nil {
+		t.Error("Expected a negative ttlSeconds to be rejected")
+	}
+}
@@ -0,0 +1,267 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ElasticsearchConfig defines the configuration of an Elasticsearch or OpenSearch output.
+// Index is a text/template executed with the OutputEvent. A trailing date containing the year 2006 is
+// formatted as a time layout with the event time, e.g. gofish-{{.Source}}-2006.01.02, and other text
+// is left as it is. Dates elsewhere in the index can be formatted with {{.Date "2006.01.02"}}.
+type ElasticsearchConfig struct {
+	URL             string            `json:"url"`
+	Index           string            `json:"index"`
+	Username        string            `json:"username,omitempty"`
+	Password        string            `json:"password,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	FlushIntervalMs int               `json:"flushIntervalMs,omitempty"`
+	FlushSize       int               `json:"flushSize,omitempty"`
+	FlushBytes      int               `json:"flushBytes,omitempty"`
+	TimeoutMs       int               `json:"timeoutMs,omitempty"`
+	MaxRetries      int               `json:"maxRetries,omitempty"`
+}
+
+// ElasticsearchOutput indexes events using the _bulk API
+type ElasticsearchOutput struct {
+	ElasticsearchConfig
+	client        *http.Client
+	indexTemplate *template.Template
+	wg            *sync.WaitGroup
+}
+
+type bulkItem struct {
+	action   []byte
+	document []byte
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Index  string          `json:"_index"`
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error,omitempty"`
+	} `json:"items"`
+}
+
+// indexData is passed to the index template, Date formats a layout with the event time
+type indexData struct {
+	*OutputEvent
+}
+
+func (d indexData) Date(layout string) string {
+	t := d.EventTime
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UTC().Format(layout)
+}
+
+// Init validates the configuration and sets defaults
+func (es *ElasticsearchOutput) Init(...interface{}) error {
+	if es.URL == "" || es.Index == "" {
+		return fmt.Errorf("Elasticsearch output requires a url and index")
+	}
+	if es.FlushIntervalMs == 0 {
+		es.FlushIntervalMs = 1000
+	}
+	if es.FlushSize == 0 {
+		es.FlushSize = 500
+	}
+	if es.FlushBytes == 0 {
+		es.FlushBytes = 5 * 1024 * 1024
+	}
+	if es.TimeoutMs == 0 {
+		es.TimeoutMs = 30000
+	}
+	if es.MaxRetries == 0 {
+		es.MaxRetries = 3
+	}
+	var err error
+	es.indexTemplate, err = template.New("index").Parse(indexTemplate(es.Index))
+	if err != nil {
+		return fmt.Errorf("Invalid Elasticsearch index template %v: %v", es.Index, err)
+	}
+	es.client = &http.Client{Timeout: time.Duration(es.TimeoutMs) * time.Millisecond}
+	es.wg = &sync.WaitGroup{}
+	return nil
+}
+
+// trailingDate matches a date layout of digits and separators at the end of an index
+var trailingDate = regexp.MustCompile(`[0-9][0-9._-]*$`)
+
+// indexTemplate rewrites a trailing date layout after the last template action so it's formatted with the event time.
+// Only layouts containing the year are rewritten, so other text such as a version number is left as it is.
+func indexTemplate(index string) string {
+	start := 0
+	if end := strings.LastIndex(index, "}}"); end != -1 {
+		start = end + 2
+	}
+	loc := trailingDate.FindStringIndex(index[start:])
+	if loc == nil || !strings.Contains(index[start+loc[0]:], "2006") {
+		return index
+	}
+	return fmt.Sprintf("%s{{.Date %s}}", index[:start+loc[0]], strconv.Quote(index[start+loc[0]:]))
+}
+
+// Sink buffers events and indexes them when the size thresholds or flush interval are reached
+func (es *ElasticsearchOutput) Sink(input *chan interface{}) {
+	log.Debugf("Writing to Elasticsearch %v", es.URL)
+	es.wg.Add(1)
+	defer es.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(es.FlushIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	var batch []bulkItem
+	var batchBytes int
+	for {
+		select {
+		case i, ok := <-*input:
+			if !ok {
+				es.flush(batch)
+				return
+			}
+			if i == nil {
+				continue
+			}
+			evt, ok := asOutputEvent(i)
+			if !ok {
+				log.Errorf("Elasticsearch output expects an OutputEvent, got %T", i)
+				continue
+			}
+			item, err := es.bulkItem(evt)
+			if err != nil {
+				log.Errorf("Unable to encode event for Elasticsearch: %v", err)
+				continue
+			}
+			batch = append(batch, item)
+			batchBytes += len(item.action) + len(item.document)
+			if len(batch) >= es.FlushSize || batchBytes >= es.FlushBytes {
+				es.flush(batch)
+				batch, batchBytes = nil, 0
+			}
+		case <-ticker.C:
+			es.flush(batch)
+			batch, batchBytes = nil, 0
+		}
+	}
+}
+
+func (es *ElasticsearchOutput) bulkItem(evt *OutputEvent) (bulkItem, error) {
+	var index bytes.Buffer
+	if err := es.indexTemplate.Execute(&index, indexData{evt}); err != nil {
+		return bulkItem{}, err
+	}
+	meta := map[string]string{"_index": strings.ToLower(index.String())}
+	// Using the EventId as the document ID makes redelivered events idempotent
+	if evt.EventId != "" {
+		meta["_id"] = evt.EventId
+	}
+	action, err := json.Marshal(map[string]interface{}{"index": meta})
+	if err != nil {
+		return bulkItem{}, err
+	}
+	document, err := json.Marshal(evt)
+	return bulkItem{action: action, document: document}, err
+}
+
+// flush indexes the batch, retrying any items rejected with a retryable status
+func (es *ElasticsearchOutput) flush(batch []bulkItem) {
+	for attempt := 0; len(batch) > 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<uint(attempt-1)*100) * time.Millisecond)
+		}
+		retry, err := es.bulk(batch)
+		if err != nil {
+			if attempt >= es.MaxRetries {
+				log.Errorf("Unable to index %d events in Elasticsearch: %v", len(batch), err)
+				return
+			}
+			continue
+		}
+		if len(retry) > 0 && attempt >= es.MaxRetries {
+			log.Errorf("Unable to index %d events in Elasticsearch after %d retries", len(retry), attempt)
+			return
+		}
+		batch = retry
+	}
+}
+
+// bulk sends a single _bulk request and returns the items that should be retried
+func (es *ElasticsearchOutput) bulk(batch []bulkItem) ([]bulkItem, error) {
+	var body bytes.Buffer
+	for _, item := range batch {
+		body.Write(item.action)
+		body.WriteByte('\n')
+		body.Write(item.document)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(es.URL, "/")+"/_bulk", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range es.Headers {
+		req.Header.Set(k, v)
+	}
+	if es.Username != "" {
+		req.SetBasicAuth(es.Username, es.Password)
+	}
+
+	resp, err := es.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("Elasticsearch bulk request failed: %s %s", resp.Status, respBody)
+	}
+
+	var result bulkResponse
+	if err = json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("Invalid Elasticsearch bulk response: %v", err)
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+	if len(result.Items) != len(batch) {
+		return nil, fmt.Errorf("Elasticsearch bulk response has %d items, expected %d", len(result.Items), len(batch))
+	}
+
+	var retry []bulkItem
+	for i, item := range result.Items {
+		for _, status := range item {
+			switch {
+			case status.Status < 300:
+			case status.Status == http.StatusTooManyRequests || status.Status >= 500:
+				retry = append(retry, batch[i])
+			default:
+				log.Errorf("Elasticsearch rejected document %s in %s: %d %s", status.ID, status.Index, status.Status, status.Error)
+			}
+		}
+	}
+	return retry, nil
+}
+
+// Close waits for any buffered events to be indexed
+func (es *ElasticsearchOutput) Close() error {
+	es.wg.Wait()
+	return nil
+}
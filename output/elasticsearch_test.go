@@ -0,0 +1,101 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bulkStandIn is a minimal stand-in for the Elasticsearch _bulk API.
+// Documents are rejected with the status in reject the first time they're seen.
+type bulkStandIn struct {
+	sync.Mutex
+	requests int
+	indexed  map[string]string
+	reject   map[string]int
+}
+
+func (b *bulkStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.Lock()
+	defer b.Unlock()
+	if r.URL.Path != "/_bulk" {
+		w.WriteHeader(404)
+		return
+	}
+	b.requests++
+	var items []string
+	errors := false
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var action map[string]map[string]string
+		json.Unmarshal(scanner.Bytes(), &action)
+		scanner.Scan()
+		meta := action["index"]
+		status := 201
+		if rejected, ok := b.reject[meta["_id"]]; ok {
+			status = rejected
+			delete(b.reject, meta["_id"])
+			errors = true
+		} else {
+			b.indexed[meta["_id"]] = meta["_index"]
+		}
+		items = append(items, fmt.Sprintf(`{"index":{"_index":%q,"_id":%q,"status":%d}}`, meta["_index"], meta["_id"], status))
+	}
+	fmt.Fprintf(w, `{"errors":%t,"items":[%s]}`, errors, strings.Join(items, ","))
+}
+
+func TestElasticsearchIndexTemplate(t *testing.T) {
+	cases := map[string]string{
+		"gofish-{{.Source}}-2006.01.02": `gofish-{{.Source}}-{{.Date "2006.01.02"}}`,
+		"monitoring-200601":             `monitoring-{{.Date "200601"}}`,
+		"monitoring-alerts":             "monitoring-alerts",
+		"alerts-v2":                     "alerts-v2",
+		"alerts-2006-{{.Source}}":       "alerts-2006-{{.Source}}",
+	}
+	for index, expected := range cases {
+		if tmpl := indexTemplate(index); tmpl != expected {
+			t.Errorf("Expected index %s to have template %s, got %s", index, expected, tmpl)
+		}
+	}
+}
+
+func TestElasticsearchBulk(t *testing.T) {
+	standIn := &bulkStandIn{
+		indexed: map[string]string{},
+		reject:  map[string]int{"retried": 429, "rejected": 400},
+	}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	es := &ElasticsearchOutput{
+		ElasticsearchConfig: ElasticsearchConfig{
+			URL:   server.URL,
+			Index: "gofish-{{.Source}}-2006.01.02",
+		},
+	}
+	eventTime := time.Date(2018, 5, 10, 12, 0, 0, 0, time.UTC)
+	var events []interface{}
+	for _, id := range []string{"indexed", "retried", "rejected"} {
+		events = append(events, OutputEvent{Source: "CloudTrail", EventId: id, EventTime: eventTime})
+	}
+	runSink(t, es, events...)
+
+	if standIn.requests != 2 {
+		t.Errorf("Expected the retryable document to be sent again, got %d requests", standIn.requests)
+	}
+	if standIn.indexed["indexed"] != "gofish-cloudtrail-2018.05.10" {
+		t.Errorf("Expected document to be indexed in gofish-cloudtrail-2018.05.10, got %v", standIn.indexed)
+	}
+	if _, ok := standIn.indexed["retried"]; !ok {
+		t.Error("Expected the throttled document to be retried")
+	}
+	if _, ok := standIn.indexed["rejected"]; ok {
+		t.Error("Expected the rejected document not to be retried")
+	}
+}
@@ -2,6 +2,7 @@ package output
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -53,3 +54,46 @@ const (
 	WarnLevel
 	InfoLevel
 )
+
+// asOutputEvent returns the OutputEvent held by a value received on a sink's channel.
+// Rules may return either OutputEvent values or pointers to them.
+func asOutputEvent(i interface{}) (*OutputEvent, bool) {
+	switch evt := i.(type) {
+	case OutputEvent:
+		return &evt, true
+	case *OutputEvent:
+		return evt, evt != nil
+	}
+	return nil, false
+}
+
+// fieldValue returns the string value of the named field of the event.
+// Keys of the Body can be referenced as Body.<key>.
+func (e *OutputEvent) fieldValue(name string) (string, bool) {
+	switch name {
+	case "Source":
+		return e.Source, true
+	case "EventTime":
+		return e.EventTime.Format(time.RFC3339Nano), true
+	case "EventType":
+		return e.EventType, true
+	case "Name":
+		return e.Name, true
+	case "Level":
+		return e.Level.String(), true
+	case "EventId":
+		return e.EventId, true
+	case "Entity":
+		return e.Entity, true
+	case "SourceIP":
+		return e.SourceIP, true
+	case "Occurrences":
+		return strconv.Itoa(e.Occurrences), true
+	}
+	if strings.HasPrefix(name, "Body.") {
+		if value, ok := e.Body[strings.TrimPrefix(name, "Body.")]; ok {
+			return fmt.Sprint(value), true
+		}
+	}
+	return "", false
+}
@@ -1,56 +1,335 @@
 package output
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 	"sync"
+	"text/template"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Fsync policies for the File output
+const (
+	SyncAlways   = "always"
+	SyncInterval = "interval"
+	SyncRotate   = "rotate"
+)
+
+// FileConfig defines the configuration of a File output.
+// Path is a text/template that can reference {{.Pipeline}} and {{.Time.Format "2006-01-02"}}.
+// When it references .Time, the output moves to a new file as soon as the path renders differently.
+// MaxSegments and MaxAgeSec only remove segments the output has rotated since it started,
+// so other files in the directory, and segments written before a restart, are never removed.
 type FileConfig struct {
-	Path string `json:"path"`
+	Path              string `json:"path"`
+	MaxSizeBytes      int64  `json:"maxSizeBytes,omitempty"`
+	RotateIntervalSec int    `json:"rotateIntervalSec,omitempty"`
+	Compress          bool   `json:"compress,omitempty"`
+	MaxSegments       int    `json:"maxSegments,omitempty"`
+	MaxAgeSec         int    `json:"maxAgeSec,omitempty"`
+	SyncPolicy        string `json:"syncPolicy,omitempty"`
+	SyncIntervalMs    int    `json:"syncIntervalMs,omitempty"`
 }
 
+// FileOutput writes events to a file as JSON, one per line, optionally rotating it
 type FileOutput struct {
-	FileName string
-	file     *os.File
-	wg       *sync.WaitGroup
+	FileName     string
+	Pipeline     string
+	Config       FileConfig
+	pathTemplate *template.Template
+	file         *os.File
+	filePath     string
+	fileSize     int64
+	openedAt     time.Time
+	// created is true if the current segment didn't exist before it was opened
+	created bool
+	// timedPath is true if the path template references the time
+	timedPath bool
+	// now returns the current time, and can be replaced in tests
+	now func() time.Time
+	wg  *sync.WaitGroup
+	// rotated passes closed segments to be compressed and retained, in the order they're rotated
+	rotated chan rotatedSegment
+	// segments are the closed segments the output has rotated, oldest first
+	segments []string
+}
+
+type rotatedSegment struct {
+	path   string
+	active string
+}
+
+type filePathData struct {
+	Pipeline string
+	Time     interface{}
 }
 
 func (f *FileOutput) Init(...interface{}) error {
 	var err error
-	if _, err := os.Stat(f.FileName); os.IsNotExist(err) {
-		os.Create(f.FileName)
+	if f.Config.SyncPolicy == "" {
+		f.Config.SyncPolicy = SyncAlways
+	}
+	switch f.Config.SyncPolicy {
+	case SyncAlways, SyncRotate:
+	case SyncInterval:
+		if f.Config.SyncIntervalMs == 0 {
+			f.Config.SyncIntervalMs = 1000
+		}
+	default:
+		return fmt.Errorf("Invalid file sync policy: %v", f.Config.SyncPolicy)
+	}
+	f.pathTemplate, err = template.New("path").Parse(f.FileName)
+	if err != nil {
+		return fmt.Errorf("Invalid file path template %v: %v", f.FileName, err)
+	}
+	f.timedPath = strings.Contains(f.FileName, ".Time")
+	if f.now == nil {
+		f.now = time.Now
 	}
 	f.wg = &sync.WaitGroup{}
-	f.file, err = os.OpenFile(f.FileName, os.O_APPEND|os.O_WRONLY, os.ModeAppend)
-	return err
+	return f.openSegment()
+}
+
+func (f *FileOutput) renderPath(t interface{}) (string, error) {
+	var buf bytes.Buffer
+	err := f.pathTemplate.Execute(&buf, filePathData{
+		Pipeline: f.Pipeline,
+		Time:     t,
+	})
+	return buf.String(), err
+}
+
+func (f *FileOutput) openSegment() error {
+	var err error
+	f.filePath, err = f.renderPath(f.now())
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(f.filePath)
+	f.created = os.IsNotExist(err)
+	f.file, err = os.OpenFile(f.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	f.fileSize = info.Size()
+	f.openedAt = f.now()
+	return nil
 }
 
 func (f *FileOutput) Sink(input *chan interface{}) {
-	log.Debugf("Writing to file %v", f.FileName)
+	log.Debugf("Writing to file %v", f.filePath)
 	f.wg.Add(1)
 	defer f.wg.Done()
-	defer f.file.Close()
+	f.rotated = make(chan rotatedSegment, 16)
+	f.wg.Add(1)
+	go f.manageSegments()
+	defer close(f.rotated)
+	defer f.closeSegment()
 
-	for i := range *input {
-		if i == nil {
-			continue
+	// Ticks drive interval syncs and time based rotation while no events arrive
+	var tick <-chan time.Time
+	if f.Config.SyncPolicy == SyncInterval || f.Config.RotateIntervalSec > 0 || f.timedPath {
+		interval := time.Second
+		if f.Config.SyncPolicy == SyncInterval {
+			interval = time.Duration(f.Config.SyncIntervalMs) * time.Millisecond
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case i, ok := <-*input:
+			if !ok {
+				return
+			}
+			if i == nil {
+				continue
+			}
+			f.write(i)
+		case <-tick:
+			if f.Config.SyncPolicy == SyncInterval {
+				f.sync()
+			}
+			if f.rotationDue() {
+				f.rotate()
+			}
+		}
+	}
+}
+
+func (f *FileOutput) write(i interface{}) {
+	if f.pathChanged() {
+		f.rotate()
+	}
+	data, err := json.Marshal(i)
+	if err != nil {
+		log.Fatalf("Unable to write event to file: %v\n%v\n", err, data)
+	}
+	n, err := f.file.Write(append(data, []byte("\n")...))
+	if err != nil {
+		log.Fatalf("Unable to write to file %v: %v\n", f.filePath, err)
+	}
+	f.fileSize += int64(n)
+	if f.Config.SyncPolicy == SyncAlways {
+		f.sync()
+	}
+	if f.rotationDue() {
+		f.rotate()
+	}
+}
+
+func (f *FileOutput) sync() {
+	err := f.file.Sync()
+	if err != nil {
+		log.Fatalf("Unable to sync file %v: %v\n", f.filePath, err)
+	}
+}
+
+func (f *FileOutput) rotationDue() bool {
+	if f.Config.MaxSizeBytes > 0 && f.fileSize >= f.Config.MaxSizeBytes {
+		return true
+	}
+	interval := time.Duration(f.Config.RotateIntervalSec) * time.Second
+	if interval > 0 && f.now().Sub(f.openedAt) >= interval {
+		return true
+	}
+	return f.pathChanged()
+}
+
+// pathChanged reports whether a path template that references the time now renders a different file
+func (f *FileOutput) pathChanged() bool {
+	if !f.timedPath {
+		return false
+	}
+	next, err := f.renderPath(f.now())
+	return err == nil && next != f.filePath
+}
+
+// rotate closes the current segment and opens a new one.
+// If the path template renders to the same file, the closed segment is renamed with a timestamp suffix.
+// An empty segment is only replaced if the path renders a different file, and is removed if the output created it.
+func (f *FileOutput) rotate() {
+	next, err := f.renderPath(f.now())
+	if f.fileSize == 0 {
+		if err != nil || next == f.filePath {
+			f.openedAt = f.now()
+			return
+		}
+		f.closeSegment()
+		if f.created {
+			os.Remove(f.filePath)
+		}
+		if err := f.openSegment(); err != nil {
+			log.Fatalf("Unable to open file %v: %v\n", f.filePath, err)
+		}
+		return
+	}
+	f.closeSegment()
+	segment := f.filePath
+	if err == nil && next == f.filePath {
+		segment = fmt.Sprintf("%s.%s", f.filePath, f.now().Format("20060102T150405.000000000"))
+		if err := os.Rename(f.filePath, segment); err != nil {
+			log.Errorf("Unable to rotate file %v: %v", f.filePath, err)
+		}
+	}
+	if err := f.openSegment(); err != nil {
+		log.Fatalf("Unable to open file %v: %v\n", f.filePath, err)
+	}
+	log.Debugf("Rotated file %v to %v", f.filePath, segment)
+
+	if segment == f.filePath {
+		// The segment couldn't be renamed, so it's still being written to
+		return
+	}
+	f.rotated <- rotatedSegment{path: segment, active: f.filePath}
+}
+
+// manageSegments compresses rotated segments and applies retention in the background, one segment at a time
+func (f *FileOutput) manageSegments() {
+	defer f.wg.Done()
+	for segment := range f.rotated {
+		if f.Config.Compress {
+			if err := compressSegment(segment.path); err != nil {
+				log.Errorf("Unable to compress file %v: %v", segment.path, err)
+			} else {
+				segment.path += ".gz"
+			}
 		}
-		data, err := json.Marshal(i)
-		if err != nil {
-			log.Fatalf("Unable to write event to file: %v\n%v\n", err, data)
+		f.segments = append(f.segments, segment.path)
+		f.applyRetention(segment.active)
+	}
+}
+
+func (f *FileOutput) closeSegment() {
+	if f.Config.SyncPolicy != SyncAlways {
+		f.sync()
+	}
+	f.file.Close()
+}
+
+func compressSegment(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// applyRetention removes the oldest rotated segments beyond MaxSegments and those older than MaxAgeSec,
+// except the active segment if the path template renders a rotated segment's path again
+func (f *FileOutput) applyRetention(active string) {
+	if f.Config.MaxSegments == 0 && f.Config.MaxAgeSec == 0 {
+		return
+	}
+	maxAge := time.Duration(f.Config.MaxAgeSec) * time.Second
+	var retained []string
+	for i, segment := range f.segments {
+		newer := len(f.segments) - i - 1
+		expired := false
+		if maxAge > 0 {
+			info, err := os.Stat(segment)
+			expired = err == nil && time.Now().Sub(info.ModTime()) > maxAge
 		}
-		_, err = f.file.Write(append(data, []byte("\n")...))
-		if err != nil {
-			log.Fatalf("Unable to write to file %v: %v\n", f.FileName, err)
+		if segment == active || !expired && (f.Config.MaxSegments == 0 || newer < f.Config.MaxSegments) {
+			retained = append(retained, segment)
+			continue
 		}
-		err = f.file.Sync()
-		if err != nil {
-			log.Fatalf("Unable to sync file %v: %v\n", f.FileName, err)
+		log.Debugf("Removing file segment %v", segment)
+		if err := os.Remove(segment); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Unable to remove file segment %v: %v", segment, err)
 		}
 	}
+	f.segments = retained
 }
 
 func (f *FileOutput) Close() error {
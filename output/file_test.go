@@ -0,0 +1,168 @@
+package output
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileOutputPathTemplate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gofish")
+	defer os.RemoveAll(dir)
+
+	runSink(t, &FileOutput{
+		FileName: filepath.Join(dir, "{{.Pipeline}}-{{.Time.Format \"2006\"}}.json"),
+		Pipeline: "cloudtrail",
+	}, OutputEvent{Name: "NoMFA"})
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "cloudtrail-2*.json"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected one file named after the pipeline and year, got %v", matches)
+	}
+	data, _ := ioutil.ReadFile(matches[0])
+	if !strings.Contains(string(data), `"Name":"NoMFA"`) {
+		t.Errorf("Expected event to be written, got %s", data)
+	}
+}
+
+func TestFileOutputRotation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gofish")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "output.json")
+
+	runSink(t, &FileOutput{
+		FileName: path,
+		Config: FileConfig{
+			MaxSizeBytes: 1,
+			Compress:     true,
+			MaxSegments:  2,
+			SyncPolicy:   SyncRotate,
+		},
+	}, OutputEvent{Name: "a"}, OutputEvent{Name: "b"}, OutputEvent{Name: "c"}, OutputEvent{Name: "d"})
+
+	segments, _ := filepath.Glob(path + ".*.gz")
+	if len(segments) != 2 {
+		t.Fatalf("Expected 2 compressed segments to be retained, got %v", segments)
+	}
+	for _, segment := range segments {
+		f, _ := os.Open(segment)
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("Segment %s is not gzipped: %s", segment, err)
+		}
+		data, _ := ioutil.ReadAll(gz)
+		f.Close()
+		if strings.Count(string(data), "\n") != 1 {
+			t.Errorf("Expected one event per segment, got %s", data)
+		}
+	}
+}
+
+func TestFileOutputInvalidSyncPolicy(t *testing.T) {
+	f := &FileOutput{
+		FileName: "invalid.json",
+		Config:   FileConfig{SyncPolicy: "sometimes"},
+	}
+	if err := f.Init(); err == nil {
+		t.Error("Expected an invalid sync policy to be rejected")
+	}
+}
+
+func TestFileOutputRetentionKeepsOtherFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gofish")
+	defer os.RemoveAll(dir)
+	unrelated := []string{
+		filepath.Join(dir, "alerts-archive.json"),
+		filepath.Join(dir, "alerts-2017.json"),
+		filepath.Join(dir, "alerts-.json.bak"),
+	}
+	for _, path := range unrelated {
+		ioutil.WriteFile(path, []byte("keep\n"), 0644)
+	}
+
+	runSink(t, &FileOutput{
+		FileName: filepath.Join(dir, "alerts-{{.Time.Format \"20060102T150405.000000000\"}}.json"),
+		Config: FileConfig{
+			MaxSizeBytes: 1,
+			MaxSegments:  1,
+			MaxAgeSec:    1,
+		},
+	}, OutputEvent{Name: "a"}, OutputEvent{Name: "b"}, OutputEvent{Name: "c"})
+
+	for _, path := range unrelated {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s not to be removed by retention, got %s", path, err)
+		}
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "alerts-2*T*.json"))
+	if len(segments) != 2 {
+		t.Errorf("Expected the active file and 1 rotated segment, got %v", segments)
+	}
+}
+
+func TestFileOutputDateRotation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gofish")
+	defer os.RemoveAll(dir)
+
+	var lock sync.Mutex
+	now := time.Date(2018, 3, 1, 23, 59, 59, 0, time.UTC)
+	f := &FileOutput{
+		FileName: filepath.Join(dir, "alerts-{{.Time.Format \"2006-01-02\"}}.json"),
+		now: func() time.Time {
+			lock.Lock()
+			defer lock.Unlock()
+			return now
+		},
+	}
+	if err := f.Init(); err != nil {
+		t.Fatalf("Error initialising file output: %s", err)
+	}
+	input := make(chan interface{})
+	done := make(chan struct{})
+	go func() {
+		f.Sink(&input)
+		close(done)
+	}()
+	input <- OutputEvent{Name: "a"}
+	first := filepath.Join(dir, "alerts-2018-03-01.json")
+	for i := 0; i < 30; i++ {
+		if info, err := os.Stat(first); err == nil && info.Size() > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Let the write finish syncing before the day changes
+	time.Sleep(100 * time.Millisecond)
+
+	lock.Lock()
+	now = now.Add(time.Second)
+	lock.Unlock()
+	// With no events arriving the ticker moves the output to the next day's file
+	next := filepath.Join(dir, "alerts-2018-03-02.json")
+	for i := 0; i < 30; i++ {
+		if _, err := os.Stat(next); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if _, err := os.Stat(next); err != nil {
+		t.Errorf("Expected the output to move to %s without new events, got %s", next, err)
+	}
+	input <- OutputEvent{Name: "b"}
+	close(input)
+	<-done
+	f.Close()
+
+	for path, name := range map[string]string{first: "a", next: "b"} {
+		data, _ := ioutil.ReadFile(path)
+		if strings.Count(string(data), "\n") != 1 || !strings.Contains(string(data), fmt.Sprintf(`"Name":%q`, name)) {
+			t.Errorf("Expected %s to contain event %s, got %s", path, name, data)
+		}
+	}
+}
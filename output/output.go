@@ -5,9 +5,28 @@ import (
 )
 
 type SinkConfig struct {
-	Type       string     `json:"type"`
-	FileConfig FileConfig `json:"file_config,omitempty"`
-	SqsConfig  SqsConfig  `json:"sqs_config,omitempty"`
+	Type                string              `json:"type"`
+	Pipeline            string              `json:"-"`
+	FileConfig          FileConfig          `json:"file_config,omitempty"`
+	SqsConfig           SqsConfig           `json:"sqs_config,omitempty"`
+	WebhookConfig       WebhookConfig       `json:"webhook_config,omitempty"`
+	ElasticsearchConfig ElasticsearchConfig `json:"elasticsearch_config,omitempty"`
+	RouterConfig        RouterConfig        `json:"router_config,omitempty"`
+	DedupConfig         DedupConfig         `json:"dedup_config,omitempty"`
+	ThrottleConfig      ThrottleConfig      `json:"throttle_config,omitempty"`
+}
+
+// Downstream returns the names of other sinks this sink forwards events to
+func (c SinkConfig) Downstream() []string {
+	switch c.Type {
+	case "Router":
+		return c.RouterConfig.downstream()
+	case "Dedup":
+		return []string{c.DedupConfig.Sink}
+	case "Throttle":
+		return c.ThrottleConfig.downstream()
+	}
+	return nil
 }
 
 // Sink is an interface for output implementations
@@ -17,6 +36,14 @@ type Sink interface {
 	Close() error
 }
 
+// Forwarder is implemented by sinks that pass events on to other sinks in the pipeline.
+// SetDownstream is called with the input channel of each sink named by Downstream before the sink is started.
+type Forwarder interface {
+	Sink
+	Downstream() []string
+	SetDownstream(map[string]*chan interface{})
+}
+
 // SourceIface provides an interface for creating input sources
 type SinkIface interface {
 	Create(config SinkConfig) (Sink, error)
@@ -31,10 +58,33 @@ func (*DefaultSink) Create(config SinkConfig) (Sink, error) {
 		return &SQSOutput{
 			QueueUrl: config.SqsConfig.QueueUrl,
 			Region:   config.SqsConfig.Region,
+			Config:   config.SqsConfig,
 		}, nil
 	case "File":
 		return &FileOutput{
 			FileName: config.FileConfig.Path,
+			Pipeline: config.Pipeline,
+			Config:   config.FileConfig,
+		}, nil
+	case "Webhook":
+		return &WebhookOutput{
+			WebhookConfig: config.WebhookConfig,
+		}, nil
+	case "Elasticsearch":
+		return &ElasticsearchOutput{
+			ElasticsearchConfig: config.ElasticsearchConfig,
+		}, nil
+	case "Router":
+		return &RouterOutput{
+			RouterConfig: config.RouterConfig,
+		}, nil
+	case "Dedup":
+		return &DedupOutput{
+			DedupConfig: config.DedupConfig,
+		}, nil
+	case "Throttle":
+		return &ThrottleOutput{
+			ThrottleConfig: config.ThrottleConfig,
 		}, nil
 	}
 
@@ -0,0 +1,24 @@
+package output
+
+import (
+	"testing"
+)
+
+// runSink initialises a sink, passes it the events and waits for it to close
+func runSink(t *testing.T, s Sink, events ...interface{}) {
+	if err := s.Init(); err != nil {
+		t.Fatalf("Error initialising %T: %s", s, err)
+	}
+	input := make(chan interface{})
+	done := make(chan struct{})
+	go func() {
+		s.Sink(&input)
+		close(done)
+	}()
+	for _, evt := range events {
+		input <- evt
+	}
+	close(input)
+	<-done
+	s.Close()
+}
@@ -0,0 +1,123 @@
+package output
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RouterConfig defines the configuration of a Router output.
+// Events are sent to the sink of the first matching route, or the default sink if none match.
+type RouterConfig struct {
+	Routes  []Route `json:"routes"`
+	Default string  `json:"default,omitempty"`
+}
+
+// Route matches events on which all of the non-empty conditions are true
+type Route struct {
+	Level     string            `json:"level,omitempty"`
+	EventType string            `json:"eventType,omitempty"`
+	Source    string            `json:"source,omitempty"`
+	Body      map[string]string `json:"body,omitempty"`
+	Sink      string            `json:"sink"`
+	level     *Level
+}
+
+func (r *Route) matches(evt *OutputEvent) bool {
+	if r.level != nil && evt.Level != *r.level {
+		return false
+	}
+	if r.EventType != "" && evt.EventType != r.EventType {
+		return false
+	}
+	if r.Source != "" && evt.Source != r.Source {
+		return false
+	}
+	for key, expected := range r.Body {
+		value, ok := evt.fieldValue("Body." + key)
+		if !ok || value != expected {
+			return false
+		}
+	}
+	return true
+}
+
+// RouterOutput dispatches events to other sinks based on their fields
+type RouterOutput struct {
+	RouterConfig
+	sinks map[string]*chan interface{}
+	wg    *sync.WaitGroup
+}
+
+func (r *RouterOutput) Init(...interface{}) error {
+	for i := range r.Routes {
+		if r.Routes[i].Level == "" {
+			continue
+		}
+		level, err := ParseLevel(r.Routes[i].Level)
+		if err != nil {
+			return fmt.Errorf("Invalid route: %v", err)
+		}
+		r.Routes[i].level = &level
+	}
+	r.wg = &sync.WaitGroup{}
+	return nil
+}
+
+// Downstream returns the names of the sinks events are routed to
+func (r *RouterOutput) Downstream() []string {
+	return r.RouterConfig.downstream()
+}
+
+func (c RouterConfig) downstream() []string {
+	var sinks []string
+	for _, route := range c.Routes {
+		sinks = append(sinks, route.Sink)
+	}
+	if c.Default != "" {
+		sinks = append(sinks, c.Default)
+	}
+	return sinks
+}
+
+// SetDownstream provides the input channels of the sinks events are routed to
+func (r *RouterOutput) SetDownstream(sinks map[string]*chan interface{}) {
+	r.sinks = sinks
+}
+
+func (r *RouterOutput) Sink(input *chan interface{}) {
+	r.wg.Add(1)
+	defer r.wg.Done()
+
+	for i := range *input {
+		if i == nil {
+			continue
+		}
+		sink := r.route(i)
+		if sink == "" {
+			log.Debugf("Router dropping event with no matching route: %v", i)
+			continue
+		}
+		*r.sinks[sink] <- i
+	}
+}
+
+func (r *RouterOutput) route(i interface{}) string {
+	evt, ok := asOutputEvent(i)
+	if !ok {
+		return r.Default
+	}
+	for _, route := range r.Routes {
+		if route.matches(evt) {
+			return route.Sink
+		}
+	}
+	return r.Default
+}
+
+// Close waits for events in flight to be passed downstream
+func (r *RouterOutput) Close() error {
+	r.wg.Wait()
+	return nil
+}
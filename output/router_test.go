@@ -0,0 +1,63 @@
+package output
+
+import (
+	"testing"
+)
+
+func TestRouterOutput(t *testing.T) {
+	router := &RouterOutput{
+		RouterConfig: RouterConfig{
+			Routes: []Route{
+				{Level: "error", Sink: "pager"},
+				{EventType: "NoMFA", Body: map[string]string{"AccountID": "123"}, Sink: "security"},
+			},
+			Default: "file",
+		},
+	}
+	if err := router.Init(); err != nil {
+		t.Fatalf("Error initialising router: %s", err)
+	}
+	downstream := map[string]*chan interface{}{}
+	received := map[string][]interface{}{}
+	for _, name := range router.Downstream() {
+		c := make(chan interface{}, 10)
+		downstream[name] = &c
+	}
+	router.SetDownstream(downstream)
+
+	input := make(chan interface{})
+	go router.Sink(&input)
+	input <- OutputEvent{Name: "a", Level: ErrorLevel, EventType: "NoMFA"}
+	input <- &OutputEvent{Name: "b", Level: WarnLevel, EventType: "NoMFA", Body: map[string]interface{}{"AccountID": "123"}}
+	input <- OutputEvent{Name: "c", Level: WarnLevel, EventType: "NoMFA", Body: map[string]interface{}{"AccountID": "456"}}
+	input <- true
+	close(input)
+	router.Close()
+
+	for name, c := range downstream {
+		close(*c)
+		for evt := range *c {
+			received[name] = append(received[name], evt)
+		}
+	}
+	if len(received["pager"]) != 1 || received["pager"][0].(OutputEvent).Name != "a" {
+		t.Errorf("Expected error events to be routed to pager, got %v", received["pager"])
+	}
+	if len(received["security"]) != 1 || received["security"][0].(*OutputEvent).Name != "b" {
+		t.Errorf("Expected matching NoMFA events to be routed to security, got %v", received["security"])
+	}
+	if len(received["file"]) != 2 {
+		t.Errorf("Expected unmatched events to be routed to the default sink, got %v", received["file"])
+	}
+}
+
+func TestRouterInvalidLevel(t *testing.T) {
+	router := &RouterOutput{
+		RouterConfig: RouterConfig{
+			Routes: []Route{{Level: "critical", Sink: "pager"}},
+		},
+	}
+	if err := router.Init(); err == nil {
+		t.Error("Expected a route with an invalid level to be rejected")
+	}
+}
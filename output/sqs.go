@@ -1,8 +1,13 @@
 package output
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
@@ -12,19 +17,64 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// sqsMaxBatchSize is the maximum number of messages SendMessageBatch accepts
+	sqsMaxBatchSize = 10
+	// sqsMaxBatchBytes is the maximum total payload of a SendMessageBatch request
+	sqsMaxBatchBytes = 256 * 1024
+)
+
 type SqsConfig struct {
-	QueueUrl string `json:"queueUrl"`
-	Region   string `json:"region"`
+	QueueUrl        string `json:"queueUrl"`
+	Region          string `json:"region"`
+	BatchSize       int    `json:"batchSize,omitempty"`
+	BatchIntervalMs int    `json:"batchIntervalMs,omitempty"`
+	MaxRetries      int    `json:"maxRetries,omitempty"`
+	// Fifo is implied when the queue URL ends in .fifo
+	Fifo bool `json:"fifo,omitempty"`
+	// MessageGroupField is the OutputEvent field used as the MessageGroupId of FIFO messages
+	MessageGroupField string `json:"messageGroupField,omitempty"`
+	// DeduplicationFields are the OutputEvent fields hashed to form the MessageDeduplicationId of FIFO messages
+	DeduplicationFields []string `json:"deduplicationFields,omitempty"`
 }
 
 type SQSOutput struct {
 	QueueUrl string
 	Region   string
+	Config   SqsConfig
 	sqsSvc   sqsiface.SQSAPI
 	wg       *sync.WaitGroup
 }
 
+type sqsMessage struct {
+	entry *sqs.SendMessageBatchRequestEntry
+	size  int
+}
+
 func (o *SQSOutput) Init(...interface{}) error {
+	if o.Config.BatchSize <= 0 || o.Config.BatchSize > sqsMaxBatchSize {
+		o.Config.BatchSize = sqsMaxBatchSize
+	}
+	if o.Config.BatchIntervalMs == 0 {
+		o.Config.BatchIntervalMs = 1000
+	}
+	if o.Config.MaxRetries == 0 {
+		o.Config.MaxRetries = 3
+	}
+	if strings.HasSuffix(o.QueueUrl, ".fifo") {
+		o.Config.Fifo = true
+	}
+	if o.Config.MessageGroupField == "" {
+		o.Config.MessageGroupField = "Source"
+	}
+	if len(o.Config.DeduplicationFields) == 0 {
+		o.Config.DeduplicationFields = []string{"EventId"}
+	}
+	o.wg = &sync.WaitGroup{}
+	if o.sqsSvc != nil {
+		return nil
+	}
+
 	session, err := session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 		Config:            aws.Config{Region: &o.Region},
@@ -33,7 +83,6 @@ func (o *SQSOutput) Init(...interface{}) error {
 		return err
 	}
 	o.sqsSvc = sqs.New(session)
-	o.wg = &sync.WaitGroup{}
 	return nil
 }
 
@@ -42,21 +91,146 @@ func (o *SQSOutput) Sink(input *chan interface{}) {
 	o.wg.Add(1)
 	defer o.wg.Done()
 
-	for i := range *input {
-		if i == nil {
+	ticker := time.NewTicker(time.Duration(o.Config.BatchIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	var batch []sqsMessage
+	var batchBytes int
+	for {
+		select {
+		case i, ok := <-*input:
+			if !ok {
+				o.sendBatch(batch)
+				return
+			}
+			if i == nil {
+				continue
+			}
+			data, ok := asOutputEvent(i)
+			if !ok {
+				log.Errorf("SQS output expects an OutputEvent, got %T", i)
+				continue
+			}
+			msg, err := o.message(data)
+			if err != nil {
+				log.Errorf("Unable to encode event for SQS: %v", err)
+				continue
+			}
+			if batchBytes+msg.size > sqsMaxBatchBytes {
+				o.sendBatch(batch)
+				batch, batchBytes = nil, 0
+			}
+			msg.entry.Id = aws.String(strconv.Itoa(len(batch)))
+			batch = append(batch, msg)
+			batchBytes += msg.size
+			if len(batch) >= o.Config.BatchSize {
+				o.sendBatch(batch)
+				batch, batchBytes = nil, 0
+			}
+		case <-ticker.C:
+			o.sendBatch(batch)
+			batch, batchBytes = nil, 0
+		}
+	}
+}
+
+func (o *SQSOutput) message(data *OutputEvent) (sqsMessage, error) {
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return sqsMessage{}, err
+	}
+	entry := &sqs.SendMessageBatchRequestEntry{
+		MessageBody:       aws.String(string(rawData)),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{},
+	}
+	size := len(rawData)
+	attributes := map[string]string{
+		"Level":     data.Level.String(),
+		"EventType": data.EventType,
+	}
+	for name, value := range attributes {
+		// SQS rejects attributes with empty values
+		if value == "" {
 			continue
 		}
+		entry.MessageAttributes[name] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+		size += len(name) + len(value) + len("String")
+	}
+
+	if o.Config.Fifo {
+		groupID, _ := data.fieldValue(o.Config.MessageGroupField)
+		if groupID == "" {
+			groupID = "default"
+		}
+		entry.MessageGroupId = aws.String(groupID)
+		entry.MessageDeduplicationId = aws.String(o.deduplicationID(data, rawData))
+	}
+	return sqsMessage{entry: entry, size: size}, nil
+}
+
+// deduplicationID hashes the configured fields, falling back to the message body if they're all empty
+func (o *SQSOutput) deduplicationID(data *OutputEvent, rawData []byte) string {
+	var values []string
+	empty := true
+	for _, field := range o.Config.DeduplicationFields {
+		value, _ := data.fieldValue(field)
+		if value != "" {
+			empty = false
+		}
+		values = append(values, value)
+	}
+	if empty {
+		values = []string{string(rawData)}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(values, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendBatch sends the batch, retrying entries that failed through no fault of our own
+func (o *SQSOutput) sendBatch(batch []sqsMessage) {
+	if len(batch) == 0 {
+		return
+	}
+	pending := make([]*sqs.SendMessageBatchRequestEntry, len(batch))
+	for i, msg := range batch {
+		pending[i] = msg.entry
+	}
 
-		data := i.(*OutputEvent)
-		rawData, _ := json.Marshal(data)
-		sendMessageParams := &sqs.SendMessageInput{
-			MessageBody: aws.String(string(rawData)),
-			QueueUrl:    &o.QueueUrl,
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			// Backoff time as recommended by https://docs.aws.amazon.com/general/latest/gr/api-retries.html
+			time.Sleep(time.Duration(1<<uint(attempt-1)*100) * time.Millisecond)
 		}
-		_, err := o.sqsSvc.SendMessage(sendMessageParams)
+		result, err := o.sqsSvc.SendMessageBatch(&sqs.SendMessageBatchInput{
+			Entries:  pending,
+			QueueUrl: &o.QueueUrl,
+		})
 		if err != nil {
-			log.Errorf("Unable to write to SQS Queue: %v\n", err)
+			if attempt >= o.Config.MaxRetries {
+				log.Errorf("Unable to write %d messages to SQS Queue: %v\n", len(pending), err)
+				return
+			}
+			continue
+		}
+
+		byID := make(map[string]*sqs.SendMessageBatchRequestEntry, len(pending))
+		for _, entry := range pending {
+			byID[*entry.Id] = entry
+		}
+		var retry []*sqs.SendMessageBatchRequestEntry
+		for _, failed := range result.Failed {
+			if aws.BoolValue(failed.SenderFault) || attempt >= o.Config.MaxRetries {
+				log.Errorf("Unable to write message to SQS Queue: %s %s\n", aws.StringValue(failed.Code), aws.StringValue(failed.Message))
+				continue
+			}
+			if entry, ok := byID[aws.StringValue(failed.Id)]; ok {
+				retry = append(retry, entry)
+			}
 		}
+		pending = retry
 	}
 }
 
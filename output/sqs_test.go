@@ -0,0 +1,100 @@
+package output
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+type mockSQS struct {
+	sqsiface.SQSAPI
+	sync.Mutex
+	batches  [][]*sqs.SendMessageBatchRequestEntry
+	failOnce map[string]bool
+}
+
+func (m *mockSQS) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.batches = append(m.batches, input.Entries)
+	output := &sqs.SendMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		if m.failOnce[*entry.MessageBody] {
+			delete(m.failOnce, *entry.MessageBody)
+			output.Failed = append(output.Failed, &sqs.BatchResultErrorEntry{
+				Id:          entry.Id,
+				Code:        aws.String("InternalError"),
+				SenderFault: aws.Bool(false),
+			})
+			continue
+		}
+		output.Successful = append(output.Successful, &sqs.SendMessageBatchResultEntry{Id: entry.Id})
+	}
+	return output, nil
+}
+
+func TestSQSOutputBatching(t *testing.T) {
+	mock := &mockSQS{}
+	var events []interface{}
+	for i := 0; i < 12; i++ {
+		events = append(events, OutputEvent{Name: "NoMFA", Level: WarnLevel, EventType: "NoMFA"})
+	}
+	// Pointers and values should both be accepted, anything else is dropped
+	events = append(events, &OutputEvent{Name: "IAMUserCreated"}, true)
+
+	runSink(t, &SQSOutput{QueueUrl: "https://sqs/queue", sqsSvc: mock}, events...)
+
+	if len(mock.batches) != 2 {
+		t.Fatalf("Expected 2 batches, got %d", len(mock.batches))
+	}
+	if len(mock.batches[0]) != 10 || len(mock.batches[1]) != 3 {
+		t.Errorf("Expected batches of 10 and 3, got %d and %d", len(mock.batches[0]), len(mock.batches[1]))
+	}
+	attributes := mock.batches[0][0].MessageAttributes
+	if *attributes["Level"].StringValue != "warn" || *attributes["EventType"].StringValue != "NoMFA" {
+		t.Errorf("Expected Level and EventType attributes, got %v", attributes)
+	}
+	if mock.batches[0][0].MessageGroupId != nil {
+		t.Error("Expected standard queue messages to have no MessageGroupId")
+	}
+}
+
+func TestSQSOutputFifo(t *testing.T) {
+	mock := &mockSQS{}
+	runSink(t, &SQSOutput{
+		QueueUrl: "https://sqs/queue.fifo",
+		Config:   SqsConfig{MessageGroupField: "Entity"},
+		sqsSvc:   mock,
+	}, OutputEvent{Entity: "user/bob", EventId: "1"}, OutputEvent{Entity: "user/bob", EventId: "1"}, OutputEvent{EventId: "2"})
+
+	entries := mock.batches[0]
+	if *entries[0].MessageGroupId != "user/bob" || *entries[2].MessageGroupId != "default" {
+		t.Errorf("Unexpected message groups %s, %s", *entries[0].MessageGroupId, *entries[2].MessageGroupId)
+	}
+	if *entries[0].MessageDeduplicationId != *entries[1].MessageDeduplicationId {
+		t.Error("Expected events with the same EventId to share a deduplication ID")
+	}
+	if *entries[0].MessageDeduplicationId == *entries[2].MessageDeduplicationId {
+		t.Error("Expected events with different EventIds to have different deduplication IDs")
+	}
+}
+
+func TestSQSOutputRetriesFailedEntries(t *testing.T) {
+	evt := OutputEvent{Name: "retry"}
+	o := &SQSOutput{QueueUrl: "https://sqs/queue"}
+	o.Init()
+	msg, _ := o.message(&evt)
+	mock := &mockSQS{failOnce: map[string]bool{*msg.entry.MessageBody: true}}
+
+	runSink(t, &SQSOutput{QueueUrl: "https://sqs/queue", sqsSvc: mock}, OutputEvent{Name: "ok"}, evt)
+
+	if len(mock.batches) != 2 {
+		t.Fatalf("Expected the failed entry to be retried, got %d batches", len(mock.batches))
+	}
+	if len(mock.batches[1]) != 1 || *mock.batches[1][0].MessageBody != *msg.entry.MessageBody {
+		t.Errorf("Expected only the failed entry to be retried, got %v", mock.batches[1])
+	}
+}
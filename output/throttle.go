@@ -0,0 +1,206 @@
+package output
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Overflow behaviours of the Throttle output
+const (
+	OverflowDrop      = "drop"
+	OverflowSummarise = "summarise"
+	OverflowDivert    = "divert"
+)
+
+// ThrottleConfig defines the configuration of a Throttle output.
+// Each distinct value of KeyField gets a token bucket refilled at Rate events per second up to Burst.
+// Placing a Throttle in front of a single sink limits that sink, routing all rules through one limits the pipeline.
+type ThrottleConfig struct {
+	KeyField string  `json:"keyField,omitempty"`
+	Rate     float64 `json:"rate"`
+	Burst    int     `json:"burst,omitempty"`
+	// Overflow is what happens to events over the limit, "drop", "summarise" or "divert"
+	Overflow string `json:"overflow,omitempty"`
+	// DivertSink receives events over the limit when Overflow is "divert"
+	DivertSink string `json:"divertSink,omitempty"`
+	// SummaryIntervalSec is how often a summary of throttled events is sent when Overflow is "summarise"
+	SummaryIntervalSec int    `json:"summaryIntervalSec,omitempty"`
+	Sink               string `json:"sink"`
+}
+
+func (c ThrottleConfig) downstream() []string {
+	if c.Overflow == OverflowDivert {
+		return []string{c.Sink, c.DivertSink}
+	}
+	return []string{c.Sink}
+}
+
+// ThrottleOutput rate limits events per key before passing them to another sink
+type ThrottleOutput struct {
+	ThrottleConfig
+	sink       *chan interface{}
+	divertSink *chan interface{}
+	buckets    map[string]*tokenBucket
+	onThrottle func()
+	wg         *sync.WaitGroup
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updated   time.Time
+	throttled int
+	last      OutputEvent
+}
+
+// Throttler is implemented by sinks that limit events, so the pipeline can count throttled events
+type Throttler interface {
+	OnThrottle(func())
+}
+
+func (t *ThrottleOutput) Init(...interface{}) error {
+	if t.Rate <= 0 {
+		return fmt.Errorf("Throttle output requires a positive rate")
+	}
+	if t.KeyField == "" {
+		t.KeyField = "Entity"
+	}
+	if t.Burst < 1 {
+		t.Burst = 1
+	}
+	if t.SummaryIntervalSec == 0 {
+		t.SummaryIntervalSec = 60
+	}
+	switch t.Overflow {
+	case "":
+		t.Overflow = OverflowDrop
+	case OverflowDrop, OverflowSummarise:
+	case OverflowDivert:
+		if t.DivertSink == "" {
+			return fmt.Errorf("Throttle output requires a divertSink to divert events to")
+		}
+	default:
+		return fmt.Errorf("Invalid throttle overflow: %v", t.Overflow)
+	}
+	t.buckets = make(map[string]*tokenBucket)
+	t.wg = &sync.WaitGroup{}
+	return nil
+}
+
+// Downstream returns the names of the sinks events are forwarded to
+func (t *ThrottleOutput) Downstream() []string {
+	return t.ThrottleConfig.downstream()
+}
+
+// SetDownstream provides the input channels of the sinks events are forwarded to
+func (t *ThrottleOutput) SetDownstream(sinks map[string]*chan interface{}) {
+	t.sink = sinks[t.ThrottleConfig.Sink]
+	t.divertSink = sinks[t.DivertSink]
+}
+
+// OnThrottle registers a function called for each event over the limit
+func (t *ThrottleOutput) OnThrottle(f func()) {
+	t.onThrottle = f
+}
+
+func (t *ThrottleOutput) Sink(input *chan interface{}) {
+	t.wg.Add(1)
+	defer t.wg.Done()
+
+	summary := time.NewTicker(time.Duration(t.SummaryIntervalSec) * time.Second)
+	defer summary.Stop()
+	for {
+		select {
+		case i, ok := <-*input:
+			if !ok {
+				t.summarise()
+				return
+			}
+			if i == nil {
+				continue
+			}
+			t.forward(i)
+		case <-summary.C:
+			t.summarise()
+		}
+	}
+}
+
+func (t *ThrottleOutput) forward(i interface{}) {
+	evt, ok := asOutputEvent(i)
+	if !ok {
+		*t.sink <- i
+		return
+	}
+	key, _ := evt.fieldValue(t.KeyField)
+	bucket, ok := t.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(t.Burst), updated: time.Now()}
+		t.buckets[key] = bucket
+	}
+	if bucket.take(t.Rate, t.Burst) {
+		*t.sink <- i
+		return
+	}
+
+	log.Debugf("Throttled event for %v", key)
+	if t.onThrottle != nil {
+		t.onThrottle()
+	}
+	switch t.Overflow {
+	case OverflowDivert:
+		*t.divertSink <- i
+	case OverflowSummarise:
+		bucket.throttled++
+		bucket.last = *evt
+	}
+}
+
+// take refills the bucket for the time elapsed and takes a token if one is available
+func (b *tokenBucket) take(rate float64, burst int) bool {
+	now := time.Now()
+	b.tokens += now.Sub(b.updated).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.updated = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// summarise sends the last throttled event for each key with Occurrences set to the number throttled,
+// and forgets keys whose buckets have refilled
+func (t *ThrottleOutput) summarise() {
+	for key, bucket := range t.buckets {
+		if bucket.throttled > 0 {
+			summary := bucket.last
+			summary.Occurrences = bucket.throttled
+			if summary.Body == nil {
+				summary.Body = map[string]interface{}{}
+			} else {
+				body := make(map[string]interface{}, len(bucket.last.Body)+1)
+				for k, v := range bucket.last.Body {
+					body[k] = v
+				}
+				summary.Body = body
+			}
+			summary.Body["Throttled"] = true
+			*t.sink <- summary
+			bucket.throttled = 0
+		}
+		if time.Now().Sub(bucket.updated).Seconds()*t.Rate >= float64(t.Burst) {
+			delete(t.buckets, key)
+		}
+	}
+}
+
+// Close waits for events in flight and summaries to be passed downstream
+func (t *ThrottleOutput) Close() error {
+	t.wg.Wait()
+	return nil
+}
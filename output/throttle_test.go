@@ -0,0 +1,78 @@
+package output
+
+import (
+	"testing"
+)
+
+func runThrottle(t *testing.T, throttle *ThrottleOutput, events ...interface{}) (sunk, diverted []*OutputEvent, throttled int) {
+	if err := throttle.Init(); err != nil {
+		t.Fatalf("Error initialising throttle: %s", err)
+	}
+	sink := make(chan interface{}, 10)
+	divert := make(chan interface{}, 10)
+	throttle.SetDownstream(map[string]*chan interface{}{"pager": &sink, "file": &divert})
+	throttle.OnThrottle(func() { throttled++ })
+
+	input := make(chan interface{})
+	go throttle.Sink(&input)
+	for _, evt := range events {
+		input <- evt
+	}
+	close(input)
+	throttle.Close()
+	close(sink)
+	close(divert)
+	for i := range sink {
+		evt, _ := asOutputEvent(i)
+		sunk = append(sunk, evt)
+	}
+	for i := range divert {
+		evt, _ := asOutputEvent(i)
+		diverted = append(diverted, evt)
+	}
+	return
+}
+
+func noMFAEvents(entities ...string) []interface{} {
+	var events []interface{}
+	for _, entity := range entities {
+		events = append(events, OutputEvent{Name: "NoMFA", Entity: entity, Occurrences: 1})
+	}
+	return events
+}
+
+func TestThrottleDrop(t *testing.T) {
+	sunk, _, throttled := runThrottle(t, &ThrottleOutput{
+		ThrottleConfig: ThrottleConfig{Rate: 0.001, Burst: 2, Sink: "pager"},
+	}, noMFAEvents("user/bob", "user/bob", "user/bob", "user/alice")...)
+
+	if len(sunk) != 3 {
+		t.Errorf("Expected 2 events for bob and 1 for alice, got %d", len(sunk))
+	}
+	if throttled != 1 {
+		t.Errorf("Expected 1 event to be throttled, got %d", throttled)
+	}
+}
+
+func TestThrottleSummarise(t *testing.T) {
+	sunk, _, _ := runThrottle(t, &ThrottleOutput{
+		ThrottleConfig: ThrottleConfig{Rate: 0.001, Overflow: OverflowSummarise, Sink: "pager"},
+	}, noMFAEvents("user/bob", "user/bob", "user/bob")...)
+
+	if len(sunk) != 2 {
+		t.Fatalf("Expected an event and a summary, got %d", len(sunk))
+	}
+	if sunk[1].Occurrences != 2 || sunk[1].Body["Throttled"] != true {
+		t.Errorf("Expected a summary of 2 throttled events, got %v", sunk[1])
+	}
+}
+
+func TestThrottleDivert(t *testing.T) {
+	sunk, diverted, _ := runThrottle(t, &ThrottleOutput{
+		ThrottleConfig: ThrottleConfig{Rate: 0.001, Overflow: OverflowDivert, DivertSink: "file", Sink: "pager"},
+	}, noMFAEvents("user/bob", "user/bob", "user/bob")...)
+
+	if len(sunk) != 1 || len(diverted) != 2 {
+		t.Errorf("Expected 1 event to be sent and 2 diverted, got %d and %d", len(sunk), len(diverted))
+	}
+}
@@ -0,0 +1,259 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WebhookConfig defines the configuration of a Webhook output.
+// MaxRetries defaults to 3 when it's 0, and a negative MaxRetries turns retries off.
+type WebhookConfig struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Template is a text/template used to render the request body.
+	// It is executed with an OutputEvent, or a slice of OutputEvents when batching.
+	// When empty the event (or batch) is sent as JSON.
+	Template        string `json:"template,omitempty"`
+	TimeoutMs       int    `json:"timeoutMs,omitempty"`
+	MaxRetries      int    `json:"maxRetries,omitempty"`
+	BackoffMs       int    `json:"backoffMs,omitempty"`
+	MaxBackoffMs    int    `json:"maxBackoffMs,omitempty"`
+	BatchSize       int    `json:"batchSize,omitempty"`
+	BatchIntervalMs int    `json:"batchIntervalMs,omitempty"`
+	// DeadLetterPath is a file that deliveries which permanently failed are appended to
+	DeadLetterPath string `json:"deadLetterPath,omitempty"`
+}
+
+// WebhookOutput sends events to a HTTP endpoint
+type WebhookOutput struct {
+	WebhookConfig
+	client   *http.Client
+	template *template.Template
+	wg       *sync.WaitGroup
+}
+
+type deadLetter struct {
+	Time    time.Time `json:"time"`
+	URL     string    `json:"url"`
+	Error   string    `json:"error"`
+	Payload string    `json:"payload"`
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// Init validates the configuration and sets defaults
+func (w *WebhookOutput) Init(...interface{}) error {
+	if w.URL == "" {
+		return fmt.Errorf("Webhook output requires a url")
+	}
+	if w.Method == "" {
+		w.Method = http.MethodPost
+	}
+	if w.TimeoutMs == 0 {
+		w.TimeoutMs = 5000
+	}
+	if w.MaxRetries == 0 {
+		w.MaxRetries = 3
+	}
+	if w.BackoffMs == 0 {
+		w.BackoffMs = 100
+	}
+	if w.MaxBackoffMs == 0 {
+		w.MaxBackoffMs = 10000
+	}
+	if w.BatchIntervalMs == 0 {
+		w.BatchIntervalMs = 1000
+	}
+	if w.Template != "" {
+		var err error
+		w.template, err = template.New("webhook").Funcs(webhookTemplateFuncs).Parse(w.Template)
+		if err != nil {
+			return fmt.Errorf("Invalid webhook template: %v", err)
+		}
+	}
+	w.client = &http.Client{Timeout: time.Duration(w.TimeoutMs) * time.Millisecond}
+	w.wg = &sync.WaitGroup{}
+	return nil
+}
+
+// Sink sends each event, or batches of events, to the webhook
+func (w *WebhookOutput) Sink(input *chan interface{}) {
+	log.Debugf("Writing to webhook %v", w.URL)
+	w.wg.Add(1)
+	defer w.wg.Done()
+
+	// A nil channel blocks forever, so without batching we never flush on a timer
+	var flushTimer <-chan time.Time
+	if w.BatchSize > 1 {
+		ticker := time.NewTicker(time.Duration(w.BatchIntervalMs) * time.Millisecond)
+		defer ticker.Stop()
+		flushTimer = ticker.C
+	}
+
+	var batch []interface{}
+	for {
+		select {
+		case i, ok := <-*input:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			if i == nil {
+				continue
+			}
+			if evt, ok := asOutputEvent(i); ok {
+				i = *evt
+			}
+			if w.BatchSize <= 1 {
+				w.send(i)
+				continue
+			}
+			batch = append(batch, i)
+			if len(batch) >= w.BatchSize {
+				w.flush(batch)
+				batch = nil
+			}
+		case <-flushTimer:
+			w.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+func (w *WebhookOutput) flush(batch []interface{}) {
+	if len(batch) == 0 {
+		return
+	}
+	w.send(batch)
+}
+
+func (w *WebhookOutput) send(data interface{}) {
+	payload, err := w.render(data)
+	if err != nil {
+		log.Errorf("Unable to render webhook payload: %v", err)
+		return
+	}
+	if err = w.deliver(payload); err != nil {
+		log.Errorf("Unable to deliver to webhook %v: %v", w.URL, err)
+		w.deadLetter(payload, err)
+	}
+}
+
+func (w *WebhookOutput) render(data interface{}) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(data)
+	}
+	var buf bytes.Buffer
+	err := w.template.Execute(&buf, data)
+	return buf.Bytes(), err
+}
+
+// deliver sends the payload, retrying with exponential backoff on server errors and throttling
+func (w *WebhookOutput) deliver(payload []byte) error {
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := w.post(payload)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 || attempt >= w.MaxRetries {
+			return err
+		}
+		if retryAfter == 0 {
+			retryAfter = w.backoff(attempt)
+		}
+		log.Debugf("Webhook delivery failed, retrying in %v: %v", retryAfter, err)
+		time.Sleep(retryAfter)
+	}
+}
+
+// post makes a single delivery attempt. On failure it returns how long the server
+// asked us to wait, zero to use the default backoff or negative if we shouldn't retry.
+func (w *WebhookOutput) post(payload []byte) (time.Duration, error) {
+	req, err := http.NewRequest(w.Method, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return time.Duration(seconds) * time.Second, fmt.Errorf("Webhook throttled: %s", resp.Status)
+		}
+		return 0, fmt.Errorf("Webhook throttled: %s", resp.Status)
+	case resp.StatusCode >= 500:
+		return 0, fmt.Errorf("Webhook server error: %s", resp.Status)
+	}
+	return -1, fmt.Errorf("Webhook rejected request: %s", resp.Status)
+}
+
+// backoff returns an exponential backoff with jitter, capped at MaxBackoffMs
+func (w *WebhookOutput) backoff(attempt int) time.Duration {
+	backoff := w.BackoffMs << uint(attempt)
+	if backoff > w.MaxBackoffMs || backoff <= 0 {
+		backoff = w.MaxBackoffMs
+	}
+	jitter := rand.Intn(backoff/2 + 1)
+	return time.Duration(backoff/2+jitter) * time.Millisecond
+}
+
+func (w *WebhookOutput) deadLetter(payload []byte, deliveryErr error) {
+	if w.DeadLetterPath == "" {
+		return
+	}
+	data, err := json.Marshal(deadLetter{
+		Time:    time.Now(),
+		URL:     w.URL,
+		Error:   deliveryErr.Error(),
+		Payload: string(payload),
+	})
+	if err != nil {
+		log.Errorf("Unable to encode dead letter: %v", err)
+		return
+	}
+	f, err := os.OpenFile(w.DeadLetterPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Errorf("Unable to open dead letter file %v: %v", w.DeadLetterPath, err)
+		return
+	}
+	defer f.Close()
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		log.Errorf("Unable to write dead letter file %v: %v", w.DeadLetterPath, err)
+	}
+}
+
+// Close waits for any pending deliveries to complete
+func (w *WebhookOutput) Close() error {
+	w.wg.Wait()
+	return nil
+}
@@ -0,0 +1,155 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+type webhookRecorder struct {
+	sync.Mutex
+	bodies   []string
+	headers  []http.Header
+	statuses []int
+}
+
+func (rec *webhookRecorder) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec.Lock()
+		defer rec.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		rec.bodies = append(rec.bodies, string(body))
+		rec.headers = append(rec.headers, r.Header)
+		status := http.StatusOK
+		if len(rec.statuses) > 0 {
+			status = rec.statuses[0]
+			rec.statuses = rec.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	rec := &webhookRecorder{}
+	server := httptest.NewServer(rec.handler())
+	defer server.Close()
+
+	runSink(t, &WebhookOutput{
+		WebhookConfig: WebhookConfig{
+			URL:      server.URL,
+			Headers:  map[string]string{"Authorization": "Bearer foo"},
+			Template: `{"text": {{json .Name}}, "level": "{{.Level}}"}`,
+		},
+	}, OutputEvent{Name: "NoMFA", Level: WarnLevel}, &OutputEvent{Name: "IAMUserCreated", Level: ErrorLevel})
+
+	if len(rec.bodies) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(rec.bodies))
+	}
+	if rec.bodies[0] != `{"text": "NoMFA", "level": "warn"}` {
+		t.Errorf("Unexpected body %s", rec.bodies[0])
+	}
+	if rec.bodies[1] != `{"text": "IAMUserCreated", "level": "error"}` {
+		t.Errorf("Unexpected body %s", rec.bodies[1])
+	}
+	if rec.headers[0].Get("Authorization") != "Bearer foo" {
+		t.Errorf("Expected Authorization header to be set, got %v", rec.headers[0])
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	rec := &webhookRecorder{statuses: []int{503, 429, 200}}
+	server := httptest.NewServer(rec.handler())
+	defer server.Close()
+
+	runSink(t, &WebhookOutput{
+		WebhookConfig: WebhookConfig{
+			URL:       server.URL,
+			BackoffMs: 1,
+		},
+	}, OutputEvent{Name: "NoMFA"})
+
+	if len(rec.bodies) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", len(rec.bodies))
+	}
+}
+
+func TestWebhookNoRetries(t *testing.T) {
+	rec := &webhookRecorder{statuses: []int{503, 200}}
+	server := httptest.NewServer(rec.handler())
+	defer server.Close()
+
+	runSink(t, &WebhookOutput{
+		WebhookConfig: WebhookConfig{
+			URL:        server.URL,
+			MaxRetries: -1,
+			BackoffMs:  1,
+		},
+	}, OutputEvent{Name: "NoMFA"})
+
+	if len(rec.bodies) != 1 {
+		t.Fatalf("Expected 1 attempt, got %d", len(rec.bodies))
+	}
+}
+
+func TestWebhookBatching(t *testing.T) {
+	rec := &webhookRecorder{}
+	server := httptest.NewServer(rec.handler())
+	defer server.Close()
+
+	runSink(t, &WebhookOutput{
+		WebhookConfig: WebhookConfig{
+			URL:       server.URL,
+			BatchSize: 2,
+		},
+	}, OutputEvent{Name: "a"}, OutputEvent{Name: "b"}, OutputEvent{Name: "c"})
+
+	if len(rec.bodies) != 2 {
+		t.Fatalf("Expected 2 batches, got %d", len(rec.bodies))
+	}
+	var batch []OutputEvent
+	if err := json.Unmarshal([]byte(rec.bodies[0]), &batch); err != nil {
+		t.Fatalf("Batch is not a JSON list: %s", err)
+	}
+	if len(batch) != 2 || batch[0].Name != "a" || batch[1].Name != "b" {
+		t.Errorf("Unexpected first batch %v", batch)
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	rec := &webhookRecorder{statuses: []int{400}}
+	server := httptest.NewServer(rec.handler())
+	defer server.Close()
+	defer os.Remove("webhook_dead_letter.json")
+
+	runSink(t, &WebhookOutput{
+		WebhookConfig: WebhookConfig{
+			URL:            server.URL,
+			BackoffMs:      1,
+			DeadLetterPath: "webhook_dead_letter.json",
+		},
+	}, OutputEvent{Name: "NoMFA"})
+
+	if len(rec.bodies) != 1 {
+		t.Errorf("Expected client errors not to be retried, got %d attempts", len(rec.bodies))
+	}
+	f, err := os.Open("webhook_dead_letter.json")
+	if err != nil {
+		t.Fatalf("Expected dead letter file to exist: %s", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	var letters []deadLetter
+	for scanner.Scan() {
+		var letter deadLetter
+		json.Unmarshal(scanner.Bytes(), &letter)
+		letters = append(letters, letter)
+	}
+	if len(letters) != 1 || letters[0].Payload != rec.bodies[0] {
+		t.Errorf("Expected the failed payload to be dead lettered, got %v", letters)
+	}
+}
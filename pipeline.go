@@ -6,11 +6,13 @@ import (
 	"os"
 	"os/signal"
 	"reflect"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/google/uuid"
+	"github.com/patrobinson/go-fish/event"
 	"github.com/patrobinson/go-fish/input"
 	"github.com/patrobinson/go-fish/output"
 	"github.com/patrobinson/go-fish/state"
@@ -21,6 +23,7 @@ import (
 type pipelineConfig struct {
 	Name        string
 	EventFolder string                        `json:"eventFolder"`
+	EventTypes  map[string]eventTypeConfig    `json:"eventTypes,omitempty"`
 	Rules       map[string]ruleConfig         `json:"rules"`
 	States      map[string]state.Config       `json:"states"`
 	Sources     map[string]input.SourceConfig `json:"sources"`
@@ -35,7 +38,7 @@ func parseConfig(rawConfig []byte) (pipelineConfig, error) {
 }
 
 func validateConfig(config pipelineConfig) error {
-	stateUsage := make(map[string]int)
+	stateWriters := make(map[string]int)
 	// Validate that any Sources, Sinks and States a Rule points to exist
 	for ruleName, rule := range config.Rules {
 		// TODO: Ensure a source exists
@@ -57,13 +60,33 @@ func validateConfig(config pipelineConfig) error {
 			if !ok {
 				return fmt.Errorf("Invalid state for rule %s: %s", ruleName, rule.State)
 			}
-			stateUsage[rule.State]++
+			if rule.StateAccess != readOnlyStateAccess {
+				stateWriters[rule.State]++
+			}
+		}
+
+		if err := rule.validate(ruleName); err != nil {
+			return err
 		}
+	}
 
-		if _, err := os.Stat(rule.Plugin); err != nil {
-			return fmt.Errorf("Invalid plugin: %s", err)
+	// Validate that any Sinks a Sink forwards to exist and don't form a cycle
+	for sinkName, sink := range config.Sinks {
+		for _, downstream := range sink.Downstream() {
+			if _, ok := config.Sinks[downstream]; !ok {
+				return fmt.Errorf("Invalid downstream sink for sink %s: %s", sinkName, downstream)
+			}
 		}
 	}
+	if cycle := findSinkCycle(config.Sinks); cycle != "" {
+		return fmt.Errorf("Invalid sink configuration, sink %s forwards to itself", cycle)
+	}
+
+	eventTypes, err := createEventTypes(config.EventTypes)
+	if err != nil {
+		return err
+	}
+	warnUnknownEventTypes(config, eventTypes)
 
 	// Validate there are no naming conflicts
 	var keys []reflect.Value
@@ -76,16 +99,83 @@ func validateConfig(config pipelineConfig) error {
 		return fmt.Errorf("Invalid configuration, duplicate keys: %s", duplicates)
 	}
 
-	// Validate no rules share a state
-	for state, used := range stateUsage {
-		if used > 1 {
-			return fmt.Errorf("Invalid rule configuration, only one rule can use each state but found multiple use state: %s", state)
+	// Validate no rules share a state they write to
+	for state, writers := range stateWriters {
+		if writers > 1 {
+			return fmt.Errorf("Invalid rule configuration, only one rule can write to each state but found multiple writing to state: %s", state)
+		}
+	}
+
+	// Validate each state implements the interfaces its rule requires
+	for ruleName, rule := range config.Rules {
+		if rule.State == "" {
+			continue
+		}
+		if err := rule.validateState(ruleName, config.States[rule.State]); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// warnUnknownEventTypes warns about rules subscribing to event types that no event type provides
+func warnUnknownEventTypes(config pipelineConfig, eventTypes []eventType) {
+	var subscribed bool
+	for _, rule := range config.Rules {
+		subscribed = subscribed || len(rule.EventTypes) > 0
+	}
+	if !subscribed {
+		return
+	}
+	pluginEventTypes, err := getEventTypes(config.EventFolder)
+	if err != nil {
+		log.Warnf("Unable to load event types to validate rule subscriptions: %v", err)
+		return
+	}
+	known := make(map[string]bool)
+	for _, et := range append(eventTypes, pluginEventTypes...) {
+		known[et.Name()] = true
+	}
+	for ruleName, rule := range config.Rules {
+		for _, name := range rule.EventTypes {
+			if !known[name] {
+				log.Warnf("Rule %s subscribes to event type %s, but no event type provides it", ruleName, name)
+			}
+		}
+	}
+}
+
+// findSinkCycle returns the name of a sink that forwards events back to itself
+func findSinkCycle(sinks map[string]output.SinkConfig) string {
+	visiting := make(map[string]bool)
+	visited := make(map[string]bool)
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		if visiting[name] {
+			return true
+		}
+		if visited[name] {
+			return false
+		}
+		visiting[name] = true
+		for _, downstream := range sinks[name].Downstream() {
+			if visit(downstream) {
+				return true
+			}
+		}
+		visiting[name] = false
+		visited[name] = true
+		return false
+	}
+	for name := range sinks {
+		if visit(name) {
+			return name
+		}
+	}
+	return ""
+}
+
 func findDuplicates(s []reflect.Value) []string {
 	var result []string
 	strings := make(map[string]bool)
@@ -101,11 +191,18 @@ func findDuplicates(s []reflect.Value) []string {
 
 // pipeline is a Directed Acyclic Graph
 type pipeline struct {
+	sync.Mutex
+	// reloadLock serialises reloads, so their changes to rules and config don't interleave
+	reloadLock    sync.Mutex
 	ID            uuid.UUID
 	Name          string
 	Config        []byte
+	Revision      int
 	Nodes         map[string]*pipelineNode
 	eventFolder   string
+	config        pipelineConfig
+	states        map[string]state.State
+	stateStatuses map[string]stateStatus
 	pipelineReady bool
 	mService      monitoringService
 }
@@ -119,11 +216,11 @@ func (p *pipeline) addEdge(from, to *pipelineNode) {
 	to.AddParent(from)
 }
 
-func (p *pipeline) sources() []*pipelineNode {
-	var sources []*pipelineNode
-	for _, node := range p.Nodes {
-		if node.InDegree() == 0 {
-			sources = append(sources, node)
+func (p *pipeline) sources() map[string]*pipelineNode {
+	sources := make(map[string]*pipelineNode)
+	for nodeName, node := range p.Nodes {
+		if _, ok := node.value.(input.Source); ok {
+			sources[nodeName] = node
 		}
 	}
 	return sources
@@ -132,18 +229,19 @@ func (p *pipeline) sources() []*pipelineNode {
 func (p *pipeline) internals() map[string]*pipelineNode {
 	internals := make(map[string]*pipelineNode)
 	for nodeName, node := range p.Nodes {
-		if node.OutDegree() != 0 && node.InDegree() != 0 {
+		if _, ok := node.value.(Rule); ok {
 			internals[nodeName] = node
 		}
 	}
 	return internals
 }
 
-func (p *pipeline) sinks() []*pipelineNode {
-	var sinks []*pipelineNode
-	for _, node := range p.Nodes {
-		if node.OutDegree() == 0 {
-			sinks = append(sinks, node)
+// sinks returns all output nodes, including those that forward to other sinks
+func (p *pipeline) sinks() map[string]*pipelineNode {
+	sinks := make(map[string]*pipelineNode)
+	for nodeName, node := range p.Nodes {
+		if _, ok := node.value.(output.Sink); ok {
+			sinks[nodeName] = node
 		}
 	}
 	return sinks
@@ -155,6 +253,7 @@ type pipelineNodeAPI interface {
 }
 
 type pipelineNode struct {
+	sync.RWMutex
 	inputChan     *chan interface{}
 	outputChan    *chan interface{}
 	value         pipelineNodeAPI
@@ -162,6 +261,8 @@ type pipelineNode struct {
 	parents       []*pipelineNode
 	windowManager *windowManager
 	pipelineName  string
+	eventTypes    map[string]bool
+	state         state.State
 }
 
 func (node *pipelineNode) Init() error {
@@ -196,6 +297,24 @@ func (node *pipelineNode) OutDegree() int {
 	return len(node.children)
 }
 
+// rule returns the rule of a rule node, which may be swapped when the pipeline is reloaded
+func (node *pipelineNode) rule() Rule {
+	node.RLock()
+	defer node.RUnlock()
+	return node.value.(Rule)
+}
+
+// Accepts returns false for events of a type the node hasn't subscribed to
+func (node *pipelineNode) Accepts(evt interface{}) bool {
+	node.RLock()
+	defer node.RUnlock()
+	if len(node.eventTypes) == 0 {
+		return true
+	}
+	e, ok := evt.(event.Event)
+	return !ok || node.eventTypes[e.TypeName()]
+}
+
 func makeSource(sourceConfig input.SourceConfig, sourceImpl input.SourceIface, name string) (*pipelineNode, error) {
 	sourceChan := make(chan interface{})
 	source, err := sourceImpl.Create(sourceConfig)
@@ -211,6 +330,7 @@ func makeSource(sourceConfig input.SourceConfig, sourceImpl input.SourceIface, n
 
 func makeSink(sinkConfig output.SinkConfig, sinkImpl output.SinkIface, name string) (*pipelineNode, error) {
 	sinkChan := make(chan interface{})
+	sinkConfig.Pipeline = name
 	sink, err := sinkImpl.Create(sinkConfig)
 	if err != nil {
 		return nil, err
@@ -227,6 +347,12 @@ type pipelineManager struct {
 	Backend    backend
 	sourceImpl input.SourceIface
 	sinkImpl   output.SinkIface
+	sync.Mutex
+	// pipelines are the pipelines created by the manager, by ID
+	pipelines map[string]*pipeline
+	// restrictProcessCommands limits process rules to running processCommands, for pipelines submitted through the API
+	restrictProcessCommands bool
+	processCommands         []string
 }
 
 func (pM *pipelineManager) Init() error {
@@ -246,15 +372,24 @@ func (pM *pipelineManager) Init() error {
 	return pM.Backend.Init()
 }
 
-func (pM *pipelineManager) Store(p *pipeline) error {
-	return pM.Backend.Store(p)
+// Store stores the pipeline's config as a new revision, recording the author and comment of the change
+func (pM *pipelineManager) Store(p *pipeline, change revision) error {
+	change.Timestamp = time.Now().UTC()
+	change.Config = p.Config
+	if err := pM.Backend.Store(p, &change); err != nil {
+		return err
+	}
+	p.Lock()
+	p.Revision = change.Number
+	p.Unlock()
+	return nil
 }
 
 func (pM *pipelineManager) Get(uuid []byte) ([]byte, error) {
 	return pM.Backend.Get(uuid)
 }
 
-func (pM *pipelineManager) NewPipeline(rawConfig []byte, mService monitoringService) (*pipeline, error) {
+func (pM *pipelineManager) NewPipeline(rawConfig []byte, mService monitoringService, change revision) (*pipeline, error) {
 	log.Debugln("Creating new pipeline")
 	config, err := parseConfig(rawConfig)
 	if err != nil {
@@ -263,14 +398,20 @@ func (pM *pipelineManager) NewPipeline(rawConfig []byte, mService monitoringServ
 	if err := validateConfig(config); err != nil {
 		return nil, fmt.Errorf("Error validating config %s", err)
 	}
+	if err := pM.checkProcessCommands(config); err != nil {
+		return nil, fmt.Errorf("Error validating config %s", err)
+	}
 
 	pipe := &pipeline{
-		Name:        config.Name,
-		ID:          uuid.New(),
-		Config:      rawConfig,
-		eventFolder: config.EventFolder,
-		Nodes:       make(map[string]*pipelineNode),
-		mService:    mService,
+		Name:          config.Name,
+		ID:            uuid.New(),
+		Config:        rawConfig,
+		eventFolder:   config.EventFolder,
+		config:        config,
+		Nodes:         make(map[string]*pipelineNode),
+		states:        make(map[string]state.State),
+		stateStatuses: make(map[string]stateStatus),
+		mService:      mService,
 	}
 
 	for sourceName, sourceConfig := range config.Sources {
@@ -289,25 +430,50 @@ func (pM *pipelineManager) NewPipeline(rawConfig []byte, mService monitoringServ
 		pipe.addVertex(sinkName, sink)
 	}
 
+	// States are initialised before the rules that use them, once even if they're shared
+	for stateName, stateConfig := range config.States {
+		s, err := state.Create(stateConfig)
+		if err != nil {
+			pipe.closeStates()
+			return nil, fmt.Errorf("Error creating state %s", err)
+		}
+		if kv, ok := s.(*state.KVStore); ok {
+			name := stateName
+			kv.OnSweep(func(stats state.KVStats) {
+				mService.setStateKeys(config.Name, name, stats.Live, stats.Expired)
+			})
+		}
+		if err = s.Init(); err != nil {
+			pipe.closeStates()
+			return nil, fmt.Errorf("Error initialising state %s: %v", stateName, err)
+		}
+		pipe.states[stateName] = s
+		pipe.openState(stateName, stateStatus{Type: stateConfig.Type})
+	}
+
 	for ruleName, ruleConfig := range config.Rules {
-		var ruleState state.State
-		var err error
-		if ruleConfig.State != "" {
-			ruleState, err = state.Create(config.States[ruleConfig.State])
+		ruleState := pipe.states[ruleConfig.State]
+		if ruleState != nil && ruleConfig.StateAccess == readOnlyStateAccess {
+			ruleState, err = state.ReadOnly(ruleState)
 			if err != nil {
+				pipe.closeStates()
 				return nil, fmt.Errorf("Error creating rule state %s", err)
 			}
-		} else {
-			ruleState = nil
 		}
 
-		rule, err := newRule(ruleConfig, ruleState)
+		rule, err := newRule(ruleName, ruleConfig, ruleState)
 		if err != nil {
+			pipe.closeStates()
 			return nil, fmt.Errorf("Error creating rule %s", err)
 		}
 		ruleNode := &pipelineNode{
 			value:        rule,
 			pipelineName: config.Name,
+			eventTypes:   make(map[string]bool),
+			state:        ruleState,
+		}
+		for _, name := range ruleConfig.EventTypes {
+			ruleNode.eventTypes[name] = true
 		}
 		pipe.addVertex(ruleName, ruleNode)
 	}
@@ -321,19 +487,42 @@ func (pM *pipelineManager) NewPipeline(rawConfig []byte, mService monitoringServ
 		pipe.addEdge(pipe.Nodes[ruleConfig.Source], ruleNode)
 	}
 
-	err = pM.Store(pipe)
+	for sinkName, sinkConfig := range config.Sinks {
+		for _, downstream := range sinkConfig.Downstream() {
+			pipe.addEdge(pipe.Nodes[sinkName], pipe.Nodes[downstream])
+		}
+	}
+
+	err = pM.Store(pipe, change)
 	if err != nil {
+		pipe.closeStates()
 		return nil, fmt.Errorf("Error storing pipeline %s", err)
 	}
 
+	pM.Lock()
+	if pM.pipelines == nil {
+		pM.pipelines = make(map[string]*pipeline)
+	}
+	pM.pipelines[pipe.ID.String()] = pipe
+	pM.Unlock()
 	return pipe, nil
 }
 
 func (p *pipeline) StartPipeline() error {
-	for _, sink := range p.sinks() {
-		sVal, ok := sink.value.(output.Sink)
-		if !ok {
-			return fmt.Errorf("Expected %s to implement the Sink interface", sink.value)
+	for sinkName, sink := range p.sinks() {
+		sVal := sink.value.(output.Sink)
+		if forwarder, ok := sVal.(output.Forwarder); ok {
+			downstream := make(map[string]*chan interface{})
+			for _, name := range forwarder.Downstream() {
+				downstream[name] = p.Nodes[name].inputChan
+			}
+			forwarder.SetDownstream(downstream)
+		}
+		if throttler, ok := sVal.(output.Throttler); ok {
+			name := sinkName
+			throttler.OnThrottle(func() {
+				p.mService.incrEventThrottled(p.Name, name)
+			})
 		}
 		err := output.StartOutput(sVal, sink.inputChan)
 		if err != nil {
@@ -349,28 +538,40 @@ func (p *pipeline) StartPipeline() error {
 		(*rule).windowManager = &windowManager{
 			sinkChan: rule.outputChan,
 			rule:     rVal,
+			interval: rVal.WindowInterval(),
 		}
-		(*rule).inputChan = startRule(rVal, rule.outputChan, rule.windowManager)
+		(*rule).inputChan = startRule(rule)
 		for _, child := range rule.Children() {
 			go runRule(child, rule)
 		}
 	}
 
-	eventTypes, err := getEventTypes(p.eventFolder)
+	eventTypes, err := createEventTypes(p.config.EventTypes)
+	if err != nil {
+		return err
+	}
+	pluginEventTypes, err := getEventTypes(p.eventFolder)
 	if err != nil {
 		log.Fatalf("Failed to get Event plugins: %v", err)
 	}
+	eventTypes = append(eventTypes, pluginEventTypes...)
 
-	for _, source := range p.sources() {
+	for sourceName, source := range p.sources() {
+		router, err := newEventTypeRouter(eventTypes, p.config.Sources[sourceName])
+		if err != nil {
+			return fmt.Errorf("Invalid event types for source %s: %v", sourceName, err)
+		}
 		sVal := source.value.(input.Source)
-		err := input.StartInput(sVal, source.outputChan)
+		err = input.StartInput(sVal, source.outputChan)
 		if err != nil {
 			return err
 		}
-		go runSource(source, eventTypes, p.mService)
+		go runSource(source, router, p.mService)
 	}
 
+	p.Lock()
 	p.pipelineReady = true
+	p.Unlock()
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -386,20 +587,24 @@ func (p *pipeline) StartPipeline() error {
 
 func runRule(sink *pipelineNode, source *pipelineNode) {
 	for evt := range *source.outputChan {
-		*sink.inputChan <- evt
+		if sink.Accepts(evt) {
+			*sink.inputChan <- evt
+		}
 	}
 }
 
-func runSource(source *pipelineNode, eventTypes []eventType, mService monitoringService) {
+func runSource(source *pipelineNode, router *eventTypeRouter, mService monitoringService) {
 	for data := range *source.outputChan {
-		evt, err := matchEventType(eventTypes, data)
+		evt, err := router.match(data, source.pipelineName, mService)
 		if err != nil {
 			log.Infof("Error matching event: %v %v", err, data)
 			continue
 		}
 		for _, node := range source.Children() {
 			mService.incrEventReceived(source.pipelineName)
-			*node.inputChan <- evt
+			if node.Accepts(evt) {
+				*node.inputChan <- evt
+			}
 		}
 	}
 }
@@ -417,11 +622,44 @@ func (p *pipeline) Close() {
 		i.Close()
 	}
 
+	// Wait for any event or window being processed, so a state isn't closed while a rule is using it
+	for _, i := range p.internals() {
+		i.Lock()
+		i.Unlock()
+		if i.windowManager != nil {
+			i.windowManager.Lock()
+			i.windowManager.Unlock()
+		}
+	}
+	log.Debug("Closing states\n")
+	p.closeStates()
+	p.Lock()
+	p.pipelineReady = false
+	p.Unlock()
+
 	log.Debug("Closing output channels\n")
-	for _, o := range p.sinks() {
-		close(*o.inputChan)
-		o.Close()
+	// Sinks that forward events must be closed before the sinks they forward to
+	sinks := p.sinks()
+	closed := make(map[*pipelineNode]bool)
+	for len(closed) < len(sinks) {
+		for _, o := range sinks {
+			if closed[o] || !parentSinksClosed(o, closed) {
+				continue
+			}
+			close(*o.inputChan)
+			o.Close()
+			closed[o] = true
+		}
+	}
+}
+
+func parentSinksClosed(node *pipelineNode, closed map[*pipelineNode]bool) bool {
+	for _, parent := range node.Parents() {
+		if _, ok := parent.value.(output.Sink); ok && !closed[parent] {
+			return false
+		}
 	}
+	return true
 }
 
 func startBoltDB(databaseName string, bucketName string) (*bolt.DB, error) {
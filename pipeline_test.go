@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/patrobinson/go-fish/event"
 	"github.com/patrobinson/go-fish/input"
 	"github.com/patrobinson/go-fish/output"
 	"github.com/patrobinson/go-fish/state"
@@ -113,7 +114,7 @@ func TestNewPipeline(t *testing.T) {
 		t.Fatalf("Error creating Pipeline Manager: %s", err)
 	}
 
-	_, err = pManager.NewPipeline(makePipeline(basicRuleConfig, "newPipeline.db"), makeMonitoringService())
+	_, err = pManager.NewPipeline(makePipeline(basicRuleConfig, "newPipeline.db"), makeMonitoringService(), revision{})
 	if err != nil {
 		t.Errorf("Error creating new pipeline: %s", err)
 	}
@@ -200,11 +201,137 @@ func TestNewPipelineWithMultipleRulesUsingState(t *testing.T) {
 	}
 
 	err := validateConfig(pConfig)
-	if err == nil || err.Error() != "Invalid rule configuration, only one rule can use each state but found multiple use state: aState" {
+	if err == nil || err.Error() != "Invalid rule configuration, only one rule can write to each state but found multiple writing to state: aState" {
 		t.Errorf("Expected pipeline with invalid state to riase error, but go %v", err)
 	}
 }
 
+func TestValidateStateRequirements(t *testing.T) {
+	pConfig := pipelineConfig{
+		EventFolder: "testdata/eventTypes",
+		Rules: map[string]ruleConfig{
+			"aRule": {
+				Source: "aSource",
+				State:  "aState",
+				Plugin: "testdata/rules/stateful.so",
+			},
+		},
+		States: map[string]state.Config{
+			"aState": {
+				Type: "Count",
+			},
+		},
+		Sources: map[string]input.SourceConfig{
+			"aSource": {
+				Type: "File",
+				FileConfig: input.FileConfig{
+					Path: "testdata/pipelines/input",
+				},
+			},
+		},
+	}
+
+	err := validateConfig(pConfig)
+	if err == nil || err.Error() != "Rule aRule requires a KeyValue state, but aState is a Count state" {
+		t.Errorf("Expected a rule with the wrong type of state to raise an error, got %v", err)
+	}
+
+	pConfig.States["aState"] = state.Config{Type: "KV"}
+	if err = validateConfig(pConfig); err != nil {
+		t.Errorf("Expected a rule with a KV state to be valid, got %s", err)
+	}
+
+	pConfig.States["aState"] = state.Config{Type: "Unknown"}
+	if err = validateConfig(pConfig); err == nil || err.Error() != "Invalid state for rule aRule: Invalid state type: Unknown" {
+		t.Errorf("Expected an unknown state type to raise an error, got %v", err)
+	}
+}
+
+func TestNewPipelineWithReadOnlyState(t *testing.T) {
+	pManager := &pipelineManager{
+		backendConfig: backendConfig{
+			Type: "boltdb",
+			BoltDBConfig: boltDBConfig{
+				BucketName:   "TestNewPipelineWithReadOnlyState",
+				DatabaseName: "read_only_pipeline.db",
+			},
+		},
+	}
+	if err := pManager.Init(); err != nil {
+		t.Fatalf("Error creating Pipeline Manager: %s", err)
+	}
+	defer os.Remove("read_only_pipeline.db")
+	defer os.Remove("read_only_state.db")
+
+	pConfig := pipelineConfig{
+		EventFolder: "testdata/eventTypes",
+		Rules: map[string]ruleConfig{
+			"writer": {
+				Source: "aSource",
+				State:  "lookup",
+				Plugin: "testdata/rules/stateful.so",
+				Sink:   "aSink",
+			},
+			"reader": {
+				Source:      "aSource",
+				State:       "lookup",
+				StateAccess: "readOnly",
+				Plugin:      "testdata/rules/stateful.so",
+				Sink:        "aSink",
+			},
+		},
+		States: map[string]state.Config{
+			"lookup": {
+				Type:     "KV",
+				KVConfig: state.KVConfig{DbFileName: "read_only_state.db", BucketName: "lookup"},
+			},
+		},
+		Sources: map[string]input.SourceConfig{
+			"aSource": {
+				Type:       "File",
+				FileConfig: input.FileConfig{Path: "testdata/pipelines/input"},
+			},
+		},
+		Sinks: map[string]output.SinkConfig{
+			"aSink": {
+				Type:       "File",
+				FileConfig: output.FileConfig{Path: "testdata/output"},
+			},
+		},
+	}
+	rawConfig, _ := json.Marshal(pConfig)
+	pipe, err := pManager.NewPipeline(rawConfig, makeMonitoringService(), revision{})
+	if err != nil {
+		t.Fatalf("Error creating pipeline with a read only state: %s", err)
+	}
+	defer pipe.closeStates()
+
+	writer := pipe.Nodes["writer"].state.(state.KeyValue)
+	reader := pipe.Nodes["reader"].state.(state.KeyValue)
+	if writer != pipe.states["lookup"] {
+		t.Errorf("Expected the writer to be given the pipeline's state")
+	}
+	if err := writer.Set([]byte("role"), []byte("bob")); err != nil {
+		t.Errorf("Error writing to state %s", err)
+	}
+	if value := reader.Get([]byte("role")); string(value) != "bob" {
+		t.Errorf("Expected the reader to read the writer's value, got %s", value)
+	}
+	if err := reader.Set([]byte("role"), []byte("mallory")); err != state.ErrReadOnly {
+		t.Errorf("Expected the reader to not be able to write, got %v", err)
+	}
+
+	pConfig.Rules["reader"] = ruleConfig{Source: "aSource", State: "lookup", StateAccess: "write", Plugin: "testdata/rules/stateful.so", Sink: "aSink"}
+	if err = validateConfig(pConfig); err == nil || err.Error() != "Invalid state access for rule reader: write" {
+		t.Errorf("Expected an invalid state access to raise an error, got %v", err)
+	}
+	pConfig.Rules["reader"] = ruleConfig{Source: "aSource", State: "counter", StateAccess: "readOnly", Plugin: "testdata/rules/a.so", Sink: "aSink"}
+	pConfig.States["counter"] = state.Config{Type: "Count"}
+	if err = validateConfig(pConfig); err == nil || err.Error() != "Invalid state for rule reader: *state.MemoryCounter state can't be read only" {
+		t.Errorf("Expected a read only Count state to raise an error, got %v", err)
+	}
+}
+
 func TestStartBasicPipeline(t *testing.T) {
 	pManager := &pipelineManager{
 		backendConfig: backendConfig{
@@ -219,7 +346,7 @@ func TestStartBasicPipeline(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error creating Pipeline Manager: %s", err)
 	}
-	p, err := pManager.NewPipeline(makePipeline(basicRuleConfig, "basicPipeline.db"), makeMonitoringService())
+	p, err := pManager.NewPipeline(makePipeline(basicRuleConfig, "basicPipeline.db"), makeMonitoringService(), revision{})
 	if err != nil {
 		t.Fatalf("Error creating new pipeline: %s", err)
 	}
@@ -251,7 +378,7 @@ func TestStartForwardPipeline(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error creating Pipeline Manager: %s", err)
 	}
-	p, err := pManager.NewPipeline(makePipeline(pipelineRuleConfig, "forwardPipeline.db"), makeMonitoringService())
+	p, err := pManager.NewPipeline(makePipeline(pipelineRuleConfig, "forwardPipeline.db"), makeMonitoringService(), revision{})
 	if err != nil {
 		t.Fatalf("Error creating new pipeline: %s", err)
 	}
@@ -263,3 +390,43 @@ func TestStartForwardPipeline(t *testing.T) {
 	}()
 	time.Sleep(1 * time.Second)
 }
+
+func TestNewPipelineWithInvalidDownstreamSink(t *testing.T) {
+	pConfig := pipelineConfig{
+		Sinks: map[string]output.SinkConfig{
+			"router": {
+				Type: "Router",
+				RouterConfig: output.RouterConfig{
+					Routes:  []output.Route{{Level: "error", Sink: "pager"}},
+					Default: "router",
+				},
+			},
+		},
+	}
+	err := validateConfig(pConfig)
+	if err == nil || err.Error() != "Invalid downstream sink for sink router: pager" {
+		t.Errorf("Expected pipeline with invalid downstream sink to raise error, but got %v", err)
+	}
+
+	pConfig.Sinks["pager"] = output.SinkConfig{Type: "File"}
+	err = validateConfig(pConfig)
+	if err == nil || err.Error() != "Invalid sink configuration, sink router forwards to itself" {
+		t.Errorf("Expected pipeline with a sink cycle to raise error, but got %v", err)
+	}
+}
+
+func TestPipelineNodeAcceptsSubscribedEventTypes(t *testing.T) {
+	node := &pipelineNode{eventTypes: map[string]bool{"cloudTrail": true}}
+	if !node.Accepts(event.Generic{Type: "cloudTrail"}) {
+		t.Error("Expected node to accept an event type it subscribes to")
+	}
+	if node.Accepts(event.Generic{Type: "certStream"}) {
+		t.Error("Expected node not to accept an event type it doesn't subscribe to")
+	}
+	if !node.Accepts(output.OutputEvent{}) {
+		t.Error("Expected node to accept values that aren't events")
+	}
+	if !(&pipelineNode{}).Accepts(event.Generic{Type: "certStream"}) {
+		t.Error("Expected node without subscriptions to accept every event")
+	}
+}
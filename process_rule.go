@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/patrobinson/go-fish/event"
+	"github.com/patrobinson/go-fish/output"
+	"github.com/patrobinson/go-fish/ruleHelpers"
+	log "github.com/sirupsen/logrus"
+)
+
+// restartBackoff is multiplied by the number of consecutive restarts to delay restarting a rule process
+var restartBackoff = 500 * time.Millisecond
+
+// processRule runs a rule as an external executable, exchanging one JSON message per line over its stdin and stdout.
+// The protocol is implemented for Go rules by rulehelpers.ServeProcess.
+// If the process exits or doesn't reply within TimeoutMs it's restarted, up to MaxRestarts times in a row, and the request is retried.
+type processRule struct {
+	sync.Mutex
+	config   ruleConfig
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	name     string
+	interval int
+	restarts int
+	failed   error
+}
+
+// processReply is a rulehelpers.ProcessResponse whose result is decoded once its type is known
+type processReply struct {
+	rulehelpers.ProcessResponse
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+func newProcessRule(config ruleConfig) *processRule {
+	if config.MaxRestarts == 0 {
+		config.MaxRestarts = 3
+	}
+	if config.TimeoutMs == 0 {
+		config.TimeoutMs = 5000
+	}
+	return &processRule{config: config}
+}
+
+// checkProcessCommands returns an error if the manager restricts process rules and a rule runs a command it doesn't allow.
+// Commands must match exactly, so that a pipeline submitted through the API can't run arbitrary executables.
+func (pM *pipelineManager) checkProcessCommands(config pipelineConfig) error {
+	if !pM.restrictProcessCommands {
+		return nil
+	}
+	for ruleName, rule := range config.Rules {
+		if rule.Type != processRuleType {
+			continue
+		}
+		var allowed bool
+		for _, command := range pM.processCommands {
+			allowed = allowed || rule.Command == command
+		}
+		if !allowed {
+			return fmt.Errorf("Process rule %s can't run %s, it isn't one of the API server's processRuleCommands", ruleName, rule.Command)
+		}
+	}
+	return nil
+}
+
+// Init starts the process, process rules can't use a State
+func (r *processRule) Init(...interface{}) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.start()
+}
+
+func (r *processRule) start() error {
+	cmd := exec.Command(r.config.Command, r.config.Args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("Unable to start rule process %s: %v", r.config.Command, err)
+	}
+	r.cmd = cmd
+	r.stdin = stdin
+	r.stdout = bufio.NewReader(stdout)
+
+	reply, err := r.roundTrip(rulehelpers.ProcessRequest{Method: "init", Config: r.config.Config})
+	if err == nil && reply.Error != "" {
+		err = errors.New(reply.Error)
+	}
+	if err != nil {
+		r.stop()
+		return fmt.Errorf("Unable to initialise rule process %s: %v", r.config.Command, err)
+	}
+	r.name = reply.Name
+	r.interval = reply.WindowInterval
+	return nil
+}
+
+// stop closes the process's stdin and waits for it to exit, killing it if it doesn't within 5 seconds
+func (r *processRule) stop() {
+	if r.cmd == nil {
+		return
+	}
+	r.stdin.Close()
+	done := make(chan struct{})
+	go func(cmd *exec.Cmd) {
+		cmd.Wait()
+		close(done)
+	}(r.cmd)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		r.cmd.Process.Kill()
+		<-done
+	}
+	r.cmd = nil
+}
+
+// roundTrip sends a request and reads the reply, killing the process if it doesn't reply within the timeout.
+// The process is then restarted by call, or stopped by start or Close.
+func (r *processRule) roundTrip(request rulehelpers.ProcessRequest) (processReply, error) {
+	var reply processReply
+	message, err := json.Marshal(request)
+	if err != nil {
+		return reply, err
+	}
+
+	type response struct {
+		line []byte
+		err  error
+	}
+	// Buffered so the goroutine can exit once the killed process's pipes are closed
+	done := make(chan response, 1)
+	go func(stdin io.Writer, stdout *bufio.Reader) {
+		if _, err := stdin.Write(append(message, '\n')); err != nil {
+			done <- response{err: err}
+			return
+		}
+		line, err := stdout.ReadBytes('\n')
+		done <- response{line, err}
+	}(r.stdin, r.stdout)
+
+	timeout := time.Duration(r.config.TimeoutMs) * time.Millisecond
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return reply, res.err
+		}
+		err = json.Unmarshal(res.line, &reply)
+		return reply, err
+	case <-time.After(timeout):
+		r.cmd.Process.Kill()
+		return reply, fmt.Errorf("Rule process %s didn't reply to %s within %v", r.config.Command, request.Method, timeout)
+	}
+}
+
+// call sends a request to the process, restarting it and retrying the request if it has exited
+func (r *processRule) call(request rulehelpers.ProcessRequest) (processReply, error) {
+	r.Lock()
+	defer r.Unlock()
+	for {
+		if r.failed != nil {
+			return processReply{}, r.failed
+		}
+		if r.cmd == nil {
+			if err := r.start(); err != nil {
+				return processReply{}, err
+			}
+		}
+		reply, err := r.roundTrip(request)
+		if err == nil {
+			r.restarts = 0
+			if reply.Error != "" {
+				return reply, errors.New(reply.Error)
+			}
+			return reply, nil
+		}
+
+		r.stop()
+		if r.restarts >= r.config.MaxRestarts {
+			r.failed = fmt.Errorf("Rule process %s failed after %d restarts: %v", r.config.Command, r.restarts, err)
+			return reply, r.failed
+		}
+		r.restarts++
+		log.Warnf("Restarting rule process %s: %v", r.config.Command, err)
+		time.Sleep(time.Duration(r.restarts) * restartBackoff)
+	}
+}
+
+// Process sends the event to the process, events decoded by built in event types are sent as their fields.
+// A JSON object result is an output.OutputEvent.
+func (r *processRule) Process(evt interface{}) interface{} {
+	request := rulehelpers.ProcessRequest{Method: "process"}
+	var value interface{} = evt
+	if e, ok := evt.(event.Event); ok {
+		request.EventType = e.TypeName()
+	}
+	if g, ok := evt.(event.Generic); ok {
+		value = g.Fields
+	}
+	var err error
+	if request.Event, err = json.Marshal(value); err != nil {
+		log.Errorf("Unable to encode event for rule %s: %v", r.String(), err)
+		return nil
+	}
+
+	reply, err := r.call(request)
+	if err != nil {
+		log.Errorf("Error processing event with rule %s: %v", r.String(), err)
+		return nil
+	}
+	if len(reply.Result) == 0 || string(reply.Result) == "null" {
+		return nil
+	}
+	if reply.Result[0] == '{' {
+		var result output.OutputEvent
+		if err = json.Unmarshal(reply.Result, &result); err != nil {
+			log.Errorf("Invalid result from rule %s: %v", r.String(), err)
+			return nil
+		}
+		return result
+	}
+	var result interface{}
+	if err = json.Unmarshal(reply.Result, &result); err != nil {
+		log.Errorf("Invalid result from rule %s: %v", r.String(), err)
+		return nil
+	}
+	return result
+}
+
+func (r *processRule) String() string {
+	if r.name != "" {
+		return r.name
+	}
+	return r.config.Command
+}
+
+func (r *processRule) WindowInterval() int {
+	return r.interval
+}
+
+func (r *processRule) Window() ([]output.OutputEvent, error) {
+	reply, err := r.call(rulehelpers.ProcessRequest{Method: "window"})
+	return reply.Events, err
+}
+
+// Close asks the process to close the rule and waits for it to exit
+func (r *processRule) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.cmd == nil {
+		return nil
+	}
+	reply, err := r.roundTrip(rulehelpers.ProcessRequest{Method: "close"})
+	r.stop()
+	if err == nil && reply.Error != "" {
+		err = errors.New(reply.Error)
+	}
+	return err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/patrobinson/go-fish/event"
+	"github.com/patrobinson/go-fish/output"
+	"github.com/patrobinson/go-fish/ruleHelpers"
+)
+
+// loginRule is served by the test binary when it's started as a rule process.
+// It crashes the first time it sees a user called crash, using a file to remember it has.
+type loginRule struct {
+	threshold int
+	failures  int
+}
+
+func (r *loginRule) Init(args ...interface{}) error {
+	var config struct{ Threshold int }
+	if err := json.Unmarshal(args[0].(json.RawMessage), &config); err != nil {
+		return err
+	}
+	r.threshold = config.Threshold
+	return nil
+}
+
+func (r *loginRule) Process(evt interface{}) interface{} {
+	login := evt.(event.Generic)
+	user, _ := login.Get("user")
+	if user == "crash" {
+		if _, err := os.Stat(os.Getenv("GO_FISH_CRASH_FILE")); os.IsNotExist(err) {
+			ioutil.WriteFile(os.Getenv("GO_FISH_CRASH_FILE"), nil, 0644)
+			os.Exit(1)
+		}
+	}
+	r.failures++
+	if r.failures < r.threshold {
+		return false
+	}
+	return output.OutputEvent{
+		Name:      "RepeatedLoginFailure",
+		EventType: login.TypeName(),
+		Entity:    fmt.Sprint(user),
+		Level:     output.WarnLevel,
+	}
+}
+
+func (r *loginRule) String() string      { return "loginRule" }
+func (r *loginRule) WindowInterval() int { return 60 }
+
+func (r *loginRule) Window() ([]output.OutputEvent, error) {
+	return []output.OutputEvent{{Name: "Failures", Occurrences: r.failures}}, nil
+}
+
+func (r *loginRule) Close() error { return nil }
+
+func serveTestProcessRule() {
+	if err := rulehelpers.ServeProcess(&loginRule{}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestProcessRule(t *testing.T) {
+	crashFile, err := ioutil.TempFile("", "crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(crashFile.Name())
+	defer os.Remove(crashFile.Name())
+	os.Setenv("GO_FISH_RULE_PROCESS", "1")
+	os.Setenv("GO_FISH_CRASH_FILE", crashFile.Name())
+	defer os.Unsetenv("GO_FISH_RULE_PROCESS")
+	restartBackoff = time.Millisecond
+
+	rule, err := newRule("loginRule", ruleConfig{
+		Type:    processRuleType,
+		Command: os.Args[0],
+		Config:  json.RawMessage(`{"Threshold": 2}`),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Error creating process rule: %s", err)
+	}
+	if rule.String() != "loginRule" || rule.WindowInterval() != 60 {
+		t.Errorf("Expected rule name and window interval from process, got %s %d", rule.String(), rule.WindowInterval())
+	}
+
+	if result := rule.Process(event.Generic{Type: "login", Fields: map[string]interface{}{"user": "bob"}}); result != false {
+		t.Errorf("Expected false below the threshold, got %v", result)
+	}
+	// The rule exits, is restarted and the event is retried, losing its count of failures
+	for i := 0; i < 2; i++ {
+		result := rule.Process(event.Generic{Type: "login", Fields: map[string]interface{}{"user": "crash"}})
+		if i == 1 {
+			expected := output.OutputEvent{Name: "RepeatedLoginFailure", EventType: "login", Entity: "crash", Level: output.WarnLevel}
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("Expected %v, got %v", expected, result)
+			}
+		}
+	}
+	events, err := rule.Window()
+	if err != nil || len(events) != 1 || events[0].Occurrences != 2 {
+		t.Errorf("Unexpected window %v %v", events, err)
+	}
+	if err := rule.Close(); err != nil {
+		t.Errorf("Error closing rule: %s", err)
+	}
+}
+
+func TestProcessRuleExceedsRestarts(t *testing.T) {
+	restartBackoff = time.Millisecond
+	rule := newProcessRule(ruleConfig{Type: processRuleType, Command: "true"})
+	if err := rule.Init(); err == nil {
+		t.Fatal("Expected a process that doesn't reply to init to raise an error")
+	}
+
+	rule = newProcessRule(ruleConfig{Type: processRuleType, Command: "sh", Args: []string{"-c", `echo '{"name": "once"}'; read line`}})
+	if err := rule.Init(); err != nil {
+		t.Fatalf("Error initialising rule: %s", err)
+	}
+	if result := rule.Process(event.Generic{}); result != nil {
+		t.Errorf("Expected no result from a process that exits, got %v", result)
+	}
+	if _, err := rule.Window(); err == nil || rule.restarts != 3 {
+		t.Errorf("Expected rule to fail after 3 restarts, got %v after %d", err, rule.restarts)
+	}
+}
+
+func TestProcessRuleTimeout(t *testing.T) {
+	restartBackoff = time.Millisecond
+	rule := newProcessRule(ruleConfig{
+		Type:        processRuleType,
+		Command:     "sh",
+		Args:        []string{"-c", `echo '{"name": "hung"}'; exec sleep 10`},
+		MaxRestarts: 1,
+		TimeoutMs:   100,
+	})
+	if err := rule.Init(); err != nil {
+		t.Fatalf("Error initialising rule: %s", err)
+	}
+	start := time.Now()
+	if _, err := rule.Window(); err == nil || rule.restarts != 1 {
+		t.Errorf("Expected a hung rule to be killed and restarted, got %v after %d restarts", err, rule.restarts)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected a hung rule to time out, took %v", elapsed)
+	}
+	if err := rule.Close(); err != nil {
+		t.Errorf("Error closing rule: %s", err)
+	}
+}
+
+func TestCheckProcessCommands(t *testing.T) {
+	config := pipelineConfig{Rules: map[string]ruleConfig{
+		"loginRule": {Type: processRuleType, Command: "sh", Args: []string{"-c", "rm -rf /"}},
+		"aRule":     {Plugin: "testdata/rules/a.so"},
+	}}
+	pManager := &pipelineManager{}
+	if err := pManager.checkProcessCommands(config); err != nil {
+		t.Errorf("Expected an unrestricted manager to allow any command, got %s", err)
+	}
+
+	pManager = &pipelineManager{restrictProcessCommands: true, processCommands: []string{"/usr/local/bin/loginRule"}}
+	expected := "Process rule loginRule can't run sh, it isn't one of the API server's processRuleCommands"
+	if err := pManager.checkProcessCommands(config); err == nil || err.Error() != expected {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+	config.Rules["loginRule"] = ruleConfig{Type: processRuleType, Command: "/usr/local/bin/loginRule"}
+	if err := pManager.checkProcessCommands(config); err != nil {
+		t.Errorf("Expected an allowed command to be valid, got %s", err)
+	}
+}
+
+func TestValidateProcessRule(t *testing.T) {
+	rules := map[string]ruleConfig{
+		"Process rule a requires a command":   {Type: processRuleType},
+		"Process rule a can't use a state":    {Type: processRuleType, Command: "a", State: "s"},
+		"Invalid type for rule a: javascript": {Type: "javascript"},
+	}
+	for expected, rule := range rules {
+		if err := rule.validate("a"); err == nil || err.Error() != expected {
+			t.Errorf("Expected %s, got %v", expected, err)
+		}
+	}
+}
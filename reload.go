@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errPipelineNotFound = errors.New("Pipeline is not running")
+	errRevisionNotFound = errors.New("Revision does not exist")
+)
+
+// Reload replaces the config of a running pipeline, swapping only the rules that have changed,
+// and stores the new config as a revision. If the revision can't be stored the pipeline is reverted to its previous config.
+func (pM *pipelineManager) Reload(id string, rawConfig []byte, change revision) (*pipeline, error) {
+	pM.Lock()
+	p, ok := pM.pipelines[id]
+	pM.Unlock()
+	if !ok {
+		return nil, errPipelineNotFound
+	}
+	config, err := parseConfig(rawConfig)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing config %s", err)
+	}
+	if err = pM.checkProcessCommands(config); err != nil {
+		return nil, fmt.Errorf("Error validating config %s", err)
+	}
+
+	p.reloadLock.Lock()
+	defer p.reloadLock.Unlock()
+	p.Lock()
+	previous := p.Config
+	p.Unlock()
+	if err = p.Reload(rawConfig); err != nil {
+		return nil, err
+	}
+	if err = pM.Store(p, change); err != nil {
+		// Revert to the stored config, so the running config is always the latest revision
+		if revertErr := p.Reload(previous); revertErr != nil {
+			log.Errorf("Error reverting pipeline %s after failing to store it: %v", id, revertErr)
+		}
+		return nil, fmt.Errorf("Error storing pipeline %s", err)
+	}
+	return p, nil
+}
+
+// Reload swaps the rules whose config has changed for new ones without stopping the pipeline.
+// Sources, sinks, states and event types can't be changed, and rules can't be added, removed or re-plumbed.
+// If any new rule fails to initialise, the rules already swapped are rolled back.
+// Callers must hold the pipeline's reloadLock.
+func (p *pipeline) Reload(rawConfig []byte) error {
+	config, err := parseConfig(rawConfig)
+	if err != nil {
+		return fmt.Errorf("Error parsing config %s", err)
+	}
+	if err = validateConfig(config); err != nil {
+		return fmt.Errorf("Error validating config %s", err)
+	}
+	changed, err := p.changedRules(config)
+	if err != nil {
+		return err
+	}
+
+	for i, ruleName := range changed {
+		log.Infof("Reloading rule %s", ruleName)
+		err = p.reloadRule(ruleName, config.Rules[ruleName])
+		if err == nil {
+			continue
+		}
+		for _, swapped := range changed[:i] {
+			log.Infof("Rolling back rule %s", swapped)
+			if rollbackErr := p.reloadRule(swapped, p.config.Rules[swapped]); rollbackErr != nil {
+				log.Errorf("Error rolling back rule %s: %v", swapped, rollbackErr)
+			}
+		}
+		return fmt.Errorf("Error reloading rule %s: %v", ruleName, err)
+	}
+
+	p.Lock()
+	p.Config = rawConfig
+	p.config = config
+	p.Unlock()
+	return nil
+}
+
+// changedRules returns the names of the rules that differ in the new config,
+// or an error if anything that can't be reloaded has changed
+func (p *pipeline) changedRules(config pipelineConfig) ([]string, error) {
+	current := p.config
+	if config.Name != current.Name ||
+		config.EventFolder != current.EventFolder ||
+		!reflect.DeepEqual(config.Sources, current.Sources) ||
+		!reflect.DeepEqual(config.Sinks, current.Sinks) ||
+		!reflect.DeepEqual(config.States, current.States) ||
+		!reflect.DeepEqual(config.EventTypes, current.EventTypes) {
+		return nil, errors.New("Only rules can be changed without restarting the pipeline")
+	}
+
+	var changed []string
+	for ruleName, rule := range config.Rules {
+		currentRule, ok := current.Rules[ruleName]
+		if !ok {
+			return nil, fmt.Errorf("Rule %s can't be added without restarting the pipeline", ruleName)
+		}
+		if rule.Source != currentRule.Source || rule.Sink != currentRule.Sink || rule.State != currentRule.State {
+			return nil, fmt.Errorf("The source, sink and state of rule %s can't be changed without restarting the pipeline", ruleName)
+		}
+		if !reflect.DeepEqual(rule, currentRule) {
+			changed = append(changed, ruleName)
+		}
+	}
+	for ruleName := range current.Rules {
+		if _, ok := config.Rules[ruleName]; !ok {
+			return nil, fmt.Errorf("Rule %s can't be removed without restarting the pipeline", ruleName)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// reloadRule swaps the rule of a node. Holding the node's lock waits for the event being processed
+// and pauses upstream nodes sending to the rule until the swap is done.
+// The state stays open and is passed to the new rule, if it fails to initialise the old rule is initialised again.
+// Go plugins can't be reloaded, so a changed plugin must be loaded from a new path.
+func (p *pipeline) reloadRule(ruleName string, config ruleConfig) error {
+	node := p.Nodes[ruleName]
+	newRule, err := loadRule(ruleName, config)
+	if err != nil {
+		return err
+	}
+
+	node.Lock()
+	defer node.Unlock()
+	windower := node.windowManager
+	if windower != nil {
+		windower.Lock()
+		defer windower.Unlock()
+	}
+
+	oldRule := node.value.(Rule)
+	if err = oldRule.Close(); err != nil {
+		log.Warnf("Error closing rule %s: %v", ruleName, err)
+	}
+	if err = newRule.Init(node.state); err != nil {
+		if rollbackErr := oldRule.Init(node.state); rollbackErr != nil {
+			return fmt.Errorf("%v, and the previous rule failed to initialise: %v", err, rollbackErr)
+		}
+		return fmt.Errorf("Rolled back after new rule failed to initialise: %v", err)
+	}
+
+	node.value = newRule
+	node.eventTypes = make(map[string]bool)
+	for _, name := range config.EventTypes {
+		node.eventTypes[name] = true
+	}
+	if windower != nil {
+		windower.rule = newRule
+		windower.interval = newRule.WindowInterval()
+		if windower.interval > 0 && windower.closeChan == nil {
+			windower.start()
+		} else if windower.interval == 0 {
+			windower.stop()
+		}
+	}
+	return nil
+}
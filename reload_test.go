@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/patrobinson/go-fish/event"
+	"github.com/patrobinson/go-fish/input"
+	"github.com/patrobinson/go-fish/output"
+)
+
+func makeReloadPipeline(rule ruleConfig, sinkPath string) []byte {
+	rule.Source = "fileInput"
+	rule.Sink = "fileOutput"
+	pConfig, _ := json.Marshal(pipelineConfig{
+		Rules: map[string]ruleConfig{"loginRule": rule},
+		Sources: map[string]input.SourceConfig{
+			"fileInput": {Type: "File", FileConfig: input.FileConfig{Path: "testdata/pipelines/input"}},
+		},
+		Sinks: map[string]output.SinkConfig{
+			"fileOutput": {Type: "File", FileConfig: output.FileConfig{Path: sinkPath}},
+		},
+	})
+	return pConfig
+}
+
+func expressionRuleConfig(name string) ruleConfig {
+	return ruleConfig{
+		Type:      expressionRuleType,
+		Condition: `user == "bob"`,
+		Output:    &expressionOutput{Name: name},
+	}
+}
+
+// startLoginRule starts only the rule of a reload pipeline, returning a function that sends it an event
+// and returns the name of its output
+func startLoginRule(p *pipeline) func() string {
+	node := p.Nodes["loginRule"]
+	outputChan := make(chan interface{})
+	node.outputChan = &outputChan
+	node.windowManager = &windowManager{sinkChan: &outputChan, rule: node.rule(), interval: node.rule().WindowInterval()}
+	node.inputChan = startRule(node)
+	return func() string {
+		*node.inputChan <- event.Generic{Fields: map[string]interface{}{"user": "bob"}}
+		return (<-outputChan).(output.OutputEvent).Name
+	}
+}
+
+func TestReloadPipeline(t *testing.T) {
+	pManager := &pipelineManager{
+		backendConfig: backendConfig{
+			Type: "boltdb",
+			BoltDBConfig: boltDBConfig{
+				BucketName:   "TestReloadPipeline",
+				DatabaseName: "reload_test.db",
+			},
+		},
+	}
+	if err := pManager.Init(); err != nil {
+		t.Fatalf("Error creating Pipeline Manager: %s", err)
+	}
+	p, err := pManager.NewPipeline(makeReloadPipeline(expressionRuleConfig("v1"), "testdata/output"), makeMonitoringService(), revision{})
+	if err != nil {
+		t.Fatalf("Error creating new pipeline: %s", err)
+	}
+
+	process := startLoginRule(p)
+	if name := process(); name != "v1" {
+		t.Fatalf("Expected v1 rule output, got %s", name)
+	}
+
+	if _, err = pManager.Reload(p.ID.String(), makeReloadPipeline(expressionRuleConfig("v2"), "testdata/output"), revision{}); err != nil {
+		t.Fatalf("Error reloading pipeline: %s", err)
+	}
+	if name := process(); name != "v2" {
+		t.Errorf("Expected v2 rule output after reload, got %s", name)
+	}
+	stored, _ := pManager.Get([]byte(p.ID.String()))
+	if string(stored) != string(p.Config) || p.config.Rules["loginRule"].Output.Name != "v2" {
+		t.Errorf("Expected the new config to be stored, got %s", stored)
+	}
+
+	failing := ruleConfig{Type: processRuleType, Command: "true"}
+	_, err = pManager.Reload(p.ID.String(), makeReloadPipeline(failing, "testdata/output"), revision{})
+	if err == nil {
+		t.Error("Expected a rule that fails to initialise to raise an error")
+	}
+	if name := process(); name != "v2" {
+		t.Errorf("Expected v2 rule output after rollback, got %s", name)
+	}
+
+	_, err = pManager.Reload(p.ID.String(), makeReloadPipeline(expressionRuleConfig("v3"), "testdata/other"), revision{})
+	if err == nil || err.Error() != "Only rules can be changed without restarting the pipeline" {
+		t.Errorf("Expected changing a sink to raise an error, got %v", err)
+	}
+	if _, err = pManager.Reload("unknown", p.Config, revision{}); err != errPipelineNotFound {
+		t.Errorf("Expected reloading an unknown pipeline to raise an error, got %v", err)
+	}
+
+	pManager.Backend = failingBackend{pManager.Backend}
+	if _, err = pManager.Reload(p.ID.String(), makeReloadPipeline(expressionRuleConfig("v4"), "testdata/output"), revision{}); err == nil {
+		t.Error("Expected failing to store a reload to raise an error")
+	}
+	if name := process(); name != "v2" || p.config.Rules["loginRule"].Output.Name != "v2" {
+		t.Errorf("Expected the pipeline to be reverted to v2 after failing to store it, got %s", name)
+	}
+}
+
+// failingBackend is a backend that fails to store configs
+type failingBackend struct {
+	backend
+}
+
+func (f failingBackend) Store(*pipeline, *revision) error {
+	return errors.New("Backend is unavailable")
+}
+
+func TestConcurrentReloads(t *testing.T) {
+	pManager := &pipelineManager{
+		backendConfig: backendConfig{
+			Type: "boltdb",
+			BoltDBConfig: boltDBConfig{
+				BucketName:   "TestConcurrentReloads",
+				DatabaseName: "concurrent_reload_test.db",
+			},
+		},
+	}
+	if err := pManager.Init(); err != nil {
+		t.Fatalf("Error creating Pipeline Manager: %s", err)
+	}
+	defer os.Remove("concurrent_reload_test.db")
+	p, err := pManager.NewPipeline(makeReloadPipeline(expressionRuleConfig("v0"), "testdata/output"), makeMonitoringService(), revision{})
+	if err != nil {
+		t.Fatalf("Error creating new pipeline: %s", err)
+	}
+	process := startLoginRule(p)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(version string) {
+			defer wg.Done()
+			if _, err := pManager.Reload(p.ID.String(), makeReloadPipeline(expressionRuleConfig(version), "testdata/output"), revision{}); err != nil {
+				t.Errorf("Error reloading pipeline: %s", err)
+			}
+		}(fmt.Sprintf("v%d", i))
+	}
+	wg.Wait()
+
+	// The running rule, the pipeline's config and the latest revision must all be from the same reload
+	running := process()
+	status := p.Status()
+	latest, _ := pManager.GetRevision([]byte(p.ID.String()), status.Revision)
+	var stored pipelineConfig
+	json.Unmarshal(latest.Config, &stored)
+	if status.Revision != 11 || p.config.Rules["loginRule"].Output.Name != running || stored.Rules["loginRule"].Output.Name != running {
+		t.Errorf("Expected revision 11 to be running %s, got revision %d with config %s", running, status.Revision, latest.Config)
+	}
+}
+
+func TestReloadWindowInterval(t *testing.T) {
+	pManager := &pipelineManager{
+		backendConfig: backendConfig{
+			Type: "boltdb",
+			BoltDBConfig: boltDBConfig{
+				BucketName:   "TestReloadWindowInterval",
+				DatabaseName: "window_reload_test.db",
+			},
+		},
+	}
+	if err := pManager.Init(); err != nil {
+		t.Fatalf("Error creating Pipeline Manager: %s", err)
+	}
+	defer os.Remove("window_reload_test.db")
+	os.Setenv("GO_FISH_RULE_PROCESS", "1")
+	defer os.Unsetenv("GO_FISH_RULE_PROCESS")
+
+	p, err := pManager.NewPipeline(makeReloadPipeline(expressionRuleConfig("v1"), "testdata/output"), makeMonitoringService(), revision{})
+	if err != nil {
+		t.Fatalf("Error creating new pipeline: %s", err)
+	}
+	startLoginRule(p)
+	windower := p.Nodes["loginRule"].windowManager
+
+	// The test binary serves a rule with a window interval of 60 seconds
+	windowed := ruleConfig{Type: processRuleType, Command: os.Args[0], Config: json.RawMessage(`{"Threshold": 2}`)}
+	if _, err = pManager.Reload(p.ID.String(), makeReloadPipeline(windowed, "testdata/output"), revision{}); err != nil {
+		t.Fatalf("Error reloading pipeline: %s", err)
+	}
+	if windower.interval != 60 || windower.closeChan == nil {
+		t.Errorf("Expected the window to be started with the new rule's interval, got %d", windower.interval)
+	}
+
+	if _, err = pManager.Reload(p.ID.String(), makeReloadPipeline(expressionRuleConfig("v2"), "testdata/output"), revision{}); err != nil {
+		t.Fatalf("Error reloading pipeline: %s", err)
+	}
+	if windower.interval != 0 || windower.closeChan != nil {
+		t.Errorf("Expected the window to be stopped for a rule without one, got interval %d", windower.interval)
+	}
+}
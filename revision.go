@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// revision is a numbered version of a pipeline's config, recorded every time the config is stored
+type revision struct {
+	Number    int             `json:"number"`
+	Timestamp time.Time       `json:"timestamp"`
+	Author    string          `json:"author,omitempty"`
+	Comment   string          `json:"comment,omitempty"`
+	Config    json.RawMessage `json:"config,omitempty"`
+}
+
+// Revisions gets every revision of a pipeline's config, oldest first
+func (pM *pipelineManager) Revisions(uuid []byte) ([]revision, error) {
+	return pM.Backend.Revisions(uuid)
+}
+
+// GetRevision gets a revision of a pipeline's config, or nil if it doesn't exist
+func (pM *pipelineManager) GetRevision(uuid []byte, number int) (*revision, error) {
+	return pM.Backend.GetRevision(uuid, number)
+}
+
+// Rollback redeploys the config of an earlier revision to a running pipeline, storing it as a new revision
+func (pM *pipelineManager) Rollback(id string, number int, change revision) (*pipeline, error) {
+	rev, err := pM.GetRevision([]byte(id), number)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting revision %s", err)
+	}
+	if rev == nil {
+		return nil, errRevisionNotFound
+	}
+	if change.Comment == "" {
+		change.Comment = fmt.Sprintf("Rollback to revision %d", number)
+	}
+	return pM.Reload(id, rev.Config, change)
+}
+
+// diffConfigs returns a line diff of two configs, after formatting them with sorted keys and a value on each line
+func diffConfigs(from, to revision) string {
+	a := configLines(from.Config)
+	b := configLines(to.Config)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- revision %d\n+++ revision %d\n", from.Number, to.Number)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&diff, " %s\n", a[i])
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(&diff, "-%s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&diff, "+%s\n", b[j])
+			j++
+		}
+	}
+	return diff.String()
+}
+
+func configLines(config []byte) []string {
+	if len(config) == 0 {
+		return nil
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(config))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err == nil {
+		if formatted, err := json.MarshalIndent(value, "", "  "); err == nil {
+			config = formatted
+		}
+	}
+	return strings.Split(strings.TrimRight(string(config), "\n"), "\n")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	from := revision{Number: 1, Config: []byte(`{"name": "a", "rules": {"r": {"plugin": "a.so"}}}`)}
+	to := revision{Number: 2, Config: []byte(`{"rules": {"r": {"plugin": "b.so"}}, "name": "a"}`)}
+	expected := `--- revision 1
++++ revision 2
+ {
+   "name": "a",
+   "rules": {
+     "r": {
+-      "plugin": "a.so"
++      "plugin": "b.so"
+     }
+   }
+ }
+`
+	if diff := diffConfigs(from, to); diff != expected {
+		t.Errorf("Expected diff\n%s\nGot\n%s", expected, diff)
+	}
+
+	expected = "--- revision 0\n+++ revision 1\n+{\n+  \"name\": \"a\"\n+}\n"
+	if diff := diffConfigs(revision{}, revision{Number: 1, Config: []byte(`{"name":"a"}`)}); diff != expected {
+		t.Errorf("Expected diff\n%s\nGot\n%s", expected, diff)
+	}
+}
+
+func TestRollbackPipeline(t *testing.T) {
+	pManager := &pipelineManager{
+		backendConfig: backendConfig{
+			Type: "boltdb",
+			BoltDBConfig: boltDBConfig{
+				BucketName:   "TestRollbackPipeline",
+				DatabaseName: "rollback_test.db",
+			},
+		},
+	}
+	if err := pManager.Init(); err != nil {
+		t.Fatalf("Error creating Pipeline Manager: %s", err)
+	}
+	p, err := pManager.NewPipeline(makeReloadPipeline(expressionRuleConfig("v1"), "testdata/output"), makeMonitoringService(), revision{Author: "alice"})
+	if err != nil {
+		t.Fatalf("Error creating new pipeline: %s", err)
+	}
+	process := startLoginRule(p)
+	id := p.ID.String()
+	if _, err = pManager.Reload(id, makeReloadPipeline(expressionRuleConfig("v2"), "testdata/output"), revision{Author: "bob", Comment: "v2"}); err != nil {
+		t.Fatalf("Error reloading pipeline: %s", err)
+	}
+
+	if _, err = pManager.Rollback(id, 1, revision{Author: "carol"}); err != nil {
+		t.Fatalf("Error rolling back pipeline: %s", err)
+	}
+	if name := process(); name != "v1" {
+		t.Errorf("Expected v1 rule output after rollback, got %s", name)
+	}
+	if p.Revision != 3 {
+		t.Errorf("Expected rollback to be stored as revision 3, got %d", p.Revision)
+	}
+
+	revisions, err := pManager.Revisions([]byte(id))
+	if err != nil {
+		t.Fatalf("Error getting revisions: %s", err)
+	}
+	expected := []revision{
+		{Number: 1, Author: "alice"},
+		{Number: 2, Author: "bob", Comment: "v2"},
+		{Number: 3, Author: "carol", Comment: "Rollback to revision 1"},
+	}
+	if len(revisions) != len(expected) {
+		t.Fatalf("Expected %d revisions, got %v", len(expected), revisions)
+	}
+	for i, rev := range revisions {
+		if rev.Number != expected[i].Number || rev.Author != expected[i].Author || rev.Comment != expected[i].Comment || rev.Timestamp.IsZero() {
+			t.Errorf("Expected revision %v, got %v", expected[i], rev)
+		}
+	}
+	if string(revisions[2].Config) != string(revisions[0].Config) {
+		t.Errorf("Expected the rollback to store the config of revision 1, got %s", revisions[2].Config)
+	}
+
+	if _, err = pManager.Rollback(id, 4, revision{}); err != errRevisionNotFound {
+		t.Errorf("Expected rolling back to an unknown revision to raise an error, got %v", err)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"plugin"
 
 	"github.com/patrobinson/go-fish/output"
@@ -21,11 +23,41 @@ type Rule interface {
 	Close() error
 }
 
+// Rule types
+const (
+	pluginRuleType     = "plugin"
+	processRuleType    = "process"
+	expressionRuleType = "expression"
+)
+
+// State access modes
+const (
+	readWriteStateAccess = "readWrite"
+	readOnlyStateAccess  = "readOnly"
+)
+
 type ruleConfig struct {
 	Source string `json:"source"`
 	State  string `json:"state,omitempty"`
-	Plugin string `json:"plugin"`
-	Sink   string `json:"sink,omitempty"`
+	// StateAccess is "readWrite", the default, or "readOnly" for a rule that reads a state another rule writes to
+	StateAccess string `json:"stateAccess,omitempty"`
+	// Type is "plugin", the default, for a Go plugin, "process" for an executable or "expression"
+	Type   string `json:"type,omitempty"`
+	Plugin string `json:"plugin,omitempty"`
+	// Command and Args start the executable of a process rule, which is sent Config when it's initialised
+	Command string          `json:"command,omitempty"`
+	Args    []string        `json:"args,omitempty"`
+	Config  json.RawMessage `json:"config,omitempty"`
+	// MaxRestarts limits how many times in a row a process rule is restarted after exiting, defaults to 3
+	MaxRestarts int `json:"maxRestarts,omitempty"`
+	// TimeoutMs is how long a process rule has to reply to each request before it's killed and restarted, defaults to 5000
+	TimeoutMs int `json:"timeoutMs,omitempty"`
+	// Condition and Output define an expression rule, which outputs an event when the condition is true
+	Condition string            `json:"condition,omitempty"`
+	Output    *expressionOutput `json:"output,omitempty"`
+	Sink      string            `json:"sink,omitempty"`
+	// EventTypes limits the events delivered to the rule to those with a matching TypeName()
+	EventTypes []string `json:"eventTypes,omitempty"`
 }
 
 func testRule(ruleFile string) error {
@@ -42,7 +74,75 @@ func testRule(ruleFile string) error {
 	return nil
 }
 
-func newRule(config ruleConfig, s state.State) (Rule, error) {
+// validate checks the rule's type specific configuration
+func (c ruleConfig) validate(ruleName string) error {
+	switch c.Type {
+	case "", pluginRuleType:
+		if _, err := os.Stat(c.Plugin); err != nil {
+			return fmt.Errorf("Invalid plugin: %s", err)
+		}
+	case processRuleType:
+		if c.Command == "" {
+			return fmt.Errorf("Process rule %s requires a command", ruleName)
+		}
+		if c.State != "" {
+			return fmt.Errorf("Process rule %s can't use a state", ruleName)
+		}
+	case expressionRuleType:
+		if _, err := newExpressionRule(ruleName, c); err != nil {
+			return fmt.Errorf("Invalid expression rule %s: %v", ruleName, err)
+		}
+		if c.State != "" {
+			return fmt.Errorf("Expression rule %s can't use a state", ruleName)
+		}
+	default:
+		return fmt.Errorf("Invalid type for rule %s: %s", ruleName, c.Type)
+	}
+
+	switch c.StateAccess {
+	case "", readWriteStateAccess, readOnlyStateAccess:
+	default:
+		return fmt.Errorf("Invalid state access for rule %s: %s", ruleName, c.StateAccess)
+	}
+	return nil
+}
+
+// validateState checks the rule's state implements the interfaces the rule requires
+func (c ruleConfig) validateState(ruleName string, stateConfig state.Config) error {
+	s, err := state.Create(stateConfig)
+	if err != nil {
+		return fmt.Errorf("Invalid state for rule %s: %v", ruleName, err)
+	}
+	if c.StateAccess == readOnlyStateAccess {
+		if s, err = state.ReadOnly(s); err != nil {
+			return fmt.Errorf("Invalid state for rule %s: %v", ruleName, err)
+		}
+	}
+	rule, err := loadRule(ruleName, c)
+	if err != nil {
+		return fmt.Errorf("Error loading rule %s: %v", ruleName, err)
+	}
+	requirer, ok := rule.(state.Requirer)
+	if !ok {
+		return nil
+	}
+	for _, requirement := range requirer.StateRequirements() {
+		if !requirement.SatisfiedBy(s) {
+			return fmt.Errorf("Rule %s requires a %s state, but %s is a %s state", ruleName, requirement, c.State, stateConfig.Type)
+		}
+	}
+	return nil
+}
+
+// loadRule creates a rule without initialising it
+func loadRule(name string, config ruleConfig) (Rule, error) {
+	switch config.Type {
+	case processRuleType:
+		return newProcessRule(config), nil
+	case expressionRuleType:
+		return newExpressionRule(name, config)
+	}
+
 	plug, err := plugin.Open(config.Plugin)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to load plugin %s: %s", config.Plugin, err)
@@ -55,26 +155,41 @@ func newRule(config ruleConfig, s state.State) (Rule, error) {
 	if !ok {
 		return nil, errors.New("Rule is not a rule type")
 	}
+	return rule, nil
+}
+
+func newRule(name string, config ruleConfig, s state.State) (Rule, error) {
+	rule, err := loadRule(name, config)
+	if err != nil {
+		return nil, err
+	}
 	if err := rule.Init(s); err != nil {
 		return nil, err
 	}
 	return rule, nil
 }
 
-func startRule(rule Rule, output *chan interface{}, windower *windowManager) *chan interface{} {
+// startRule processes events sent to the rule node. The node's read lock is held while an event is
+// processed, so the rule can be swapped by taking the write lock.
+func startRule(node *pipelineNode) *chan interface{} {
 	input := make(chan interface{})
+	rule := node.rule()
 	log.Debugf("Starting %v\n", rule.String())
 
-	go func(input *chan interface{}, output *chan interface{}, r Rule) {
-		defer r.Close()
+	go func(input *chan interface{}, node *pipelineNode) {
+		defer func() {
+			node.rule().Close()
+		}()
 		for str := range *input {
-			res := r.Process(str)
-			*output <- res
+			node.RLock()
+			res := node.value.(Rule).Process(str)
+			node.RUnlock()
+			*node.outputChan <- res
 		}
-	}(&input, output, rule)
+	}(&input, node)
 
 	if rule.WindowInterval() > 0 {
-		windower.start()
+		node.windowManager.start()
 	}
 
 	return &input
@@ -0,0 +1,121 @@
+package rulehelpers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/patrobinson/go-fish/event"
+	"github.com/patrobinson/go-fish/output"
+)
+
+// Rule mirrors the interface go-fish rules implement
+type Rule interface {
+	Init(...interface{}) error
+	Process(interface{}) interface{}
+	String() string
+	WindowInterval() int
+	Window() ([]output.OutputEvent, error)
+	Close() error
+}
+
+// ProcessRequest is a message sent by go-fish to a process rule, one JSON object per line on its stdin.
+// Method is one of "init", "process", "window" or "close".
+type ProcessRequest struct {
+	Method    string          `json:"method"`
+	Config    json.RawMessage `json:"config,omitempty"`
+	EventType string          `json:"eventType,omitempty"`
+	Event     json.RawMessage `json:"event,omitempty"`
+}
+
+// ProcessResponse is the reply of a process rule to each request, one JSON object per line on its stdout.
+// Name and WindowInterval answer "init", Result answers "process" and Events answer "window".
+type ProcessResponse struct {
+	Name           string               `json:"name,omitempty"`
+	WindowInterval int                  `json:"windowInterval,omitempty"`
+	Result         interface{}          `json:"result,omitempty"`
+	Events         []output.OutputEvent `json:"events,omitempty"`
+	Error          string               `json:"error,omitempty"`
+}
+
+/*
+ServeProcess runs a Rule as a go-fish process rule, answering requests on stdin until it's closed.
+It allows a rule to be built as an executable rather than a plugin
+
+	func main() {
+		if err := rulehelpers.ServeProcess(&myRule{}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+The rule's Init is called with the rule's config as a json.RawMessage.
+Events are passed to Process as an event.Generic when they're JSON objects.
+*/
+func ServeProcess(rule Rule) error {
+	return serveProcess(rule, os.Stdin, os.Stdout)
+}
+
+func serveProcess(rule Rule, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	encoder := json.NewEncoder(out)
+	for scanner.Scan() {
+		var request ProcessRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			return fmt.Errorf("Invalid request: %v", err)
+		}
+		response := handleProcessRequest(rule, request)
+		if err := encoder.Encode(response); err != nil {
+			return err
+		}
+		if request.Method == "close" {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func handleProcessRequest(rule Rule, request ProcessRequest) ProcessResponse {
+	var response ProcessResponse
+	var err error
+	switch request.Method {
+	case "init":
+		if err = rule.Init(request.Config); err == nil {
+			response.Name = rule.String()
+			response.WindowInterval = rule.WindowInterval()
+		}
+	case "process":
+		var evt interface{}
+		evt, err = decodeProcessEvent(request)
+		if err == nil {
+			response.Result = rule.Process(evt)
+		}
+	case "window":
+		response.Events, err = rule.Window()
+	case "close":
+		err = rule.Close()
+	default:
+		err = fmt.Errorf("Unknown method %s", request.Method)
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	return response
+}
+
+func decodeProcessEvent(request ProcessRequest) (interface{}, error) {
+	if len(request.Event) == 0 {
+		return nil, errors.New("Process request has no event")
+	}
+	var value interface{}
+	if err := json.Unmarshal(request.Event, &value); err != nil {
+		return nil, err
+	}
+	if fields, ok := value.(map[string]interface{}); ok {
+		return event.Generic{Type: request.EventType, Fields: fields}, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,383 @@
+package schema
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// AvroSchema decodes Avro binary encoded data into generic values.
+// Records and maps decode to map[string]interface{}, arrays to []interface{}, ints and longs to int64,
+// floats and doubles to float64, enums to their symbol and bytes and fixed to []byte.
+type AvroSchema struct {
+	root *avroType
+}
+
+type avroType struct {
+	kind    string
+	name    string
+	fields  []avroField
+	symbols []string
+	items   *avroType
+	values  *avroType
+	union   []*avroType
+	size    int
+}
+
+type avroField struct {
+	name string
+	typ  *avroType
+}
+
+// ParseAvro parses an Avro schema in its JSON form
+func ParseAvro(definition string) (*AvroSchema, error) {
+	var schema interface{}
+	if err := json.Unmarshal([]byte(definition), &schema); err != nil {
+		return nil, fmt.Errorf("Invalid Avro schema: %v", err)
+	}
+	parser := &avroParser{named: make(map[string]*avroType)}
+	root, err := parser.parse(schema, "")
+	if err != nil {
+		return nil, err
+	}
+	return &AvroSchema{root: root}, nil
+}
+
+type avroParser struct {
+	named map[string]*avroType
+}
+
+func (p *avroParser) parse(schema interface{}, namespace string) (*avroType, error) {
+	switch s := schema.(type) {
+	case string:
+		return p.parseName(s, namespace)
+	case []interface{}:
+		union := &avroType{kind: "union"}
+		for _, branch := range s {
+			t, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			union.union = append(union.union, t)
+		}
+		return union, nil
+	case map[string]interface{}:
+		return p.parseComplex(s, namespace)
+	}
+	return nil, fmt.Errorf("Invalid Avro schema: %v", schema)
+}
+
+func (p *avroParser) parseName(name, namespace string) (*avroType, error) {
+	switch name {
+	case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+		return &avroType{kind: name}, nil
+	}
+	if t, ok := p.named[name]; ok {
+		return t, nil
+	}
+	if t, ok := p.named[namespace+"."+name]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("Unknown Avro type %s", name)
+}
+
+// define registers a named type by its full and short names, so it can be referenced before it's complete
+func (p *avroParser) define(t *avroType, s map[string]interface{}, namespace string) (string, error) {
+	name, _ := s["name"].(string)
+	if name == "" {
+		return "", fmt.Errorf("Avro %s requires a name", t.kind)
+	}
+	if ns, ok := s["namespace"].(string); ok {
+		namespace = ns
+	}
+	fullName := name
+	if strings.Contains(name, ".") {
+		namespace = name[:strings.LastIndex(name, ".")]
+		name = name[strings.LastIndex(name, ".")+1:]
+	} else if namespace != "" {
+		fullName = namespace + "." + name
+	}
+	t.name = fullName
+	p.named[fullName] = t
+	p.named[name] = t
+	return namespace, nil
+}
+
+func (p *avroParser) parseComplex(s map[string]interface{}, namespace string) (*avroType, error) {
+	kind, _ := s["type"].(string)
+	switch kind {
+	case "record", "error":
+		t := &avroType{kind: "record"}
+		namespace, err := p.define(t, s, namespace)
+		if err != nil {
+			return nil, err
+		}
+		fields, _ := s["fields"].([]interface{})
+		for _, f := range fields {
+			field, _ := f.(map[string]interface{})
+			name, _ := field["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("Avro record %s has a field without a name", t.name)
+			}
+			ft, err := p.parse(field["type"], namespace)
+			if err != nil {
+				return nil, err
+			}
+			t.fields = append(t.fields, avroField{name: name, typ: ft})
+		}
+		return t, nil
+	case "enum":
+		t := &avroType{kind: "enum"}
+		if _, err := p.define(t, s, namespace); err != nil {
+			return nil, err
+		}
+		symbols, _ := s["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			t.symbols = append(t.symbols, fmt.Sprint(symbol))
+		}
+		return t, nil
+	case "fixed":
+		t := &avroType{kind: "fixed"}
+		if _, err := p.define(t, s, namespace); err != nil {
+			return nil, err
+		}
+		size, _ := s["size"].(float64)
+		t.size = int(size)
+		return t, nil
+	case "array":
+		items, err := p.parse(s["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroType{kind: "array", items: items}, nil
+	case "map":
+		values, err := p.parse(s["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroType{kind: "map", values: values}, nil
+	}
+	// Primitive types may be written as {"type": "long", "logicalType": ...}
+	return p.parse(s["type"], namespace)
+}
+
+// Decode decodes a single Avro binary encoded datum
+func (a *AvroSchema) Decode(data []byte) (interface{}, error) {
+	d := &avroDecoder{data: data}
+	value, err := d.decode(a.root)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("Unexpected %d bytes after Avro datum", len(d.data)-d.pos)
+	}
+	return value, nil
+}
+
+var errAvroTruncated = errors.New("Avro datum is truncated")
+
+type avroDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *avroDecoder) long() (int64, error) {
+	value, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		return 0, errAvroTruncated
+	}
+	d.pos += n
+	return value, nil
+}
+
+func (d *avroDecoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, errAvroTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *avroDecoder) bytes() ([]byte, error) {
+	length, err := d.long()
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 || length > int64(len(d.data)-d.pos) {
+		return nil, errAvroTruncated
+	}
+	return d.next(int(length))
+}
+
+// maxAvroZeroWidthItems caps the number of items in an array whose items are encoded in no bytes,
+// as their count can't be checked against the bytes remaining
+const maxAvroZeroWidthItems = 1 << 16
+
+// blockCount reads the item count of an array or map block, skipping the size of blocks with a negative count.
+// Items encoded in at least one byte can't outnumber the bytes remaining, and the total of items encoded in
+// no bytes is capped, so a corrupt count can't make the decoder allocate or loop without bound.
+func (d *avroDecoder) blockCount(zeroWidth bool, decoded int) (int, error) {
+	count, err := d.long()
+	if err != nil {
+		return 0, err
+	}
+	if count < 0 {
+		if count == math.MinInt64 {
+			return 0, fmt.Errorf("Invalid Avro block count %d", count)
+		}
+		count = -count
+		if _, err = d.long(); err != nil {
+			return 0, err
+		}
+	}
+	if zeroWidth {
+		if count > int64(maxAvroZeroWidthItems-decoded) {
+			return 0, fmt.Errorf("Avro block has more than %d items", maxAvroZeroWidthItems)
+		}
+	} else if count > int64(len(d.data)-d.pos) {
+		return 0, errAvroTruncated
+	}
+	return int(count), nil
+}
+
+// zeroWidth returns whether values of a type are encoded in no bytes
+func (t *avroType) zeroWidth(seen map[*avroType]bool) bool {
+	switch t.kind {
+	case "null":
+		return true
+	case "fixed":
+		return t.size == 0
+	case "record":
+		if seen[t] {
+			return false
+		}
+		seen[t] = true
+		for _, field := range t.fields {
+			if !field.typ.zeroWidth(seen) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (d *avroDecoder) decode(t *avroType) (interface{}, error) {
+	switch t.kind {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case "int", "long":
+		return d.long()
+	case "float":
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case "double":
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case "bytes":
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case "string":
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case "fixed":
+		b, err := d.next(t.size)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case "enum":
+		index, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || int(index) >= len(t.symbols) {
+			return nil, fmt.Errorf("Invalid symbol %d for Avro enum %s", index, t.name)
+		}
+		return t.symbols[index], nil
+	case "union":
+		index, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || int(index) >= len(t.union) {
+			return nil, fmt.Errorf("Invalid Avro union branch %d", index)
+		}
+		return d.decode(t.union[index])
+	case "record":
+		record := make(map[string]interface{}, len(t.fields))
+		for _, field := range t.fields {
+			value, err := d.decode(field.typ)
+			if err != nil {
+				return nil, err
+			}
+			record[field.name] = value
+		}
+		return record, nil
+	case "array":
+		items := []interface{}{}
+		zeroWidth := t.items.zeroWidth(make(map[*avroType]bool))
+		for {
+			count, err := d.blockCount(zeroWidth, len(items))
+			if err != nil {
+				return nil, err
+			}
+			if count == 0 {
+				return items, nil
+			}
+			for ; count > 0; count-- {
+				item, err := d.decode(t.items)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+		}
+	case "map":
+		values := make(map[string]interface{})
+		for {
+			// Map keys are strings, so every entry is encoded in at least one byte
+			count, err := d.blockCount(false, len(values))
+			if err != nil {
+				return nil, err
+			}
+			if count == 0 {
+				return values, nil
+			}
+			for ; count > 0; count-- {
+				key, err := d.bytes()
+				if err != nil {
+					return nil, err
+				}
+				value, err := d.decode(t.values)
+				if err != nil {
+					return nil, err
+				}
+				values[string(key)] = value
+			}
+		}
+	}
+	return nil, fmt.Errorf("Unsupported Avro type %s", t.kind)
+}
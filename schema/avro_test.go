@@ -0,0 +1,152 @@
+package schema
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// avroLong appends a zig-zag encoded long
+func avroLong(b []byte, v int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(b, buf[:binary.PutVarint(buf, v)]...)
+}
+
+func avroString(b []byte, s string) []byte {
+	return append(avroLong(b, int64(len(s))), s...)
+}
+
+const loginSchema = `{
+	"type": "record",
+	"name": "Login",
+	"namespace": "com.example",
+	"fields": [
+		{"name": "user", "type": "string"},
+		{"name": "ip", "type": ["null", "string"]},
+		{"name": "attempts", "type": "int"},
+		{"name": "score", "type": "double"},
+		{"name": "result", "type": {"type": "enum", "name": "Result", "symbols": ["SUCCESS", "FAILURE"]}},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "counts", "type": {"type": "map", "values": "long"}},
+		{"name": "previous", "type": ["null", "Login"]},
+		{"name": "timestamp", "type": {"type": "long", "logicalType": "timestamp-millis"}}
+	]
+}`
+
+func encodeLogin(user string, previous bool) []byte {
+	b := avroString(nil, user)
+	b = avroString(avroLong(b, 1), "10.0.0.1")
+	b = avroLong(b, 3)
+	score := make([]byte, 8)
+	binary.LittleEndian.PutUint64(score, math.Float64bits(0.5))
+	b = append(b, score...)
+	b = avroLong(b, 1)
+	// The array is written as a block with a negative count and byte size
+	b = avroLong(avroLong(b, -2), 6)
+	b = avroString(avroString(b, "vpn"), "mfa")
+	b = avroLong(b, 0)
+	b = avroLong(avroString(avroLong(b, 1), "failures"), 2)
+	b = avroLong(b, 0)
+	if previous {
+		b = append(avroLong(b, 1), encodeLogin("alice", false)...)
+	} else {
+		b = avroLong(b, 0)
+	}
+	return avroLong(b, 1526000000000)
+}
+
+func TestAvroDecode(t *testing.T) {
+	schema, err := ParseAvro(loginSchema)
+	if err != nil {
+		t.Fatalf("Error parsing schema: %s", err)
+	}
+	value, err := schema.Decode(encodeLogin("bob", true))
+	if err != nil {
+		t.Fatalf("Error decoding: %s", err)
+	}
+	expected := map[string]interface{}{
+		"user":      "bob",
+		"ip":        "10.0.0.1",
+		"attempts":  int64(3),
+		"score":     0.5,
+		"result":    "FAILURE",
+		"tags":      []interface{}{"vpn", "mfa"},
+		"counts":    map[string]interface{}{"failures": int64(2)},
+		"timestamp": int64(1526000000000),
+	}
+	record := value.(map[string]interface{})
+	previous := record["previous"].(map[string]interface{})
+	delete(record, "previous")
+	if !reflect.DeepEqual(record, expected) {
+		t.Errorf("Expected %v, got %v", expected, record)
+	}
+	if previous["user"] != "alice" || previous["previous"] != nil {
+		t.Errorf("Unexpected nested record %v", previous)
+	}
+
+	if _, err := schema.Decode(encodeLogin("bob", false)[:4]); err == nil {
+		t.Error("Expected a truncated datum not to decode")
+	}
+}
+
+func TestAvroInvalidSchema(t *testing.T) {
+	for _, definition := range []string{
+		`{"type": "record", "fields": []}`,
+		`{"type": "record", "name": "A", "fields": [{"name": "b", "type": "B"}]}`,
+		`not json`,
+	} {
+		if _, err := ParseAvro(definition); err == nil {
+			t.Errorf("Expected %s to be invalid", definition)
+		}
+	}
+}
+
+func TestAvroDecodeCorruptCounts(t *testing.T) {
+	nulls, err := ParseAvro(`{"type": "array", "items": "null"}`)
+	if err != nil {
+		t.Fatalf("Error parsing schema: %s", err)
+	}
+	strings, err := ParseAvro(`{"type": "array", "items": "string"}`)
+	if err != nil {
+		t.Fatalf("Error parsing schema: %s", err)
+	}
+	if value, err := nulls.Decode(avroLong(avroLong(nil, 3), 0)); err != nil || len(value.([]interface{})) != 3 {
+		t.Errorf("Expected an array of 3 nulls, got %v %v", value, err)
+	}
+
+	cases := map[string]struct {
+		schema *AvroSchema
+		data   []byte
+	}{
+		"string longer than the datum":  {strings, avroLong(avroLong(nil, 1), math.MaxInt64)},
+		"string with a negative length": {strings, avroLong(avroLong(nil, 1), -1)},
+		"more items than bytes":         {strings, avroLong(nil, 1<<40)},
+		"minimum block count":           {strings, avroLong(nil, math.MinInt64)},
+		"too many zero width items":     {nulls, avroLong(nil, math.MaxInt64)},
+		"zero width items over blocks":  {nulls, avroLong(avroLong(nil, maxAvroZeroWidthItems), maxAvroZeroWidthItems)},
+	}
+	for name, c := range cases {
+		if _, err := c.schema.Decode(c.data); err == nil {
+			t.Errorf("Expected a datum with a %s not to decode", name)
+		}
+	}
+}
+
+func FuzzAvroDecode(f *testing.F) {
+	schema, err := ParseAvro(loginSchema)
+	if err != nil {
+		f.Fatalf("Error parsing schema: %s", err)
+	}
+	login := encodeLogin("bob", true)
+	f.Add(login)
+	for _, n := range []int{1, 4, 13, len(login) / 2, len(login) - 1} {
+		f.Add(login[:n])
+	}
+	f.Add(avroLong(avroLong(nil, 1), math.MaxInt64))
+	f.Add(avroLong(avroString(nil, "bob"), math.MinInt64))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Any input must be decoded or rejected without panicking
+		schema.Decode(data)
+	})
+}
@@ -0,0 +1,653 @@
+package schema
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ProtobufSchema decodes Protobuf messages described by a .proto definition into generic values.
+// Messages and map fields decode to map[string]interface{}, repeated fields to []interface{},
+// signed integers to int64, unsigned integers to uint64, floats to float64 and enums to their value name.
+// Scalar fields absent from a proto3 message are set to their zero value.
+// Fields of message types the definition imports, but doesn't define, decode to []byte.
+type ProtobufSchema struct {
+	messages []*protoMessage
+	proto3   bool
+}
+
+type protoMessage struct {
+	name     string
+	fields   map[int]*protoField
+	messages []*protoMessage
+	mapEntry bool
+}
+
+type protoField struct {
+	name     string
+	typeName string
+	repeated bool
+	optional bool
+	message  *protoMessage
+	enum     map[int]string
+}
+
+// ParseProtobuf parses a .proto definition. Services, options and imports are ignored.
+func ParseProtobuf(definition string) (*ProtobufSchema, error) {
+	tokens, err := tokenizeProto(definition)
+	if err != nil {
+		return nil, err
+	}
+	p := &protoParser{
+		tokens:   tokens,
+		messages: make(map[string]*protoMessage),
+		enums:    make(map[string]map[int]string),
+	}
+	schema := &ProtobufSchema{}
+	if err = p.parseFile(schema); err != nil {
+		return nil, err
+	}
+	for _, ref := range p.refs {
+		p.resolve(ref)
+	}
+	return schema, nil
+}
+
+func tokenizeProto(definition string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(definition); {
+		c := definition[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case strings.HasPrefix(definition[i:], "//"):
+			for i < len(definition) && definition[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(definition[i:], "/*"):
+			end := strings.Index(definition[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.New("Unterminated comment in Protobuf schema")
+			}
+			i += end + 4
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(definition) && definition[end] != c {
+				if definition[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(definition) {
+				return nil, errors.New("Unterminated string in Protobuf schema")
+			}
+			tokens = append(tokens, definition[i:end+1])
+			i = end + 1
+		case c == '_' || c == '.' || c == '-' || c == '+' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			start := i
+			for i < len(definition) && (definition[i] == '_' || definition[i] == '.' || definition[i] == '-' || definition[i] == '+' ||
+				unicode.IsLetter(rune(definition[i])) || unicode.IsDigit(rune(definition[i]))) {
+				i++
+			}
+			tokens = append(tokens, definition[start:i])
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+type protoParser struct {
+	tokens   []string
+	pos      int
+	pkg      string
+	messages map[string]*protoMessage
+	enums    map[string]map[int]string
+	refs     []protoRef
+}
+
+// protoRef is a field whose message or enum type is resolved once the whole file is parsed
+type protoRef struct {
+	field *protoField
+	scope string
+}
+
+func (p *protoParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	return token
+}
+
+func (p *protoParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *protoParser) expect(expected string) error {
+	if token := p.next(); token != expected {
+		return fmt.Errorf("Invalid Protobuf schema, expected %q but got %q", expected, token)
+	}
+	return nil
+}
+
+// skipStatement skips to the end of the current statement, including any block it opens
+func (p *protoParser) skipStatement() error {
+	depth := 0
+	for {
+		switch p.next() {
+		case "":
+			return errors.New("Unexpected end of Protobuf schema")
+		case ";":
+			if depth == 0 {
+				return nil
+			}
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+func (p *protoParser) parseFile(schema *ProtobufSchema) error {
+	for p.peek() != "" {
+		switch p.next() {
+		case "syntax":
+			if err := p.expect("="); err != nil {
+				return err
+			}
+			schema.proto3 = strings.Trim(p.next(), `"'`) == "proto3"
+			if err := p.expect(";"); err != nil {
+				return err
+			}
+		case "package":
+			p.pkg = p.next()
+			if err := p.expect(";"); err != nil {
+				return err
+			}
+		case "message":
+			message, err := p.parseMessage(p.pkg)
+			if err != nil {
+				return err
+			}
+			schema.messages = append(schema.messages, message)
+		case "enum":
+			if err := p.parseEnum(p.pkg); err != nil {
+				return err
+			}
+		case ";":
+		default:
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func (p *protoParser) parseMessage(scope string) (*protoMessage, error) {
+	message := &protoMessage{
+		name:   qualify(scope, p.next()),
+		fields: make(map[int]*protoField),
+	}
+	p.messages[message.name] = message
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if err := p.parseMessageBody(message, true); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// parseMessageBody parses fields up to the closing brace, oneof blocks are parsed as part of their message
+func (p *protoParser) parseMessageBody(message *protoMessage, nested bool) error {
+	for {
+		token := p.next()
+		switch token {
+		case "":
+			return errors.New("Unexpected end of Protobuf schema")
+		case "}":
+			return nil
+		case ";":
+		case "message":
+			if !nested {
+				return errors.New("Invalid Protobuf schema, message in oneof")
+			}
+			child, err := p.parseMessage(message.name)
+			if err != nil {
+				return err
+			}
+			message.messages = append(message.messages, child)
+		case "enum":
+			if err := p.parseEnum(message.name); err != nil {
+				return err
+			}
+		case "oneof":
+			p.next()
+			if err := p.expect("{"); err != nil {
+				return err
+			}
+			if err := p.parseMessageBody(message, false); err != nil {
+				return err
+			}
+		case "option", "reserved", "extensions", "extend":
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		case "map":
+			if err := p.parseMapField(message); err != nil {
+				return err
+			}
+		default:
+			field := &protoField{optional: !nested}
+			switch token {
+			case "repeated":
+				field.repeated = true
+				token = p.next()
+			case "optional", "required":
+				field.optional = true
+				token = p.next()
+			}
+			field.typeName = token
+			if err := p.parseField(message, field); err != nil {
+				return err
+			}
+			if !protoScalars[field.typeName] {
+				p.refs = append(p.refs, protoRef{field: field, scope: message.name})
+			}
+		}
+	}
+}
+
+func (p *protoParser) parseField(message *protoMessage, field *protoField) error {
+	field.name = p.next()
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	number, err := strconv.Atoi(p.next())
+	if err != nil {
+		return fmt.Errorf("Invalid field number for %s.%s: %v", message.name, field.name, err)
+	}
+	// Field options aren't needed to decode
+	if p.peek() == "[" {
+		for token := p.next(); token != "]"; token = p.next() {
+			if token == "" {
+				return errors.New("Unexpected end of Protobuf schema")
+			}
+		}
+	}
+	if err = p.expect(";"); err != nil {
+		return err
+	}
+	message.fields[number] = field
+	return nil
+}
+
+// parseMapField parses map<K, V> name = N; as a repeated field of an entry message with key and value fields
+func (p *protoParser) parseMapField(message *protoMessage) error {
+	if err := p.expect("<"); err != nil {
+		return err
+	}
+	keyType := p.next()
+	if err := p.expect(","); err != nil {
+		return err
+	}
+	valueType := p.next()
+	if err := p.expect(">"); err != nil {
+		return err
+	}
+	value := &protoField{name: "value", typeName: valueType}
+	entry := &protoMessage{
+		fields: map[int]*protoField{
+			1: {name: "key", typeName: keyType},
+			2: value,
+		},
+		mapEntry: true,
+	}
+	if !protoScalars[valueType] {
+		p.refs = append(p.refs, protoRef{field: value, scope: message.name})
+	}
+	field := &protoField{repeated: true, message: entry}
+	if err := p.parseField(message, field); err != nil {
+		return err
+	}
+	entry.name = message.name + "." + field.name + "Entry"
+	return nil
+}
+
+func (p *protoParser) parseEnum(scope string) error {
+	name := qualify(scope, p.next())
+	values := make(map[int]string)
+	p.enums[name] = values
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for {
+		token := p.next()
+		switch token {
+		case "":
+			return errors.New("Unexpected end of Protobuf schema")
+		case "}":
+			return nil
+		case ";":
+		case "option", "reserved":
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		default:
+			if err := p.expect("="); err != nil {
+				return err
+			}
+			number, err := strconv.Atoi(p.next())
+			if err != nil {
+				return fmt.Errorf("Invalid value for %s.%s: %v", name, token, err)
+			}
+			if _, ok := values[number]; !ok {
+				values[number] = token
+			}
+			if err = p.skipStatement(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// resolve finds the message or enum a field refers to, searching from the innermost scope outwards
+func (p *protoParser) resolve(ref protoRef) {
+	name := ref.field.typeName
+	candidates := []string{strings.TrimPrefix(name, ".")}
+	if !strings.HasPrefix(name, ".") {
+		candidates = nil
+		for scope := ref.scope; scope != ""; {
+			candidates = append(candidates, scope+"."+name)
+			if i := strings.LastIndex(scope, "."); i >= 0 {
+				scope = scope[:i]
+			} else {
+				scope = ""
+			}
+		}
+		candidates = append(candidates, name)
+	}
+	for _, candidate := range candidates {
+		if message, ok := p.messages[candidate]; ok {
+			ref.field.message = message
+			return
+		}
+		if enum, ok := p.enums[candidate]; ok {
+			ref.field.enum = enum
+			return
+		}
+	}
+}
+
+var protoScalars = map[string]bool{
+	"double": true, "float": true,
+	"int32": true, "int64": true, "uint32": true, "uint64": true, "sint32": true, "sint64": true,
+	"fixed32": true, "fixed64": true, "sfixed32": true, "sfixed64": true,
+	"bool": true, "string": true, "bytes": true,
+}
+
+// Decode decodes a Protobuf message in the Confluent wire format, after the schema ID.
+// The payload starts with the indexes of the message type in the definition.
+func (s *ProtobufSchema) Decode(data []byte) (interface{}, error) {
+	count, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, errors.New("Invalid Protobuf message indexes")
+	}
+	data = data[n:]
+	indexes := []int64{0}
+	if count > 0 {
+		indexes = nil
+		for ; count > 0; count-- {
+			index, n := binary.Varint(data)
+			if n <= 0 {
+				return nil, errors.New("Invalid Protobuf message indexes")
+			}
+			data = data[n:]
+			indexes = append(indexes, index)
+		}
+	}
+
+	messages := s.messages
+	var message *protoMessage
+	for _, index := range indexes {
+		if index < 0 || int(index) >= len(messages) {
+			return nil, fmt.Errorf("Protobuf message index %v not found in schema", indexes)
+		}
+		message = messages[index]
+		messages = message.messages
+	}
+	return s.DecodeMessage(message.name, data)
+}
+
+// DecodeMessage decodes a Protobuf message of the named type, the name must include the package
+func (s *ProtobufSchema) DecodeMessage(name string, data []byte) (map[string]interface{}, error) {
+	message := findProtoMessage(s.messages, name)
+	if message == nil {
+		return nil, fmt.Errorf("Protobuf message %s not found in schema", name)
+	}
+	return s.decode(message, data)
+}
+
+func findProtoMessage(messages []*protoMessage, name string) *protoMessage {
+	for _, message := range messages {
+		if message.name == name {
+			return message
+		}
+		if found := findProtoMessage(message.messages, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+var errProtoTruncated = errors.New("Protobuf message is truncated")
+
+func (s *ProtobufSchema) decode(message *protoMessage, data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errProtoTruncated
+		}
+		data = data[n:]
+		number, wireType := int(key>>3), int(key&7)
+
+		var raw uint64
+		var bytes []byte
+		switch wireType {
+		case 0:
+			raw, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errProtoTruncated
+			}
+		case 1:
+			if len(data) < 8 {
+				return nil, errProtoTruncated
+			}
+			raw, n = binary.LittleEndian.Uint64(data), 8
+		case 2:
+			length, m := binary.Uvarint(data)
+			if m <= 0 || uint64(len(data)-m) < length {
+				return nil, errProtoTruncated
+			}
+			bytes, n = data[m:m+int(length)], m+int(length)
+		case 5:
+			if len(data) < 4 {
+				return nil, errProtoTruncated
+			}
+			raw, n = uint64(binary.LittleEndian.Uint32(data)), 4
+		default:
+			return nil, fmt.Errorf("Unsupported Protobuf wire type %d", wireType)
+		}
+		data = data[n:]
+
+		field, ok := message.fields[number]
+		if !ok {
+			continue
+		}
+		if err := s.decodeField(field, wireType, raw, bytes, values); err != nil {
+			return nil, fmt.Errorf("Error decoding %s.%s: %v", message.name, field.name, err)
+		}
+	}
+	if s.proto3 {
+		setProtoDefaults(message, values)
+	}
+	return values, nil
+}
+
+func (s *ProtobufSchema) decodeField(field *protoField, wireType int, raw uint64, bytes []byte, values map[string]interface{}) error {
+	// Repeated scalars are packed into a single length delimited field
+	if field.repeated && wireType == 2 && field.message == nil && field.typeName != "string" && field.typeName != "bytes" {
+		packed, err := decodePacked(field, bytes)
+		if err != nil {
+			return err
+		}
+		list, _ := values[field.name].([]interface{})
+		values[field.name] = append(list, packed...)
+		return nil
+	}
+
+	value, err := s.decodeValue(field, raw, bytes)
+	if err != nil {
+		return err
+	}
+	switch {
+	case field.message != nil && field.message.mapEntry:
+		entry := value.(map[string]interface{})
+		entries, ok := values[field.name].(map[string]interface{})
+		if !ok {
+			entries = make(map[string]interface{})
+			values[field.name] = entries
+		}
+		entries[fmt.Sprint(entry["key"])] = entry["value"]
+	case field.repeated:
+		list, _ := values[field.name].([]interface{})
+		values[field.name] = append(list, value)
+	default:
+		values[field.name] = value
+	}
+	return nil
+}
+
+func decodePacked(field *protoField, data []byte) ([]interface{}, error) {
+	size := 0
+	switch field.typeName {
+	case "fixed32", "sfixed32", "float":
+		size = 4
+	case "fixed64", "sfixed64", "double":
+		size = 8
+	}
+	values := []interface{}{}
+	for len(data) > 0 {
+		var raw uint64
+		if size == 0 {
+			var n int
+			raw, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errProtoTruncated
+			}
+			data = data[n:]
+		} else {
+			if len(data) < size {
+				return nil, errProtoTruncated
+			}
+			if size == 4 {
+				raw = uint64(binary.LittleEndian.Uint32(data))
+			} else {
+				raw = binary.LittleEndian.Uint64(data)
+			}
+			data = data[size:]
+		}
+		value, err := scalarValue(field, raw)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (s *ProtobufSchema) decodeValue(field *protoField, raw uint64, bytes []byte) (interface{}, error) {
+	switch {
+	case field.message != nil:
+		return s.decode(field.message, bytes)
+	case field.typeName == "string":
+		return string(bytes), nil
+	case field.typeName == "bytes" || bytes != nil:
+		return append([]byte(nil), bytes...), nil
+	}
+	return scalarValue(field, raw)
+}
+
+func scalarValue(field *protoField, raw uint64) (interface{}, error) {
+	switch field.typeName {
+	case "int32":
+		return int64(int32(raw)), nil
+	case "int64", "sfixed64":
+		return int64(raw), nil
+	case "sfixed32":
+		return int64(int32(uint32(raw))), nil
+	case "sint32", "sint64":
+		return int64(raw>>1) ^ -int64(raw&1), nil
+	case "uint32", "uint64", "fixed32", "fixed64":
+		return raw, nil
+	case "bool":
+		return raw != 0, nil
+	case "float":
+		return float64(math.Float32frombits(uint32(raw))), nil
+	case "double":
+		return math.Float64frombits(raw), nil
+	}
+	if field.enum != nil {
+		if name, ok := field.enum[int(int32(raw))]; ok {
+			return name, nil
+		}
+		return int64(int32(raw)), nil
+	}
+	return nil, fmt.Errorf("Unknown Protobuf type %s", field.typeName)
+}
+
+func setProtoDefaults(message *protoMessage, values map[string]interface{}) {
+	for _, field := range message.fields {
+		if _, ok := values[field.name]; ok {
+			continue
+		}
+		switch {
+		case field.message != nil && field.message.mapEntry:
+			values[field.name] = map[string]interface{}{}
+		case field.repeated:
+			values[field.name] = []interface{}{}
+		case field.optional || field.message != nil:
+		case field.typeName == "string":
+			values[field.name] = ""
+		case field.typeName == "bytes":
+			values[field.name] = []byte{}
+		case field.enum != nil:
+			values[field.name], _ = scalarValue(field, 0)
+		case protoScalars[field.typeName]:
+			values[field.name], _ = scalarValue(field, 0)
+		}
+	}
+}
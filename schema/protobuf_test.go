@@ -0,0 +1,121 @@
+package schema
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+const alertProto = `
+syntax = "proto3";
+package alerts;
+
+import "google/protobuf/timestamp.proto";
+
+// An alert raised by a sensor
+message Alert {
+	enum Severity {
+		LOW = 0;
+		HIGH = 1;
+	}
+	string name = 1;
+	Severity severity = 2;
+	sint32 delta = 3;
+	repeated int64 ports = 4 [packed = true];
+	map<string, Host> hosts = 5;
+	google.protobuf.Timestamp created = 6;
+	oneof source {
+		string sensor = 7;
+		string user = 8;
+	}
+	bool acknowledged = 9;
+
+	message Host {
+		string ip = 1;
+		repeated string tags = 2;
+	}
+}
+
+message Heartbeat {
+	string sensor = 1;
+}
+`
+
+type protoBuilder []byte
+
+func (b protoBuilder) varint(number int, v uint64) protoBuilder {
+	b = b.key(number, 0)
+	return append(b, uvarint(v)...)
+}
+
+func (b protoBuilder) bytes(number int, v []byte) protoBuilder {
+	b = b.key(number, 2)
+	b = append(b, uvarint(uint64(len(v)))...)
+	return append(b, v...)
+}
+
+func (b protoBuilder) key(number, wireType int) protoBuilder {
+	return append(b, uvarint(uint64(number<<3|wireType))...)
+}
+
+func uvarint(v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, v)]
+}
+
+func TestProtobufDecode(t *testing.T) {
+	schema, err := ParseProtobuf(alertProto)
+	if err != nil {
+		t.Fatalf("Error parsing schema: %s", err)
+	}
+
+	host := protoBuilder{}.bytes(1, []byte("10.0.0.1")).bytes(2, []byte("db"))
+	entry := protoBuilder{}.bytes(1, []byte("db1")).bytes(2, host)
+	ports := append(uvarint(22), uvarint(443)...)
+	message := protoBuilder{}.
+		bytes(1, []byte("port scan")).
+		varint(2, 1).
+		varint(3, 3). // sint32 -2
+		bytes(4, ports).
+		bytes(5, entry).
+		bytes(6, []byte{8, 1}).
+		bytes(7, []byte("ids")).
+		varint(99, 1) // Unknown fields are skipped
+
+	// A single zero message index selects the first message
+	value, err := schema.Decode(append([]byte{0}, message...))
+	if err != nil {
+		t.Fatalf("Error decoding: %s", err)
+	}
+	expected := map[string]interface{}{
+		"name":     "port scan",
+		"severity": "HIGH",
+		"delta":    int64(-2),
+		"ports":    []interface{}{int64(22), int64(443)},
+		"hosts": map[string]interface{}{
+			"db1": map[string]interface{}{"ip": "10.0.0.1", "tags": []interface{}{"db"}},
+		},
+		"created":      []byte{8, 1},
+		"sensor":       "ids",
+		"acknowledged": false,
+	}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("Expected %v, got %v", expected, value)
+	}
+
+	// Message indexes are a zig-zag encoded count followed by the indexes
+	value, err = schema.Decode(append([]byte{2, 2}, protoBuilder{}.bytes(1, []byte("ids"))...))
+	if err != nil {
+		t.Fatalf("Error decoding: %s", err)
+	}
+	if !reflect.DeepEqual(value, map[string]interface{}{"sensor": "ids"}) {
+		t.Errorf("Expected a Heartbeat, got %v", value)
+	}
+
+	if _, err = schema.Decode(append([]byte{2, 4}, message...)); err == nil {
+		t.Error("Expected an unknown message index not to decode")
+	}
+	if _, err = schema.Decode(append([]byte{0}, message[:5]...)); err == nil {
+		t.Error("Expected a truncated message not to decode")
+	}
+}
@@ -0,0 +1,155 @@
+package schema
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schema types, as named by the Schema Registry
+const (
+	Avro     = "AVRO"
+	Protobuf = "PROTOBUF"
+)
+
+// RegistryConfig defines where schemas are loaded from.
+// Either a Schema Registry compatible URL, or a Directory containing files named <id>.avsc or <id>.proto
+type RegistryConfig struct {
+	URL       string `json:"url,omitempty"`
+	Directory string `json:"directory,omitempty"`
+	TimeoutMs int    `json:"timeoutMs,omitempty"`
+}
+
+// Schema is a schema definition as stored in the registry
+type Schema struct {
+	ID         int
+	SchemaType string
+	Definition string
+}
+
+// Registry looks up schemas by their ID
+type Registry interface {
+	Schema(id int) (Schema, error)
+}
+
+// NewRegistry creates a Registry that caches schemas by ID
+func NewRegistry(config RegistryConfig) (Registry, error) {
+	var source Registry
+	switch {
+	case config.URL != "":
+		timeout := time.Duration(config.TimeoutMs) * time.Millisecond
+		if timeout == 0 {
+			timeout = 10 * time.Second
+		}
+		source = &httpRegistry{
+			url:    strings.TrimSuffix(config.URL, "/"),
+			client: &http.Client{Timeout: timeout},
+		}
+	case config.Directory != "":
+		source = &directoryRegistry{directory: config.Directory}
+	default:
+		return nil, errors.New("Schema registry requires a url or directory")
+	}
+	return &cachingRegistry{
+		source:  source,
+		schemas: make(map[int]Schema),
+	}, nil
+}
+
+type cachingRegistry struct {
+	sync.RWMutex
+	source  Registry
+	schemas map[int]Schema
+}
+
+func (c *cachingRegistry) Schema(id int) (Schema, error) {
+	c.RLock()
+	schema, ok := c.schemas[id]
+	c.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	schema, err := c.source.Schema(id)
+	if err != nil {
+		return schema, err
+	}
+	c.Lock()
+	c.schemas[id] = schema
+	c.Unlock()
+	return schema, nil
+}
+
+type httpRegistry struct {
+	url    string
+	client *http.Client
+}
+
+func (h *httpRegistry) Schema(id int) (Schema, error) {
+	resp, err := h.client.Get(fmt.Sprintf("%s/schemas/ids/%d", h.url, id))
+	if err != nil {
+		return Schema{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Schema{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Schema{}, fmt.Errorf("Error fetching schema %d: %s %s", id, resp.Status, body)
+	}
+
+	var result struct {
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return Schema{}, fmt.Errorf("Invalid schema registry response for %d: %v", id, err)
+	}
+	// The registry omits the type of Avro schemas
+	if result.SchemaType == "" {
+		result.SchemaType = Avro
+	}
+	return Schema{ID: id, SchemaType: result.SchemaType, Definition: result.Schema}, nil
+}
+
+type directoryRegistry struct {
+	directory string
+}
+
+func (d *directoryRegistry) Schema(id int) (Schema, error) {
+	extensions := map[string]string{
+		".avsc":  Avro,
+		".proto": Protobuf,
+	}
+	for extension, schemaType := range extensions {
+		definition, err := ioutil.ReadFile(filepath.Join(d.directory, fmt.Sprintf("%d%s", id, extension)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return Schema{}, err
+		}
+		return Schema{ID: id, SchemaType: schemaType, Definition: string(definition)}, nil
+	}
+	return Schema{}, fmt.Errorf("Schema %d not found in %s", id, d.directory)
+}
+
+// ParseWireFormat splits a message in the Confluent wire format into its schema ID and payload
+func ParseWireFormat(data []byte) (int, []byte, error) {
+	if len(data) < 5 {
+		return 0, nil, errors.New("Message is too short for the wire format")
+	}
+	if data[0] != 0 {
+		return 0, nil, fmt.Errorf("Unknown wire format magic byte %d", data[0])
+	}
+	return int(binary.BigEndian.Uint32(data[1:5])), data[5:], nil
+}
@@ -0,0 +1,85 @@
+package schema
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPRegistry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			fmt.Fprint(w, `{"schema": "\"string\""}`)
+		case "/schemas/ids/2":
+			fmt.Fprint(w, `{"schema": "syntax = \"proto3\";", "schemaType": "PROTOBUF"}`)
+		default:
+			w.WriteHeader(404)
+			fmt.Fprint(w, `{"error_code": 40403, "message": "Schema not found"}`)
+		}
+	}))
+	defer server.Close()
+
+	registry, err := NewRegistry(RegistryConfig{URL: server.URL + "/"})
+	if err != nil {
+		t.Fatalf("Error creating registry: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		schema, err := registry.Schema(1)
+		if err != nil {
+			t.Fatalf("Error fetching schema: %s", err)
+		}
+		if schema.SchemaType != Avro || schema.Definition != `"string"` {
+			t.Errorf("Unexpected schema %v", schema)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected schema to be cached, got %d requests", requests)
+	}
+	if schema, _ := registry.Schema(2); schema.SchemaType != Protobuf {
+		t.Errorf("Expected a Protobuf schema, got %v", schema)
+	}
+	if _, err := registry.Schema(3); err == nil {
+		t.Error("Expected an unknown schema to raise an error")
+	}
+}
+
+func TestDirectoryRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "1.avsc"), []byte(`"string"`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "2.proto"), []byte(`syntax = "proto3";`), 0644)
+
+	registry, _ := NewRegistry(RegistryConfig{Directory: dir})
+	if schema, err := registry.Schema(1); err != nil || schema.SchemaType != Avro {
+		t.Errorf("Expected an Avro schema, got %v %v", schema, err)
+	}
+	if schema, err := registry.Schema(2); err != nil || schema.SchemaType != Protobuf {
+		t.Errorf("Expected a Protobuf schema, got %v %v", schema, err)
+	}
+	if _, err := registry.Schema(3); err == nil {
+		t.Error("Expected an unknown schema to raise an error")
+	}
+}
+
+func TestParseWireFormat(t *testing.T) {
+	id, payload, err := ParseWireFormat([]byte{0, 0, 0, 1, 2, 'a'})
+	if err != nil || id != 258 || string(payload) != "a" {
+		t.Errorf("Unexpected result %d %v %v", id, payload, err)
+	}
+	if _, _, err := ParseWireFormat([]byte{1, 0, 0, 0, 1}); err == nil {
+		t.Error("Expected an unknown magic byte to raise an error")
+	}
+	if _, _, err := ParseWireFormat([]byte{0, 0}); err == nil {
+		t.Error("Expected a short message to raise an error")
+	}
+}
@@ -0,0 +1,161 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// BloomConfig defines the configuration of a Bloom state
+type BloomConfig struct {
+	// Capacity is the number of items the filter is sized for, defaults to 100000
+	Capacity int `json:"capacity,omitempty"`
+	// FalsePositiveRate is the rate of false positives once the filter holds its capacity, defaults to 0.01
+	FalsePositiveRate float64 `json:"falsePositiveRate,omitempty"`
+	// SnapshotFile persists the filter, it's loaded when initialised and saved when closed
+	SnapshotFile string `json:"snapshotFile,omitempty"`
+}
+
+// Bloom is a Bloom filter, which tests whether an item has been added in a fixed amount of memory.
+// It never has false negatives, but can have false positives.
+type Bloom struct {
+	BloomConfig
+	sync.Mutex
+	hashes    uint32
+	size      uint64
+	bits      []uint64
+	lifecycle lifecycle
+}
+
+// NewBloom creates a Bloom filter sized for the capacity and false positive rate
+func NewBloom(capacity int, falsePositiveRate float64) (*Bloom, error) {
+	b := &Bloom{BloomConfig: BloomConfig{Capacity: capacity, FalsePositiveRate: falsePositiveRate}}
+	return b, b.Init()
+}
+
+// Init initialises the filter with the optimal number of bits and hashes for the capacity and false positive rate, if it isn't already
+func (b *Bloom) Init() error {
+	return b.lifecycle.init(b.open)
+}
+
+func (b *Bloom) open() error {
+	if b.Capacity == 0 {
+		b.Capacity = 100000
+	}
+	if b.FalsePositiveRate == 0 {
+		b.FalsePositiveRate = 0.01
+	}
+	if b.Capacity < 0 || b.FalsePositiveRate < 0 || b.FalsePositiveRate >= 1 {
+		return fmt.Errorf("Invalid Bloom filter capacity %d or false positive rate %v", b.Capacity, b.FalsePositiveRate)
+	}
+	n := float64(b.Capacity)
+	b.size = uint64(math.Ceil(-n * math.Log(b.FalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	b.hashes = uint32(math.Max(1, math.Round(float64(b.size)/n*math.Ln2)))
+	b.bits = make([]uint64, (b.size+63)/64)
+	return loadSnapshot(b.SnapshotFile, b)
+}
+
+// Close saves the snapshot once every Init has been matched by a Close, if the filter has one
+func (b *Bloom) Close() error {
+	return b.lifecycle.close(b.close)
+}
+
+func (b *Bloom) close() error {
+	return saveSnapshot(b.SnapshotFile, b)
+}
+
+// Add adds an item
+func (b *Bloom) Add(item []byte) {
+	h1, h2 := hashItem(item)
+	b.Lock()
+	for i := uint32(0); i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % b.size
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+	b.Unlock()
+}
+
+// Test returns whether an item may have been added, or false if it definitely hasn't
+func (b *Bloom) Test(item []byte) bool {
+	h1, h2 := hashItem(item)
+	b.Lock()
+	defer b.Unlock()
+	for i := uint32(0); i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % b.size
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TestAndAdd adds an item, returning whether it may have been added before
+func (b *Bloom) TestAndAdd(item []byte) bool {
+	seen := b.Test(item)
+	b.Add(item)
+	return seen
+}
+
+// Merge adds the items of another filter with the same capacity and false positive rate, such as one from another window
+func (b *Bloom) Merge(other *Bloom) error {
+	other.Lock()
+	hashes, size := other.hashes, other.size
+	bits := append([]uint64{}, other.bits...)
+	other.Unlock()
+
+	b.Lock()
+	defer b.Unlock()
+	if hashes != b.hashes || size != b.size {
+		return errors.New("Can't merge Bloom filters with different capacities or false positive rates")
+	}
+	for i, word := range bits {
+		b.bits[i] |= word
+	}
+	return nil
+}
+
+// Reset removes all the items
+func (b *Bloom) Reset() {
+	b.Lock()
+	b.bits = make([]uint64, len(b.bits))
+	b.Unlock()
+}
+
+// MarshalBinary serialises the filter
+func (b *Bloom) MarshalBinary() ([]byte, error) {
+	b.Lock()
+	defer b.Unlock()
+	data := make([]byte, 13+8*len(b.bits))
+	data[0] = sketchVersion
+	binary.BigEndian.PutUint32(data[1:], b.hashes)
+	binary.BigEndian.PutUint64(data[5:], b.size)
+	for i, word := range b.bits {
+		binary.BigEndian.PutUint64(data[13+8*i:], word)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary restores a filter serialised with the same capacity and false positive rate
+func (b *Bloom) UnmarshalBinary(data []byte) error {
+	if len(data) < 13 || data[0] != sketchVersion {
+		return errors.New("Invalid Bloom filter")
+	}
+	hashes := binary.BigEndian.Uint32(data[1:])
+	size := binary.BigEndian.Uint64(data[5:])
+	if hashes == 0 || size == 0 || uint64(len(data)-13) != 8*((size+63)/64) {
+		return errors.New("Invalid Bloom filter")
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.bits != nil && (hashes != b.hashes || size != b.size) {
+		return errors.New("Bloom filter was serialised with a different capacity or false positive rate")
+	}
+	b.hashes, b.size = hashes, size
+	b.bits = make([]uint64, (size+63)/64)
+	for i := range b.bits {
+		b.bits[i] = binary.BigEndian.Uint64(data[13+8*i:])
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBloomMembership(t *testing.T) {
+	b, err := NewBloom(10000, 0.01)
+	if err != nil {
+		t.Fatalf("Error creating Bloom filter %s", err)
+	}
+	for i := 0; i < 10000; i++ {
+		b.Add([]byte(fmt.Sprintf("known%d", i)))
+	}
+	for i := 0; i < 10000; i++ {
+		if !b.Test([]byte(fmt.Sprintf("known%d", i))) {
+			t.Fatalf("Expected known%d to be a member", i)
+		}
+	}
+	var falsePositives int
+	for i := 0; i < 10000; i++ {
+		if b.Test([]byte(fmt.Sprintf("unknown%d", i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 200 {
+		t.Errorf("Expected a false positive rate of about 1%%, got %d in 10000", falsePositives)
+	}
+	if b.TestAndAdd([]byte("new")) || !b.TestAndAdd([]byte("new")) {
+		t.Errorf("Expected TestAndAdd to report whether the item was seen before")
+	}
+}
+
+func TestBloomMergeAndSnapshot(t *testing.T) {
+	snapshotFile := "bloom_test.snapshot"
+	defer os.Remove(snapshotFile)
+	b := &Bloom{BloomConfig: BloomConfig{Capacity: 1000, SnapshotFile: snapshotFile}}
+	if err := b.Init(); err != nil {
+		t.Fatalf("Error initialising Bloom filter %s", err)
+	}
+	b.Add([]byte("monday"))
+	window, _ := NewBloom(1000, 0.01)
+	window.Add([]byte("tuesday"))
+	if err := b.Merge(window); err != nil {
+		t.Fatalf("Error merging Bloom filters %s", err)
+	}
+	b.Close()
+
+	restored := &Bloom{BloomConfig: BloomConfig{Capacity: 1000, SnapshotFile: snapshotFile}}
+	if err := restored.Init(); err != nil {
+		t.Fatalf("Error restoring Bloom filter %s", err)
+	}
+	if !restored.Test([]byte("monday")) || !restored.Test([]byte("tuesday")) {
+		t.Errorf("Expected the restored filter to contain both windows")
+	}
+	other, _ := NewBloom(5000, 0.01)
+	if err := restored.Merge(other); err == nil {
+		t.Errorf("Expected merging different capacities to fail")
+	}
+	restored.Reset()
+	if restored.Test([]byte("monday")) {
+		t.Errorf("Expected a reset filter to be empty")
+	}
+}
@@ -2,27 +2,37 @@ package state
 
 import "sync"
 
-// Counter is a simple in memory counter
-type Counter struct {
+// Counter is the name MemoryCounter had before states implemented typed interfaces.
+//
+// Deprecated: use MemoryCounter, or the WindowCounter interface.
+type Counter = MemoryCounter
+
+// MemoryCounter is a simple in memory counter
+type MemoryCounter struct {
 	sync.RWMutex
-	Count int
+	Count     int
+	lifecycle lifecycle
+}
+
+// Init initialises the counter, if it isn't already
+func (c *MemoryCounter) Init() error {
+	return c.lifecycle.init(c.open)
 }
 
-// Init initialises the counter
-func (c *Counter) Init() error {
+func (c *MemoryCounter) open() error {
 	c.Count = 0
 	return nil
 }
 
 // Increment increments the counter
-func (c *Counter) Increment() {
+func (c *MemoryCounter) Increment() {
 	c.Lock()
 	c.Count++
 	c.Unlock()
 }
 
 // Window returns the current value and resets the counter
-func (c *Counter) Window() int {
+func (c *MemoryCounter) Window() int {
 	c.Lock()
 	ret := c.Count
 	c.Count = 0
@@ -30,5 +40,16 @@ func (c *Counter) Window() int {
 	return ret
 }
 
+// Value returns the current value without resetting the counter
+func (c *MemoryCounter) Value() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.Count
+}
+
 // Close closes the counter (does nothing)
-func (c *Counter) Close() {}
+func (c *MemoryCounter) Close() error {
+	return c.lifecycle.close(c.close)
+}
+
+func (c *MemoryCounter) close() error { return nil }
@@ -5,7 +5,7 @@ import (
 )
 
 func TestCounter(t *testing.T) {
-	counter := Counter{}
+	counter := MemoryCounter{}
 	counter.Init()
 	counter.Increment()
 	if counter.Count != 1 {
@@ -14,11 +14,14 @@ func TestCounter(t *testing.T) {
 }
 
 func TestCounterWindowing(t *testing.T) {
-	counter := Counter{}
+	counter := MemoryCounter{}
 	counter.Init()
 	counter.Increment()
 	counter.Increment()
 	counter.Increment()
+	if counter.Value() != 3 || counter.Value() != 3 {
+		t.Error("Expected reading the value not to reset the counter")
+	}
 	if counter.Window() != 3 {
 		t.Error("Counter not incremented")
 	}
@@ -26,3 +29,16 @@ func TestCounterWindowing(t *testing.T) {
 		t.Error("Expected counter to be zero")
 	}
 }
+
+func TestCounterAlias(t *testing.T) {
+	// Rules written before the rename assert their state is a *state.Counter
+	var s State = &MemoryCounter{}
+	counter, ok := s.(*Counter)
+	if !ok {
+		t.Fatal("Expected a MemoryCounter to be a Counter")
+	}
+	counter.Increment()
+	if counter.Window() != 1 {
+		t.Error("Counter not incremented")
+	}
+}
@@ -0,0 +1,158 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// CountMinConfig defines the configuration of a CountMin state.
+// Estimates exceed the true count by at most Epsilon times the total of all counts, with probability 1 - Delta.
+type CountMinConfig struct {
+	// Epsilon defaults to 0.001
+	Epsilon float64 `json:"epsilon,omitempty"`
+	// Delta defaults to 0.01
+	Delta float64 `json:"delta,omitempty"`
+	// SnapshotFile persists the sketch, it's loaded when initialised and saved when closed
+	SnapshotFile string `json:"snapshotFile,omitempty"`
+}
+
+// CountMin estimates how many times each item has been added, in a fixed amount of memory.
+// Estimates are never less than the true count.
+type CountMin struct {
+	CountMinConfig
+	sync.Mutex
+	width     uint32
+	depth     uint32
+	counts    []uint64
+	lifecycle lifecycle
+}
+
+// NewCountMin creates a CountMin with the error bounds
+func NewCountMin(epsilon float64, delta float64) (*CountMin, error) {
+	c := &CountMin{CountMinConfig: CountMinConfig{Epsilon: epsilon, Delta: delta}}
+	return c, c.Init()
+}
+
+// Init initialises the CountMin with e/epsilon counters in each of ln(1/delta) rows, if it isn't already
+func (c *CountMin) Init() error {
+	return c.lifecycle.init(c.open)
+}
+
+func (c *CountMin) open() error {
+	if c.Epsilon == 0 {
+		c.Epsilon = 0.001
+	}
+	if c.Delta == 0 {
+		c.Delta = 0.01
+	}
+	if c.Epsilon < 0 || c.Epsilon >= 1 || c.Delta < 0 || c.Delta >= 1 {
+		return fmt.Errorf("Invalid CountMin error bounds: epsilon %v, delta %v", c.Epsilon, c.Delta)
+	}
+	c.width = uint32(math.Ceil(math.E / c.Epsilon))
+	c.depth = uint32(math.Ceil(math.Log(1 / c.Delta)))
+	c.counts = make([]uint64, c.width*c.depth)
+	return loadSnapshot(c.SnapshotFile, c)
+}
+
+// Close saves the snapshot once every Init has been matched by a Close, if the CountMin has one
+func (c *CountMin) Close() error {
+	return c.lifecycle.close(c.close)
+}
+
+func (c *CountMin) close() error {
+	return saveSnapshot(c.SnapshotFile, c)
+}
+
+// Add adds count occurrences of an item
+func (c *CountMin) Add(item []byte, count uint64) {
+	h1, h2 := hashItem(item)
+	c.Lock()
+	for row := uint32(0); row < c.depth; row++ {
+		c.counts[c.index(row, h1, h2)] += count
+	}
+	c.Unlock()
+}
+
+// Estimate returns the estimated number of occurrences of an item
+func (c *CountMin) Estimate(item []byte) uint64 {
+	h1, h2 := hashItem(item)
+	c.Lock()
+	defer c.Unlock()
+	estimate := uint64(math.MaxUint64)
+	for row := uint32(0); row < c.depth; row++ {
+		if count := c.counts[c.index(row, h1, h2)]; count < estimate {
+			estimate = count
+		}
+	}
+	return estimate
+}
+
+// index returns the counter of an item in a row, using double hashing to derive a hash for each row
+func (c *CountMin) index(row uint32, h1 uint64, h2 uint64) uint64 {
+	return uint64(row)*uint64(c.width) + (h1+uint64(row)*h2)%uint64(c.width)
+}
+
+// Merge adds the counts of another CountMin with the same error bounds, such as one from another window
+func (c *CountMin) Merge(other *CountMin) error {
+	other.Lock()
+	width, depth := other.width, other.depth
+	counts := append([]uint64{}, other.counts...)
+	other.Unlock()
+
+	c.Lock()
+	defer c.Unlock()
+	if width != c.width || depth != c.depth {
+		return errors.New("Can't merge CountMins with different error bounds")
+	}
+	for i, count := range counts {
+		c.counts[i] += count
+	}
+	return nil
+}
+
+// Reset removes all the counts
+func (c *CountMin) Reset() {
+	c.Lock()
+	c.counts = make([]uint64, len(c.counts))
+	c.Unlock()
+}
+
+// MarshalBinary serialises the CountMin
+func (c *CountMin) MarshalBinary() ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+	data := make([]byte, 9+8*len(c.counts))
+	data[0] = sketchVersion
+	binary.BigEndian.PutUint32(data[1:], c.width)
+	binary.BigEndian.PutUint32(data[5:], c.depth)
+	for i, count := range c.counts {
+		binary.BigEndian.PutUint64(data[9+8*i:], count)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary restores a CountMin serialised with the same error bounds
+func (c *CountMin) UnmarshalBinary(data []byte) error {
+	if len(data) < 9 || data[0] != sketchVersion {
+		return errors.New("Invalid CountMin")
+	}
+	width := binary.BigEndian.Uint32(data[1:])
+	depth := binary.BigEndian.Uint32(data[5:])
+	if uint64(len(data)-9) != 8*uint64(width)*uint64(depth) {
+		return errors.New("Invalid CountMin")
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.counts != nil && (width != c.width || depth != c.depth) {
+		return errors.New("CountMin was serialised with different error bounds")
+	}
+	c.width, c.depth = width, depth
+	c.counts = make([]uint64, width*depth)
+	for i := range c.counts {
+		c.counts[i] = binary.BigEndian.Uint64(data[9+8*i:])
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCountMinEstimate(t *testing.T) {
+	c, err := NewCountMin(0.001, 0.01)
+	if err != nil {
+		t.Fatalf("Error creating CountMin %s", err)
+	}
+	c.Add([]byte("s3.amazonaws.com"), 500)
+	var total uint64 = 500
+	for i := 0; i < 10000; i++ {
+		c.Add([]byte(fmt.Sprintf("host%d.example.com", i)), 1)
+		total++
+	}
+	if estimate := c.Estimate([]byte("s3.amazonaws.com")); estimate < 500 || estimate > 500+total/1000 {
+		t.Errorf("Expected an estimate between 500 and %d, got %d", 500+total/1000, estimate)
+	}
+	if estimate := c.Estimate([]byte("unseen.example.com")); estimate > total/1000 {
+		t.Errorf("Expected an unseen item to be at most %d, got %d", total/1000, estimate)
+	}
+
+	previous, _ := NewCountMin(0.001, 0.01)
+	previous.Add([]byte("s3.amazonaws.com"), 100)
+	if err := c.Merge(previous); err != nil {
+		t.Fatalf("Error merging CountMins %s", err)
+	}
+	if estimate := c.Estimate([]byte("s3.amazonaws.com")); estimate < 600 {
+		t.Errorf("Expected the merged estimate to be at least 600, got %d", estimate)
+	}
+	other, _ := NewCountMin(0.01, 0.01)
+	if err := c.Merge(other); err == nil {
+		t.Errorf("Expected merging different error bounds to fail")
+	}
+
+	data, _ := c.MarshalBinary()
+	restored := &CountMin{}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Error restoring CountMin %s", err)
+	}
+	if restored.Estimate([]byte("s3.amazonaws.com")) != c.Estimate([]byte("s3.amazonaws.com")) {
+		t.Errorf("Expected the restored CountMin to have the same estimates")
+	}
+	if err := other.UnmarshalBinary(data); err == nil {
+		t.Errorf("Expected a CountMin with different error bounds to be rejected")
+	}
+
+	c.Reset()
+	if estimate := c.Estimate([]byte("s3.amazonaws.com")); estimate != 0 {
+		t.Errorf("Expected a reset CountMin to be empty, got %d", estimate)
+	}
+}
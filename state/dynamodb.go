@@ -0,0 +1,459 @@
+package state
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/matryer/try"
+)
+
+// DynamoDBConfig defines the configuration of a DynamoDB store.
+// The table must have a binary partition key named Key, and can enable DynamoDB TTL on the ExpiresAt attribute.
+type DynamoDBConfig struct {
+	Region    string `json:"region"`
+	TableName string `json:"tableName"`
+	// KeyPrefix is prepended to every key, so rules can share a table
+	KeyPrefix string `json:"keyPrefix"`
+	// TTLSeconds expires keys set without their own TTL after this many seconds, if it's 0 they never expire
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+	// Retries is the number of times throttled or failed requests are retried, defaults to 3
+	Retries int `json:"retries,omitempty"`
+	// CacheSize is the number of values kept in memory to avoid reading them again, they aren't cached if it's 0.
+	// The cache belongs to the process, so it doesn't see writes to the table by other go-fish instances.
+	CacheSize int `json:"cacheSize,omitempty"`
+	// CacheTTLSeconds is how long a value is cached before it's read from the table again, defaults to 5
+	CacheTTLSeconds int `json:"cacheTTLSeconds,omitempty"`
+}
+
+// DynamoDBStore provides a KeyValue store persisted in a DynamoDB table
+type DynamoDBStore struct {
+	DynamoDBConfig
+	// TTL is the TTL of keys set without their own TTL
+	TTL       time.Duration
+	svc       dynamodbiface.DynamoDBAPI
+	cache     *lruCache
+	lifecycle lifecycle
+}
+
+// Init initialises the DynamoDB store, if it isn't already open
+func (d *DynamoDBStore) Init() error {
+	return d.lifecycle.init(d.open)
+}
+
+func (d *DynamoDBStore) open() error {
+	if d.TableName == "" {
+		return errors.New("DynamoDB state requires a table name")
+	}
+	if d.Retries == 0 {
+		d.Retries = 3
+	}
+	if d.CacheTTLSeconds == 0 {
+		d.CacheTTLSeconds = 5
+	}
+	if d.CacheSize > 0 {
+		d.cache = newLRUCache(d.CacheSize, time.Duration(d.CacheTTLSeconds)*time.Second)
+	}
+	if d.svc != nil {
+		return nil
+	}
+
+	session, err := session.NewSessionWithOptions(
+		session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+			Config:            aws.Config{Region: aws.String(d.Region)},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		session.Config.Endpoint = aws.String(endpoint)
+	}
+	d.svc = dynamodb.New(session)
+	return nil
+}
+
+// Close closes the DynamoDB store (does nothing)
+func (d *DynamoDBStore) Close() error {
+	return d.lifecycle.close(d.close)
+}
+
+func (d *DynamoDBStore) close() error { return nil }
+
+// Set sets a Key to the defined value, expiring after the store's TTL
+func (d *DynamoDBStore) Set(key []byte, value []byte) error {
+	return d.SetWithTTL(key, value, d.TTL)
+}
+
+// SetWithTTL sets a Key to the defined value, expiring after the TTL.
+// A TTL of 0 means the key never expires.
+func (d *DynamoDBStore) SetWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	item := map[string]*dynamodb.AttributeValue{
+		"Key": {
+			B: d.key(key),
+		},
+		"Value": {
+			B: value,
+		},
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+		item["ExpiresAt"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(expires.Unix(), 10)),
+		}
+	}
+	err := d.retry(func() error {
+		_, err := d.svc.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(d.TableName),
+			Item:      item,
+		})
+		return err
+	})
+	if d.cache != nil {
+		if err != nil {
+			d.cache.remove(string(key))
+		} else {
+			d.cache.add(string(key), value, expires)
+		}
+	}
+	return err
+}
+
+// Get retrieves the specified value, or nil if it doesn't exist or has expired
+func (d *DynamoDBStore) Get(key []byte) []byte {
+	if d.cache != nil {
+		if value, ok := d.cache.get(string(key)); ok {
+			return value
+		}
+	}
+
+	item, err := d.getItem(key)
+	if err != nil || item["Value"] == nil {
+		return nil
+	}
+	// DynamoDB deletes expired items lazily, so they may still be returned
+	expires := expiresAt(item)
+	if !expires.IsZero() && !time.Now().Before(expires) {
+		return nil
+	}
+
+	value := item["Value"].B
+	if d.cache != nil {
+		d.cache.add(string(key), value, expires)
+	}
+	return value
+}
+
+// ForEach executes the function for each key/value pair that hasn't expired, with the key prefix removed.
+// The table is scanned, so this reads every item in it.
+func (d *DynamoDBStore) ForEach(function func(k, v []byte) error) error {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(d.TableName),
+	}
+	if d.KeyPrefix != "" {
+		input.FilterExpression = aws.String("begins_with(#key, :prefix)")
+		input.ExpressionAttributeNames = map[string]*string{
+			"#key": aws.String("Key"),
+		}
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":prefix": {
+				B: []byte(d.KeyPrefix),
+			},
+		}
+	}
+
+	for {
+		var page *dynamodb.ScanOutput
+		err := d.retry(func() error {
+			var err error
+			page, err = d.svc.Scan(input)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, item := range page.Items {
+			if item["Key"] == nil || item["Value"] == nil {
+				continue
+			}
+			if expires := expiresAt(item); !expires.IsZero() && !now.Before(expires) {
+				continue
+			}
+			if err = function(item["Key"].B[len(d.KeyPrefix):], item["Value"].B); err != nil {
+				return err
+			}
+		}
+		if len(page.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		input.ExclusiveStartKey = page.LastEvaluatedKey
+	}
+}
+
+// Delete deletes the given key
+func (d *DynamoDBStore) Delete(key []byte) {
+	if d.cache != nil {
+		d.cache.remove(string(key))
+	}
+	d.retry(func() error {
+		_, err := d.svc.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: aws.String(d.TableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"Key": {
+					B: d.key(key),
+				},
+			},
+		})
+		return err
+	})
+}
+
+// Add atomically adds delta to the count of a key, kept in the Count attribute, and returns the new count.
+// A new count expires after the store's TTL.
+func (d *DynamoDBStore) Add(key []byte, delta int64) (int64, error) {
+	expression := "ADD #count :delta"
+	names := map[string]*string{
+		"#count": aws.String("Count"),
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":delta": {
+			N: aws.String(strconv.FormatInt(delta, 10)),
+		},
+	}
+	if d.TTL > 0 {
+		expression += " SET #expires = if_not_exists(#expires, :expires)"
+		names["#expires"] = aws.String("ExpiresAt")
+		values[":expires"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(time.Now().Add(d.TTL).Unix(), 10)),
+		}
+	}
+	item, err := d.update(key, expression, names, values)
+	if err != nil {
+		return 0, err
+	}
+	return parseDynamoCount(key, item)
+}
+
+// Count returns the count of a key, or 0 if it hasn't been counted
+func (d *DynamoDBStore) Count(key []byte) (int64, error) {
+	item, err := d.getItem(key)
+	if err != nil {
+		return 0, err
+	}
+	return parseDynamoCount(key, item)
+}
+
+// AddMember adds a member to the set of a key, kept in the Members attribute
+func (d *DynamoDBStore) AddMember(key []byte, member []byte) error {
+	_, err := d.update(key, "ADD #members :member", map[string]*string{
+		"#members": aws.String("Members"),
+	}, map[string]*dynamodb.AttributeValue{
+		":member": {
+			BS: [][]byte{member},
+		},
+	})
+	return err
+}
+
+// RemoveMember removes a member from the set of a key
+func (d *DynamoDBStore) RemoveMember(key []byte, member []byte) error {
+	_, err := d.update(key, "DELETE #members :member", map[string]*string{
+		"#members": aws.String("Members"),
+	}, map[string]*dynamodb.AttributeValue{
+		":member": {
+			BS: [][]byte{member},
+		},
+	})
+	return err
+}
+
+// HasMember returns whether a member is in the set of a key
+func (d *DynamoDBStore) HasMember(key []byte, member []byte) (bool, error) {
+	members, err := d.Members(key)
+	for _, m := range members {
+		if bytes.Equal(m, member) {
+			return true, nil
+		}
+	}
+	return false, err
+}
+
+// Members returns the members of the set of a key
+func (d *DynamoDBStore) Members(key []byte) ([][]byte, error) {
+	item, err := d.getItem(key)
+	if err != nil || item["Members"] == nil {
+		return nil, err
+	}
+	return item["Members"].BS, nil
+}
+
+func (d *DynamoDBStore) getItem(key []byte) (map[string]*dynamodb.AttributeValue, error) {
+	var item *dynamodb.GetItemOutput
+	err := d.retry(func() error {
+		var err error
+		item, err = d.svc.GetItem(&dynamodb.GetItemInput{
+			TableName: aws.String(d.TableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"Key": {
+					B: d.key(key),
+				},
+			},
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item.Item, nil
+}
+
+// update applies an update expression to the item of a key and returns the updated attributes
+func (d *DynamoDBStore) update(key []byte, expression string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	var output *dynamodb.UpdateItemOutput
+	err := d.retry(func() error {
+		var err error
+		output, err = d.svc.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(d.TableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"Key": {
+					B: d.key(key),
+				},
+			},
+			UpdateExpression:          aws.String(expression),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ReturnValues:              aws.String(dynamodb.ReturnValueUpdatedNew),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Attributes, nil
+}
+
+func parseDynamoCount(key []byte, item map[string]*dynamodb.AttributeValue) (int64, error) {
+	if item["Count"] == nil || item["Count"].N == nil {
+		return 0, nil
+	}
+	count, err := strconv.ParseInt(*item["Count"].N, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Count of %s is invalid: %v", key, err)
+	}
+	return count, nil
+}
+
+func (d *DynamoDBStore) key(key []byte) []byte {
+	return append([]byte(d.KeyPrefix), key...)
+}
+
+// retry retries throttled and failed requests with exponential backoff
+func (d *DynamoDBStore) retry(call func() error) error {
+	return try.Do(func(attempt int) (bool, error) {
+		err := call()
+		if awsErr, ok := err.(awserr.Error); ok && attempt <= d.Retries {
+			if awsErr.Code() == dynamodb.ErrCodeProvisionedThroughputExceededException ||
+				awsErr.Code() == dynamodb.ErrCodeInternalServerError {
+				// Backoff time as recommended by https://docs.aws.amazon.com/general/latest/gr/api-retries.html
+				time.Sleep(time.Duration(1<<uint(attempt)*100) * time.Millisecond)
+				return true, err
+			}
+		}
+		return false, err
+	})
+}
+
+func expiresAt(item map[string]*dynamodb.AttributeValue) time.Time {
+	if item["ExpiresAt"] == nil || item["ExpiresAt"].N == nil {
+		return time.Time{}
+	}
+	seconds, err := strconv.ParseInt(*item["ExpiresAt"].N, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// lruCache keeps the most recently used values for up to ttl, evicting the least recently used when it's full
+type lruCache struct {
+	sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && !time.Now().Before(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// add caches a value until it expires or the cache's TTL has passed, whichever is sooner
+func (c *lruCache) add(key string, value []byte, expires time.Time) {
+	if stale := time.Now().Add(c.ttl); expires.IsZero() || stale.Before(expires) {
+		expires = stale
+	}
+	c.Lock()
+	defer c.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value = &cacheEntry{key, value, expires}
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key, value, expires})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *lruCache) remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+}
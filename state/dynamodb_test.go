@@ -0,0 +1,281 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	items    map[string]map[string]*dynamodb.AttributeValue
+	gets     int
+	throttle int
+}
+
+func (m *mockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	if m.throttle > 0 {
+		m.throttle--
+		return nil, awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", errors.New(""))
+	}
+	m.items[string(input.Item["Key"].B)] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *mockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	m.gets++
+	return &dynamodb.GetItemOutput{Item: m.items[string(input.Key["Key"].B)]}, nil
+}
+
+func (m *mockDynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	delete(m.items, string(input.Key["Key"].B))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+var setIfNotExists = regexp.MustCompile(`SET (#\w+) = if_not_exists\(#\w+, (:\w+)\)`)
+
+// UpdateItem supports the ADD and DELETE actions on a single number or binary set attribute,
+// optionally followed by a SET action that sets an attribute if it doesn't exist
+func (m *mockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	var action, name, value string
+	fmt.Sscanf(*input.UpdateExpression, "%s %s %s", &action, &name, &value)
+	attribute := *input.ExpressionAttributeNames[name]
+	operand := input.ExpressionAttributeValues[value]
+
+	key := string(input.Key["Key"].B)
+	item, ok := m.items[key]
+	if !ok {
+		item = map[string]*dynamodb.AttributeValue{"Key": input.Key["Key"]}
+		m.items[key] = item
+	}
+	if set := setIfNotExists.FindStringSubmatch(*input.UpdateExpression); set != nil {
+		setAttribute := *input.ExpressionAttributeNames[set[1]]
+		if item[setAttribute] == nil {
+			item[setAttribute] = input.ExpressionAttributeValues[set[2]]
+		}
+	}
+	current := item[attribute]
+	switch {
+	case operand.N != nil:
+		var count, delta int64
+		if current != nil {
+			count, _ = strconv.ParseInt(*current.N, 10, 64)
+		}
+		delta, _ = strconv.ParseInt(*operand.N, 10, 64)
+		item[attribute] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(count+delta, 10))}
+	case action == "ADD":
+		if current == nil {
+			current = &dynamodb.AttributeValue{}
+			item[attribute] = current
+		}
+		for _, member := range operand.BS {
+			found := false
+			for _, existing := range current.BS {
+				found = found || bytes.Equal(existing, member)
+			}
+			if !found {
+				current.BS = append(current.BS, member)
+			}
+		}
+	case action == "DELETE" && current != nil:
+		var members [][]byte
+		for _, existing := range current.BS {
+			if !bytes.Equal(existing, operand.BS[0]) {
+				members = append(members, existing)
+			}
+		}
+		current.BS = members
+	}
+	return &dynamodb.UpdateItemOutput{Attributes: map[string]*dynamodb.AttributeValue{attribute: item[attribute]}}, nil
+}
+
+// Scan returns a page per item, to test pagination
+func (m *mockDynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	var keys []string
+	for key := range m.items {
+		if prefix, ok := input.ExpressionAttributeValues[":prefix"]; ok && !bytes.HasPrefix([]byte(key), prefix.B) {
+			continue
+		}
+		if input.ExclusiveStartKey != nil && key <= string(input.ExclusiveStartKey["Key"].B) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return &dynamodb.ScanOutput{}, nil
+	}
+	sort.Strings(keys)
+	item := m.items[keys[0]]
+	return &dynamodb.ScanOutput{
+		Items:            []map[string]*dynamodb.AttributeValue{item},
+		LastEvaluatedKey: map[string]*dynamodb.AttributeValue{"Key": item["Key"]},
+	}, nil
+}
+
+func newTestDynamoDBStore(mock *mockDynamoDB, cacheSize int) *DynamoDBStore {
+	store := &DynamoDBStore{
+		DynamoDBConfig: DynamoDBConfig{TableName: "state", KeyPrefix: "rule/", CacheSize: cacheSize},
+		svc:            mock,
+	}
+	store.Init()
+	return store
+}
+
+func TestDynamoDBStore(t *testing.T) {
+	mock := &mockDynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{
+		"other/foo": {"Key": {B: []byte("other/foo")}, "Value": {B: []byte("other")}},
+	}}
+	store := newTestDynamoDBStore(mock, 0)
+
+	if err := store.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("Could not set key/value %s", err)
+	}
+	store.Set([]byte("baz"), []byte("qux"))
+	if _, ok := mock.items["rule/foo"]; !ok {
+		t.Errorf("Expected the key to be prefixed, got %v", mock.items)
+	}
+	if value := store.Get([]byte("foo")); string(value) != "bar" {
+		t.Errorf("Expected value at foo to be bar, got %s", value)
+	}
+
+	pairs := map[string]string{}
+	store.ForEach(func(k, v []byte) error {
+		pairs[string(k)] = string(v)
+		return nil
+	})
+	if !reflect.DeepEqual(pairs, map[string]string{"foo": "bar", "baz": "qux"}) {
+		t.Errorf("Expected ForEach to only visit the rule's keys, got %v", pairs)
+	}
+
+	store.Delete([]byte("foo"))
+	if value := store.Get([]byte("foo")); value != nil {
+		t.Errorf("Expected value at foo to be empty, got %s", value)
+	}
+}
+
+func TestDynamoDBStoreTTL(t *testing.T) {
+	mock := &mockDynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{
+		"rule/expired": {
+			"Key":       {B: []byte("rule/expired")},
+			"Value":     {B: []byte("bar")},
+			"ExpiresAt": {N: aws.String("1")},
+		},
+	}}
+	store := newTestDynamoDBStore(mock, 0)
+	store.SetWithTTL([]byte("live"), []byte("bar"), time.Hour)
+
+	if mock.items["rule/live"]["ExpiresAt"] == nil {
+		t.Error("Expected the item to have an expiry time")
+	}
+	if value := store.Get([]byte("expired")); value != nil {
+		t.Errorf("Expected an expired item not yet deleted by DynamoDB to be empty, got %s", value)
+	}
+	var keys []string
+	store.ForEach(func(k, v []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	if !reflect.DeepEqual(keys, []string{"live"}) {
+		t.Errorf("Expected ForEach to skip expired items, got %v", keys)
+	}
+}
+
+func TestDynamoDBStoreCounterTTL(t *testing.T) {
+	mock := &mockDynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{}}
+	store := newTestDynamoDBStore(mock, 0)
+	store.TTL = time.Hour
+
+	store.Add([]byte("logins"), 1)
+	expires := mock.items["rule/logins"]["ExpiresAt"]
+	if expires == nil {
+		t.Fatal("Expected a new count to have an expiry time")
+	}
+	mock.items["rule/logins"]["ExpiresAt"] = &dynamodb.AttributeValue{N: aws.String("4102444800")}
+	if count, _ := store.Add([]byte("logins"), 2); count != 3 {
+		t.Errorf("Expected a count of 3, got %d", count)
+	}
+	if *mock.items["rule/logins"]["ExpiresAt"].N != "4102444800" {
+		t.Errorf("Expected adding to an existing count not to change its expiry time, got %s", *mock.items["rule/logins"]["ExpiresAt"].N)
+	}
+}
+
+func TestDynamoDBStoreCache(t *testing.T) {
+	mock := &mockDynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{
+		"rule/a": {"Key": {B: []byte("rule/a")}, "Value": {B: []byte("1")}},
+		"rule/b": {"Key": {B: []byte("rule/b")}, "Value": {B: []byte("2")}},
+		"rule/c": {"Key": {B: []byte("rule/c")}, "Value": {B: []byte("3")}},
+	}}
+	store := newTestDynamoDBStore(mock, 2)
+
+	store.Get([]byte("a"))
+	store.Get([]byte("a"))
+	if mock.gets != 1 {
+		t.Errorf("Expected a cached value to be read once, got %d reads", mock.gets)
+	}
+	store.Get([]byte("b"))
+	store.Get([]byte("c"))
+	store.Get([]byte("a"))
+	if mock.gets != 4 {
+		t.Errorf("Expected the least recently used value to be evicted, got %d reads", mock.gets)
+	}
+
+	store.Set([]byte("c"), []byte("4"))
+	if value := store.Get([]byte("c")); string(value) != "4" || mock.gets != 4 {
+		t.Errorf("Expected a set value to be cached, got %s after %d reads", value, mock.gets)
+	}
+	store.Delete([]byte("c"))
+	if value := store.Get([]byte("c")); value != nil {
+		t.Errorf("Expected a deleted value to be removed from the cache, got %s", value)
+	}
+}
+
+func TestDynamoDBStoreCacheTTL(t *testing.T) {
+	mock := &mockDynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{
+		"rule/a": {"Key": {B: []byte("rule/a")}, "Value": {B: []byte("1")}},
+	}}
+	store := newTestDynamoDBStore(mock, 2)
+	if store.CacheTTLSeconds != 5 {
+		t.Errorf("Expected the cache TTL to default to 5 seconds, got %d", store.CacheTTLSeconds)
+	}
+	store.cache.ttl = 10 * time.Millisecond
+
+	store.Get([]byte("a"))
+	// Another instance changes the value
+	mock.items["rule/a"]["Value"] = &dynamodb.AttributeValue{B: []byte("2")}
+	if value := store.Get([]byte("a")); string(value) != "1" {
+		t.Errorf("Expected the value to be cached, got %s", value)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if value := store.Get([]byte("a")); string(value) != "2" || mock.gets != 2 {
+		t.Errorf("Expected the value to be read again after the cache TTL, got %s after %d reads", value, mock.gets)
+	}
+}
+
+func TestDynamoDBStoreRetries(t *testing.T) {
+	mock := &mockDynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{}, throttle: 1}
+	store := newTestDynamoDBStore(mock, 0)
+	if err := store.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Errorf("Expected a throttled request to be retried, got %s", err)
+	}
+
+	mock.throttle = 5
+	store.Retries = 1
+	if err := store.Set([]byte("foo"), []byte("bar")); err == nil {
+		t.Error("Expected a request throttled more than the retries to fail")
+	}
+}
+
+func TestDynamoDBStoreCountersAndSets(t *testing.T) {
+	testKeyedCounterAndSet(t, newTestDynamoDBStore(&mockDynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{}}, 0))
+}
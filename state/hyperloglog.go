@@ -0,0 +1,156 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// HyperLogLogConfig defines the configuration of a HyperLogLog state
+type HyperLogLogConfig struct {
+	// ErrorRate is the standard error of the count, defaults to 0.01
+	ErrorRate float64 `json:"errorRate,omitempty"`
+	// SnapshotFile persists the sketch, it's loaded when initialised and saved when closed
+	SnapshotFile string `json:"snapshotFile,omitempty"`
+}
+
+// HyperLogLog estimates the number of distinct items added to it, in a fixed amount of memory
+type HyperLogLog struct {
+	HyperLogLogConfig
+	sync.Mutex
+	precision uint8
+	registers []uint8
+	lifecycle lifecycle
+}
+
+// NewHyperLogLog creates a HyperLogLog with the standard error
+func NewHyperLogLog(errorRate float64) (*HyperLogLog, error) {
+	h := &HyperLogLog{HyperLogLogConfig: HyperLogLogConfig{ErrorRate: errorRate}}
+	return h, h.Init()
+}
+
+// Init initialises the HyperLogLog if it isn't already, using 2^p registers so the standard error of 1.04/sqrt(2^p) is within the error rate
+func (h *HyperLogLog) Init() error {
+	return h.lifecycle.init(h.open)
+}
+
+func (h *HyperLogLog) open() error {
+	if h.ErrorRate == 0 {
+		h.ErrorRate = 0.01
+	}
+	if h.ErrorRate < 0 || h.ErrorRate >= 1 {
+		return fmt.Errorf("Invalid HyperLogLog error rate: %v", h.ErrorRate)
+	}
+	precision := math.Ceil(2 * math.Log2(1.04/h.ErrorRate))
+	h.precision = uint8(math.Max(4, math.Min(18, precision)))
+	h.registers = make([]uint8, 1<<h.precision)
+	return loadSnapshot(h.SnapshotFile, h)
+}
+
+// Close saves the snapshot once every Init has been matched by a Close, if the HyperLogLog has one
+func (h *HyperLogLog) Close() error {
+	return h.lifecycle.close(h.close)
+}
+
+func (h *HyperLogLog) close() error {
+	return saveSnapshot(h.SnapshotFile, h)
+}
+
+// Add adds an item
+func (h *HyperLogLog) Add(item []byte) {
+	hash, _ := hashItem(item)
+	index := hash >> (64 - h.precision)
+	// The leading zeros of the remaining bits, with a sentinel bit so there are at most 64 - precision
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1))) + 1
+	h.Lock()
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+	h.Unlock()
+}
+
+// Count returns the estimated number of distinct items added
+func (h *HyperLogLog) Count() uint64 {
+	h.Lock()
+	defer h.Unlock()
+	m := float64(len(h.registers))
+	var sum float64
+	var zeros int
+	for _, register := range h.registers {
+		sum += 1 / float64(uint64(1)<<register)
+		if register == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / sum
+	// Linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// Merge adds the items of another HyperLogLog with the same error rate, such as one from another window
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	other.Lock()
+	registers := append([]uint8{}, other.registers...)
+	other.Unlock()
+
+	h.Lock()
+	defer h.Unlock()
+	if len(registers) != len(h.registers) {
+		return errors.New("Can't merge HyperLogLogs with different error rates")
+	}
+	for i, register := range registers {
+		if register > h.registers[i] {
+			h.registers[i] = register
+		}
+	}
+	return nil
+}
+
+// Reset removes all the items
+func (h *HyperLogLog) Reset() {
+	h.Lock()
+	h.registers = make([]uint8, len(h.registers))
+	h.Unlock()
+}
+
+// MarshalBinary serialises the HyperLogLog
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	h.Lock()
+	defer h.Unlock()
+	return append([]byte{sketchVersion, h.precision}, h.registers...), nil
+}
+
+// UnmarshalBinary restores a HyperLogLog serialised with the same error rate
+func (h *HyperLogLog) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 || data[0] != sketchVersion {
+		return errors.New("Invalid HyperLogLog")
+	}
+	h.Lock()
+	defer h.Unlock()
+	if h.registers != nil && data[1] != h.precision {
+		return errors.New("HyperLogLog was serialised with a different error rate")
+	}
+	if data[1] < 4 || data[1] > 18 || len(data)-2 != 1<<data[1] {
+		return errors.New("Invalid HyperLogLog")
+	}
+	h.precision = data[1]
+	h.registers = append([]uint8{}, data[2:]...)
+	return nil
+}
@@ -0,0 +1,76 @@
+package state
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestHyperLogLogCount(t *testing.T) {
+	h, err := NewHyperLogLog(0.01)
+	if err != nil {
+		t.Fatalf("Error creating HyperLogLog %s", err)
+	}
+	if count := h.Count(); count != 0 {
+		t.Errorf("Expected an empty HyperLogLog to count 0, got %d", count)
+	}
+	for _, n := range []int{10, 1000, 100000} {
+		h.Reset()
+		for i := 0; i < n; i++ {
+			// Duplicates shouldn't be counted
+			h.Add([]byte(fmt.Sprintf("10.0.0.%d", i)))
+			h.Add([]byte(fmt.Sprintf("10.0.0.%d", i)))
+		}
+		if count := h.Count(); math.Abs(float64(count)-float64(n)) > 0.03*float64(n) {
+			t.Errorf("Expected a count of about %d, got %d", n, count)
+		}
+	}
+
+	if _, err := NewHyperLogLog(1.5); err == nil {
+		t.Errorf("Expected an invalid error rate to be rejected")
+	}
+}
+
+func TestHyperLogLogMergeAndSnapshot(t *testing.T) {
+	first, _ := NewHyperLogLog(0.02)
+	second, _ := NewHyperLogLog(0.02)
+	for i := 0; i < 2000; i++ {
+		first.Add([]byte(fmt.Sprintf("user%d", i)))
+		second.Add([]byte(fmt.Sprintf("user%d", i+1000)))
+	}
+	if err := first.Merge(second); err != nil {
+		t.Fatalf("Error merging HyperLogLogs %s", err)
+	}
+	if count := first.Count(); math.Abs(float64(count)-3000) > 150 {
+		t.Errorf("Expected the merged count to be about 3000, got %d", count)
+	}
+	other, _ := NewHyperLogLog(0.1)
+	if err := first.Merge(other); err == nil {
+		t.Errorf("Expected merging different error rates to fail")
+	}
+
+	snapshotFile := "hyperloglog_test.snapshot"
+	defer os.Remove(snapshotFile)
+	h := &HyperLogLog{HyperLogLogConfig: HyperLogLogConfig{ErrorRate: 0.02, SnapshotFile: snapshotFile}}
+	if err := h.Init(); err != nil {
+		t.Fatalf("Error initialising HyperLogLog %s", err)
+	}
+	h.Merge(first)
+	h.Close()
+
+	restored := &HyperLogLog{HyperLogLogConfig: HyperLogLogConfig{ErrorRate: 0.02, SnapshotFile: snapshotFile}}
+	if err := restored.Init(); err != nil {
+		t.Fatalf("Error restoring HyperLogLog %s", err)
+	}
+	if restored.Count() != first.Count() {
+		t.Errorf("Expected the restored count to be %d, got %d", first.Count(), restored.Count())
+	}
+	mismatched := &HyperLogLog{HyperLogLogConfig: HyperLogLogConfig{ErrorRate: 0.1, SnapshotFile: snapshotFile}}
+	if err := mismatched.Init(); err == nil {
+		t.Errorf("Expected a snapshot with a different error rate to be rejected")
+	}
+	if err := restored.UnmarshalBinary([]byte{sketchVersion, 14, 0}); err == nil {
+		t.Errorf("Expected a truncated HyperLogLog to be rejected")
+	}
+}
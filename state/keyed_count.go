@@ -0,0 +1,352 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	log "github.com/sirupsen/logrus"
+)
+
+// KeyedCountConfig defines the configuration of a KeyedCount state
+type KeyedCountConfig struct {
+	// WindowSeconds is the period counts slide over, if it's 0 counts only reset when the window is taken
+	WindowSeconds int `json:"windowSeconds,omitempty"`
+	// Buckets is the number of time buckets the window is split into, defaults to 10.
+	// Counts expire a bucket at a time, so more buckets slide more smoothly.
+	Buckets int `json:"buckets,omitempty"`
+	// DbFileName and BucketName persist the counts in BoltDB, otherwise they're only kept in memory.
+	// BucketName defaults to KeyedCount.
+	DbFileName string `json:"dbFileName,omitempty"`
+	BucketName string `json:"bucketName,omitempty"`
+	// FlushIntervalMs is how often changed counts are written to BoltDB in a single transaction, defaults to 1000.
+	// Counts added since the last flush are lost if the process exits without closing the store.
+	FlushIntervalMs int `json:"flushIntervalMs,omitempty"`
+}
+
+// KeyCount is the count of a key
+type KeyCount struct {
+	Key   string
+	Count int64
+}
+
+// KeyedCountStore keeps a count for each key over a sliding window.
+// Each key has a ring buffer of time buckets, and its count is the sum of the buckets in the window.
+type KeyedCountStore struct {
+	KeyedCountConfig
+	sync.Mutex
+	counts      map[string]*bucketRing
+	bucketWidth time.Duration
+	db          *bolt.DB
+	// dirty are the keys whose counts have changed since they were last flushed
+	dirty     map[string]struct{}
+	now       func() time.Time
+	closeChan chan struct{}
+	wg        sync.WaitGroup
+	lifecycle lifecycle
+}
+
+// bucketRing holds the counts of a key, latest is the number of the most recent bucket since the epoch
+type bucketRing struct {
+	latest  int64
+	buckets []int64
+}
+
+// Init initialises the store, loading the counts persisted in BoltDB, if it isn't already open
+func (c *KeyedCountStore) Init() error {
+	return c.lifecycle.init(c.open)
+}
+
+func (c *KeyedCountStore) open() error {
+	if c.Buckets == 0 {
+		c.Buckets = 10
+	}
+	if c.WindowSeconds < 0 || c.Buckets < 0 {
+		return errors.New("KeyedCount state requires a positive window and number of buckets")
+	}
+	if c.WindowSeconds == 0 {
+		c.Buckets = 1
+	}
+	c.bucketWidth = time.Duration(c.WindowSeconds) * time.Second / time.Duration(c.Buckets)
+	if c.now == nil {
+		c.now = time.Now
+	}
+	c.counts = make(map[string]*bucketRing)
+	c.dirty = make(map[string]struct{})
+	if c.DbFileName == "" {
+		return nil
+	}
+
+	if c.BucketName == "" {
+		c.BucketName = "KeyedCount"
+	}
+	if c.FlushIntervalMs == 0 {
+		c.FlushIntervalMs = 1000
+	}
+	var err error
+	c.db, err = bolt.Open(c.DbFileName, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(c.BucketName))
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(key, value []byte) error {
+			if ring := decodeBucketRing(value); len(ring.buckets) == c.Buckets {
+				c.counts[string(key)] = ring
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	c.closeChan = make(chan struct{})
+	c.wg.Add(1)
+	go c.flusher(c.closeChan)
+	return nil
+}
+
+// Close closes the store once every Init has been matched by a Close, flushing the changed counts to BoltDB
+func (c *KeyedCountStore) Close() error {
+	return c.lifecycle.close(c.close)
+}
+
+func (c *KeyedCountStore) close() error {
+	if c.closeChan != nil {
+		close(c.closeChan)
+		c.wg.Wait()
+		c.closeChan = nil
+	}
+	if c.db == nil {
+		return nil
+	}
+	c.Lock()
+	err := c.flush()
+	c.Unlock()
+	if closeErr := c.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// flusher writes the changed counts to BoltDB every FlushIntervalMs until the store is closed
+func (c *KeyedCountStore) flusher(closeChan chan struct{}) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(time.Duration(c.FlushIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closeChan:
+			return
+		case <-ticker.C:
+			c.Lock()
+			if err := c.flush(); err != nil {
+				log.Errorf("Error flushing keyed counts to %s: %v", c.DbFileName, err)
+			}
+			c.Unlock()
+		}
+	}
+}
+
+// flush writes the counts that have changed since the last flush in one transaction, callers must hold the lock
+func (c *KeyedCountStore) flush() error {
+	if len(c.dirty) == 0 {
+		return nil
+	}
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(c.BucketName))
+		for key := range c.dirty {
+			if ring, ok := c.counts[key]; ok {
+				if err := b.Put([]byte(key), ring.encode()); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		c.dirty = make(map[string]struct{})
+	}
+	return err
+}
+
+// Add adds delta to the count of a key in the current bucket and returns the key's count over the window
+func (c *KeyedCountStore) Add(key []byte, delta int64) (int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	current := c.currentBucket()
+	ring, ok := c.counts[string(key)]
+	if !ok {
+		ring = &bucketRing{latest: current, buckets: make([]int64, c.Buckets)}
+		c.counts[string(key)] = ring
+	}
+	ring.advance(current)
+	ring.buckets[current%int64(c.Buckets)] += delta
+	if c.db != nil {
+		c.dirty[string(key)] = struct{}{}
+	}
+	return ring.sum(), nil
+}
+
+// Increment adds one to the count of a key and returns the key's count over the window
+func (c *KeyedCountStore) Increment(key []byte) (int64, error) {
+	return c.Add(key, 1)
+}
+
+// Count returns the count of a key over the window
+func (c *KeyedCountStore) Count(key []byte) (int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	ring, ok := c.counts[string(key)]
+	if !ok {
+		return 0, nil
+	}
+	ring.advance(c.currentBucket())
+	return ring.sum(), nil
+}
+
+// TopK returns the k keys with the highest counts over the window, highest first
+func (c *KeyedCountStore) TopK(k int) ([]KeyCount, error) {
+	c.Lock()
+	defer c.Unlock()
+	counts, err := c.totals()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Key < counts[j].Key
+	})
+	if len(counts) > k {
+		counts = counts[:k]
+	}
+	return counts, nil
+}
+
+// Window returns the count of every key over the window and resets them
+func (c *KeyedCountStore) Window() (map[string]int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	counts, err := c.totals()
+	if err != nil {
+		return nil, err
+	}
+	c.counts = make(map[string]*bucketRing)
+	c.dirty = make(map[string]struct{})
+	if c.db != nil {
+		err = c.db.Update(func(tx *bolt.Tx) error {
+			if err := tx.DeleteBucket([]byte(c.BucketName)); err != nil {
+				return err
+			}
+			_, err := tx.CreateBucket([]byte(c.BucketName))
+			return err
+		})
+	}
+
+	window := make(map[string]int64, len(counts))
+	for _, count := range counts {
+		window[count.Key] = count.Count
+	}
+	return window, err
+}
+
+// totals returns the count of every key, removing keys whose buckets have all expired
+func (c *KeyedCountStore) totals() ([]KeyCount, error) {
+	current := c.currentBucket()
+	var counts []KeyCount
+	var expired [][]byte
+	for key, ring := range c.counts {
+		ring.advance(current)
+		sum := ring.sum()
+		if ring.empty() {
+			delete(c.counts, key)
+			delete(c.dirty, key)
+			expired = append(expired, []byte(key))
+			continue
+		}
+		counts = append(counts, KeyCount{Key: key, Count: sum})
+	}
+	if c.db == nil || len(expired) == 0 {
+		return counts, nil
+	}
+	return counts, c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(c.BucketName))
+		for _, key := range expired {
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *KeyedCountStore) currentBucket() int64 {
+	if c.bucketWidth == 0 {
+		return 0
+	}
+	return c.now().UnixNano() / int64(c.bucketWidth)
+}
+
+// advance moves the ring to the current bucket, clearing the buckets that have left the window
+func (r *bucketRing) advance(current int64) {
+	if current <= r.latest {
+		return
+	}
+	size := int64(len(r.buckets))
+	if current-r.latest >= size {
+		for i := range r.buckets {
+			r.buckets[i] = 0
+		}
+	} else {
+		for bucket := r.latest + 1; bucket <= current; bucket++ {
+			r.buckets[bucket%size] = 0
+		}
+	}
+	r.latest = current
+}
+
+func (r *bucketRing) sum() int64 {
+	var sum int64
+	for _, count := range r.buckets {
+		sum += count
+	}
+	return sum
+}
+
+func (r *bucketRing) empty() bool {
+	for _, count := range r.buckets {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *bucketRing) encode() []byte {
+	value := make([]byte, 8*(len(r.buckets)+1))
+	binary.BigEndian.PutUint64(value, uint64(r.latest))
+	for i, count := range r.buckets {
+		binary.BigEndian.PutUint64(value[8*(i+1):], uint64(count))
+	}
+	return value
+}
+
+func decodeBucketRing(value []byte) *bucketRing {
+	ring := &bucketRing{}
+	if len(value) < 8 || len(value)%8 != 0 {
+		return ring
+	}
+	ring.latest = int64(binary.BigEndian.Uint64(value))
+	for i := 8; i < len(value); i += 8 {
+		ring.buckets = append(ring.buckets, int64(binary.BigEndian.Uint64(value[i:])))
+	}
+	return ring
+}
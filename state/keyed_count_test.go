@@ -0,0 +1,133 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func TestKeyedCountSlidingWindow(t *testing.T) {
+	clock := &testClock{now: time.Unix(1500000000, 0)}
+	counter := &KeyedCountStore{
+		KeyedCountConfig: KeyedCountConfig{WindowSeconds: 300, Buckets: 5},
+		now:              clock.Now,
+	}
+	if err := counter.Init(); err != nil {
+		t.Fatalf("Error initialising counter %s", err)
+	}
+
+	counter.Increment([]byte("bob"))
+	clock.now = clock.now.Add(2 * time.Minute)
+	counter.Add([]byte("bob"), 2)
+	counter.Increment([]byte("alice"))
+	if count, _ := counter.Count([]byte("bob")); count != 3 {
+		t.Errorf("Expected bob's count to be 3, got %d", count)
+	}
+
+	clock.now = clock.now.Add(4 * time.Minute)
+	if count, _ := counter.Count([]byte("bob")); count != 2 {
+		t.Errorf("Expected bob's first count to have left the window, got %d", count)
+	}
+	if count, _ := counter.Count([]byte("carol")); count != 0 {
+		t.Errorf("Expected an uncounted key to be 0, got %d", count)
+	}
+
+	clock.now = clock.now.Add(10 * time.Minute)
+	if count, _ := counter.Add([]byte("bob"), 1); count != 1 {
+		t.Errorf("Expected bob's counts to have all expired, got %d", count)
+	}
+	if top, _ := counter.TopK(5); !reflect.DeepEqual(top, []KeyCount{{"bob", 1}}) {
+		t.Errorf("Expected expired keys to be removed, got %v", top)
+	}
+}
+
+func TestKeyedCountTopKAndWindow(t *testing.T) {
+	counter := &KeyedCountStore{}
+	if err := counter.Init(); err != nil {
+		t.Fatalf("Error initialising counter %s", err)
+	}
+	for key, count := range map[string]int64{"a": 3, "b": 5, "c": 1, "d": 3} {
+		counter.Add([]byte(key), count)
+	}
+
+	top, _ := counter.TopK(3)
+	if !reflect.DeepEqual(top, []KeyCount{{"b", 5}, {"a", 3}, {"d", 3}}) {
+		t.Errorf("Expected the top 3 keys, got %v", top)
+	}
+	window, _ := counter.Window()
+	if !reflect.DeepEqual(window, map[string]int64{"a": 3, "b": 5, "c": 1, "d": 3}) {
+		t.Errorf("Expected the window to contain every count, got %v", window)
+	}
+	if count, _ := counter.Count([]byte("b")); count != 0 {
+		t.Errorf("Expected counts to be reset by the window, got %d", count)
+	}
+}
+
+func TestKeyedCountPersistence(t *testing.T) {
+	config := KeyedCountConfig{WindowSeconds: 3600, Buckets: 4, DbFileName: "keyed_count_test.db"}
+	defer os.Remove("keyed_count_test.db")
+	counter := &KeyedCountStore{KeyedCountConfig: config}
+	if err := counter.Init(); err != nil {
+		t.Fatalf("Error initialising counter %s", err)
+	}
+	counter.Add([]byte("bob"), 4)
+	counter.Close()
+
+	counter = &KeyedCountStore{KeyedCountConfig: config}
+	if err := counter.Init(); err != nil {
+		t.Fatalf("Error initialising counter %s", err)
+	}
+	if count, _ := counter.Count([]byte("bob")); count != 4 {
+		t.Errorf("Expected the count to be persisted, got %d", count)
+	}
+	counter.Window()
+	counter.Close()
+
+	counter = &KeyedCountStore{KeyedCountConfig: config}
+	counter.Init()
+	defer counter.Close()
+	if count, _ := counter.Count([]byte("bob")); count != 0 {
+		t.Errorf("Expected the window to reset the persisted counts, got %d", count)
+	}
+}
+
+func TestKeyedCountFlush(t *testing.T) {
+	defer os.Remove("keyed_count_flush_test.db")
+	counter := &KeyedCountStore{KeyedCountConfig: KeyedCountConfig{
+		DbFileName:      "keyed_count_flush_test.db",
+		FlushIntervalMs: 20,
+	}}
+	if err := counter.Init(); err != nil {
+		t.Fatalf("Error initialising counter %s", err)
+	}
+	defer counter.Close()
+	persisted := func() int {
+		keys := 0
+		counter.db.View(func(tx *bolt.Tx) error {
+			keys = tx.Bucket([]byte(counter.BucketName)).Stats().KeyN
+			return nil
+		})
+		return keys
+	}
+
+	for i := 0; i < 100; i++ {
+		counter.Increment([]byte(fmt.Sprintf("user%d", i%10)))
+	}
+	if keys := persisted(); keys != 0 {
+		t.Errorf("Expected counts not to be written until they're flushed, got %d keys", keys)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if keys := persisted(); keys != 10 {
+		t.Errorf("Expected the changed counts to be flushed, got %d keys", keys)
+	}
+}
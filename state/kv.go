@@ -1,72 +1,185 @@
 package state
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/boltdb/bolt"
 )
 
+const defaultSweepInterval = time.Minute
+
 // KVConfig defines the configuration of a KeyValue store
 type KVConfig struct {
 	DbFileName string `json:"dbFileName"`
 	BucketName string `json:"bucketName"`
+	// TTLSeconds expires keys set without their own TTL after this many seconds, if it's 0 they never expire
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+	// SweepIntervalSec is how often expired keys are removed in the background, defaults to 60
+	SweepIntervalSec int `json:"sweepIntervalSec,omitempty"`
+}
+
+// KVStats are the number of keys in a KeyValue store
+type KVStats struct {
+	// Live is the number of keys that haven't expired
+	Live int
+	// Expired is the number of keys that have expired since the store was initialised
+	Expired int
 }
 
-// KVStore provides a persistent KeyValue store
+// KVStore provides a persistent KeyValue store.
+// Keys can expire, they are removed when they are next read or by a background sweeper.
 type KVStore struct {
 	DbFileName string
 	BucketName string
-	db         *bolt.DB
+	// TTL is the TTL of keys set without their own TTL
+	TTL           time.Duration
+	SweepInterval time.Duration
+	db            *bolt.DB
+	sync.Mutex
+	onExpire  func(key, value []byte)
+	onSweep   func(KVStats)
+	expired   int
+	closeChan chan struct{}
+	wg        sync.WaitGroup
+	lifecycle lifecycle
 }
 
-// Init initialises the KeyValue store
+// Init initialises the KeyValue store, if it isn't already open
 func (k *KVStore) Init() error {
+	return k.lifecycle.init(k.open)
+}
+
+func (k *KVStore) open() error {
 	var err error
 	k.db, err = bolt.Open(k.DbFileName, 0600, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		return err
 	}
 
-	return k.db.Update(func(tx *bolt.Tx) error {
+	err = k.db.Update(func(tx *bolt.Tx) error {
 		_, err = tx.CreateBucketIfNotExists([]byte(k.BucketName))
 		if err != nil {
 			return err
 		}
-		return nil
+		_, err = tx.CreateBucketIfNotExists(k.expiryBucket())
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(k.setsBucket())
+		return err
 	})
+	if err != nil {
+		return err
+	}
+
+	k.Lock()
+	k.expired = 0
+	k.Unlock()
+	if k.SweepInterval == 0 {
+		k.SweepInterval = defaultSweepInterval
+	}
+	k.closeChan = make(chan struct{})
+	k.wg.Add(1)
+	go k.sweeper(k.closeChan)
+	return nil
+}
+
+// Close closes the KeyValue Store ensuring it is persisted to disk, once every Init has been matched by a Close
+func (k *KVStore) Close() error {
+	return k.lifecycle.close(k.close)
+}
+
+func (k *KVStore) close() error {
+	if k.closeChan != nil {
+		close(k.closeChan)
+		k.wg.Wait()
+		k.closeChan = nil
+	}
+	return k.db.Close()
+}
+
+// expiryBucket holds the time each key with a TTL expires, in nanoseconds since the epoch
+func (k *KVStore) expiryBucket() []byte {
+	return []byte(k.BucketName + "Expiry")
+}
+
+// setsBucket holds a bucket of members for each set, keyed by the set's key
+func (k *KVStore) setsBucket() []byte {
+	return []byte(k.BucketName + "Sets")
+}
+
+// OnExpire sets a function called with each expired key and value as it's removed,
+// either by the background sweeper or by reading it. It must not block.
+func (k *KVStore) OnExpire(callback func(key, value []byte)) {
+	k.Lock()
+	k.onExpire = callback
+	k.Unlock()
 }
 
-// Close closes the KeyValue Store ensuring it is persisted to disk
-func (k *KVStore) Close() {
-	k.db.Close()
+// OnSweep sets a function called with the stats of the store after each sweep
+func (k *KVStore) OnSweep(callback func(KVStats)) {
+	k.Lock()
+	k.onSweep = callback
+	k.Unlock()
 }
 
-// Set sets a Key to the defined value
+// Set sets a Key to the defined value, expiring after the store's TTL
 func (k *KVStore) Set(key []byte, value []byte) error {
+	return k.SetWithTTL(key, value, k.TTL)
+}
+
+// SetWithTTL sets a Key to the defined value, expiring after the TTL.
+// A TTL of 0 means the key never expires.
+func (k *KVStore) SetWithTTL(key []byte, value []byte, ttl time.Duration) error {
 	return k.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(k.BucketName))
-		return b.Put(key, value)
+		if err := b.Put(key, value); err != nil {
+			return err
+		}
+		expiry := tx.Bucket(k.expiryBucket())
+		if ttl <= 0 {
+			return expiry.Delete(key)
+		}
+		return expiry.Put(key, expiryTime(time.Now().Add(ttl)))
 	})
 }
 
-// Get retrieves the specified value
+// Get retrieves the specified value, or nil if it has expired
 func (k *KVStore) Get(key []byte) []byte {
 	var value []byte
+	var expired bool
 	k.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(k.BucketName))
 		value = b.Get(key)
+		expired = isExpired(tx.Bucket(k.expiryBucket()).Get(key), time.Now())
 		return nil
 	})
 
+	if expired {
+		k.expire([][]byte{key})
+		return nil
+	}
 	return value
 }
 
-// ForEach executes the function for each key/value pair
+// ForEach executes the function for each key/value pair that hasn't expired
 func (k *KVStore) ForEach(function func(k, v []byte) error) error {
 	return k.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(k.BucketName))
+		expiry := tx.Bucket(k.expiryBucket())
+		now := time.Now()
 
-		return b.ForEach(function)
+		return b.ForEach(func(key, value []byte) error {
+			if isExpired(expiry.Get(key), now) {
+				return nil
+			}
+			return function(key, value)
+		})
 	})
 }
 
@@ -75,6 +188,212 @@ func (k *KVStore) Delete(key []byte) {
 	k.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(k.BucketName))
 		b.Delete(key)
+		tx.Bucket(k.expiryBucket()).Delete(key)
+		return nil
+	})
+}
+
+// Add adds delta to the count of a key, stored as a decimal value, and returns the new count.
+// A new count expires after the store's TTL.
+func (k *KVStore) Add(key []byte, delta int64) (int64, error) {
+	var count int64
+	err := k.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(k.BucketName))
+		expiry := tx.Bucket(k.expiryBucket())
+		value := b.Get(key)
+		if isExpired(expiry.Get(key), time.Now()) {
+			value = nil
+		}
+		var err error
+		if count, err = parseCount(key, value); err != nil {
+			return err
+		}
+		count += delta
+		if err = b.Put(key, []byte(strconv.FormatInt(count, 10))); err != nil {
+			return err
+		}
+		if value != nil {
+			return nil
+		}
+		if k.TTL <= 0 {
+			return expiry.Delete(key)
+		}
+		return expiry.Put(key, expiryTime(time.Now().Add(k.TTL)))
+	})
+	return count, err
+}
+
+// Count returns the count of a key, or 0 if it hasn't been counted
+func (k *KVStore) Count(key []byte) (int64, error) {
+	return parseCount(key, k.Get(key))
+}
+
+// AddMember adds a member to the set of a key
+func (k *KVStore) AddMember(key []byte, member []byte) error {
+	return k.db.Update(func(tx *bolt.Tx) error {
+		set, err := tx.Bucket(k.setsBucket()).CreateBucketIfNotExists(key)
+		if err != nil {
+			return err
+		}
+		return set.Put(member, []byte{})
+	})
+}
+
+// RemoveMember removes a member from the set of a key
+func (k *KVStore) RemoveMember(key []byte, member []byte) error {
+	return k.db.Update(func(tx *bolt.Tx) error {
+		set := tx.Bucket(k.setsBucket()).Bucket(key)
+		if set == nil {
+			return nil
+		}
+		return set.Delete(member)
+	})
+}
+
+// HasMember returns whether a member is in the set of a key
+func (k *KVStore) HasMember(key []byte, member []byte) (bool, error) {
+	var ok bool
+	err := k.db.View(func(tx *bolt.Tx) error {
+		set := tx.Bucket(k.setsBucket()).Bucket(key)
+		ok = set != nil && set.Get(member) != nil
+		return nil
+	})
+	return ok, err
+}
+
+// Members returns the members of the set of a key, in order
+func (k *KVStore) Members(key []byte) ([][]byte, error) {
+	var members [][]byte
+	err := k.db.View(func(tx *bolt.Tx) error {
+		set := tx.Bucket(k.setsBucket()).Bucket(key)
+		if set == nil {
+			return nil
+		}
+		return set.ForEach(func(member, _ []byte) error {
+			members = append(members, append([]byte{}, member...))
+			return nil
+		})
+	})
+	return members, err
+}
+
+// Stats returns the number of live keys and the number of keys expired since the store was initialised
+func (k *KVStore) Stats() KVStats {
+	var stats KVStats
+	k.db.View(func(tx *bolt.Tx) error {
+		stats.Live = tx.Bucket([]byte(k.BucketName)).Stats().KeyN
+		now := time.Now()
+		return tx.Bucket(k.expiryBucket()).ForEach(func(_, expiry []byte) error {
+			if isExpired(expiry, now) {
+				stats.Live--
+			}
+			return nil
+		})
+	})
+	k.Lock()
+	stats.Expired = k.expired
+	k.Unlock()
+	return stats
+}
+
+// Sweep removes all the expired keys
+func (k *KVStore) Sweep() {
+	var expired [][]byte
+	k.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		return tx.Bucket(k.expiryBucket()).ForEach(func(key, expiry []byte) error {
+			if isExpired(expiry, now) {
+				expired = append(expired, append([]byte{}, key...))
+			}
+			return nil
+		})
+	})
+	k.expire(expired)
+
+	k.Lock()
+	onSweep := k.onSweep
+	k.Unlock()
+	if onSweep != nil {
+		onSweep(k.Stats())
+	}
+}
+
+func (k *KVStore) sweeper(closeChan chan struct{}) {
+	defer k.wg.Done()
+	ticker := time.NewTicker(k.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closeChan:
+			return
+		case <-ticker.C:
+			k.Sweep()
+		}
+	}
+}
+
+// expire deletes the keys that are still expired, as they may have been set again since they were read,
+// and calls the expiry callback with each
+func (k *KVStore) expire(keys [][]byte) {
+	if len(keys) == 0 {
+		return
+	}
+	type entry struct{ key, value []byte }
+	var removed []entry
+	err := k.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(k.BucketName))
+		expiry := tx.Bucket(k.expiryBucket())
+		now := time.Now()
+		for _, key := range keys {
+			if !isExpired(expiry.Get(key), now) {
+				continue
+			}
+			removed = append(removed, entry{key, append([]byte{}, b.Get(key)...)})
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+			if err := expiry.Delete(key); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
+	if err != nil {
+		return
+	}
+
+	k.Lock()
+	k.expired += len(removed)
+	onExpire := k.onExpire
+	k.Unlock()
+	if onExpire == nil {
+		return
+	}
+	for _, e := range removed {
+		onExpire(e.key, e.value)
+	}
+}
+
+func parseCount(key []byte, value []byte) (int64, error) {
+	if value == nil {
+		return 0, nil
+	}
+	count, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Value of %s is not a count", key)
+	}
+	return count, nil
+}
+
+func expiryTime(t time.Time) []byte {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(t.UnixNano()))
+	return value
+}
+
+func isExpired(expiry []byte, now time.Time) bool {
+	if len(expiry) != 8 {
+		return false
+	}
+	return bytes.Compare(expiry, expiryTime(now)) <= 0
 }
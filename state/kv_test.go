@@ -2,7 +2,9 @@ package state
 
 import (
 	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestKVStore(t *testing.T) {
@@ -31,3 +33,123 @@ func TestKVStore(t *testing.T) {
 
 	os.Remove("test.db")
 }
+
+func TestKVStoreTTL(t *testing.T) {
+	kv := KVStore{
+		DbFileName: "ttl_test.db",
+		BucketName: "Test",
+		TTL:        time.Hour,
+	}
+	if err := kv.Init(); err != nil {
+		t.Fatalf("Error initialising store %s", err)
+	}
+	defer os.Remove("ttl_test.db")
+	defer kv.Close()
+
+	var expired []string
+	kv.OnExpire(func(key, value []byte) {
+		expired = append(expired, string(key)+"="+string(value))
+	})
+	kv.Set([]byte("session"), []byte("bob"))
+	kv.SetWithTTL([]byte("forever"), []byte("alice"), 0)
+	kv.SetWithTTL([]byte("short"), []byte("carol"), time.Millisecond)
+	kv.SetWithTTL([]byte("read"), []byte("dave"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if stats := kv.Stats(); stats.Live != 2 || stats.Expired != 0 {
+		t.Errorf("Expected 2 live keys and none expired, got %+v", stats)
+	}
+	var keys []string
+	kv.ForEach(func(k, v []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	if !reflect.DeepEqual(keys, []string{"forever", "session"}) {
+		t.Errorf("Expected ForEach to skip expired keys, got %v", keys)
+	}
+
+	if value := kv.Get([]byte("read")); value != nil {
+		t.Errorf("Expected an expired key to be empty, got %s", value)
+	}
+	if !reflect.DeepEqual(expired, []string{"read=dave"}) {
+		t.Errorf("Expected reading an expired key to expire it, got %v", expired)
+	}
+
+	var sweepStats KVStats
+	kv.OnSweep(func(stats KVStats) { sweepStats = stats })
+	kv.Sweep()
+	if !reflect.DeepEqual(expired, []string{"read=dave", "short=carol"}) {
+		t.Errorf("Expected the sweep to expire the remaining key, got %v", expired)
+	}
+	if sweepStats.Live != 2 || sweepStats.Expired != 2 {
+		t.Errorf("Expected 2 live and 2 expired keys, got %+v", sweepStats)
+	}
+	if value := kv.Get([]byte("session")); string(value) != "bob" {
+		t.Errorf("Expected a key within the store's TTL to be live, got %s", value)
+	}
+}
+
+func TestKVStoreSweeper(t *testing.T) {
+	kv := KVStore{
+		DbFileName:    "sweeper_test.db",
+		BucketName:    "Test",
+		SweepInterval: time.Millisecond,
+	}
+	if err := kv.Init(); err != nil {
+		t.Fatalf("Error initialising store %s", err)
+	}
+	defer os.Remove("sweeper_test.db")
+
+	expired := make(chan string, 1)
+	kv.OnExpire(func(key, _ []byte) { expired <- string(key) })
+	kv.SetWithTTL([]byte("foo"), []byte("bar"), time.Millisecond)
+	select {
+	case key := <-expired:
+		if key != "foo" {
+			t.Errorf("Expected foo to expire, got %s", key)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the sweeper to expire foo")
+	}
+	kv.Close()
+}
+
+func TestKVStoreCountersAndSets(t *testing.T) {
+	kv := &KVStore{
+		DbFileName: "sets_test.db",
+		BucketName: "Test",
+	}
+	if err := kv.Init(); err != nil {
+		t.Fatalf("Error initialising store %s", err)
+	}
+	defer os.Remove("sets_test.db")
+	defer kv.Close()
+	testKeyedCounterAndSet(t, kv)
+}
+
+func TestKVStoreSharedLifecycle(t *testing.T) {
+	kv := &KVStore{DbFileName: "lifecycle_test.db", BucketName: "Test"}
+	defer os.Remove("lifecycle_test.db")
+	if err := kv.Init(); err != nil {
+		t.Fatalf("Error initialising store %s", err)
+	}
+	// A rule initialising the state the pipeline has already opened mustn't open the database again
+	if err := kv.Init(); err != nil {
+		t.Fatalf("Expected initialising an open store to succeed, got %s", err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatalf("Error closing store %s", err)
+	}
+	if err := kv.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Errorf("Expected the store to stay open until every Init is closed, got %s", err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatalf("Error closing store %s", err)
+	}
+	if err := kv.Set([]byte("foo"), []byte("bar")); err == nil {
+		t.Error("Expected the store to be closed")
+	}
+	if err := kv.Close(); err != nil {
+		t.Errorf("Expected closing a closed store to do nothing, got %s", err)
+	}
+}
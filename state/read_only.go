@@ -0,0 +1,126 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrReadOnly is returned when writing to a read only state
+var ErrReadOnly = errors.New("State is read only")
+
+// ReadOnly wraps a state so that it can be read but not written to, for rules that share a state another rule writes to.
+// The wrapper implements the same interfaces as the state, writes return ErrReadOnly or are ignored if they can't return an error.
+// Init and Close do nothing, as the state is initialised and closed by the pipeline.
+func ReadOnly(s State) (State, error) {
+	kv, isKeyValue := s.(KeyValue)
+	counter, isKeyedCounter := s.(KeyedCounter)
+	set, isSet := s.(Set)
+	switch {
+	case isKeyValue && isKeyedCounter && isSet:
+		return &readOnlyStore{readOnlyKeyValue{kv}, readOnlyKeyedCounter{counter}, readOnlySet{set}}, nil
+	case isKeyValue:
+		return readOnlyKeyValue{kv}, nil
+	case isKeyedCounter:
+		return readOnlyKeyedCounter{counter}, nil
+	case isSet:
+		return readOnlySet{set}, nil
+	}
+
+	switch sketch := s.(type) {
+	case DistinctCounter:
+		return readOnlyDistinctCounter{sketch}, nil
+	case FrequencyCounter:
+		return readOnlyFrequencyCounter{sketch}, nil
+	case Membership:
+		return readOnlyMembership{sketch}, nil
+	}
+	return nil, fmt.Errorf("%T state can't be read only", s)
+}
+
+func ignoredWrite(s State) {
+	log.Errorf("Ignored a write to read only %T state", s)
+}
+
+// readOnlyStore is a read only state that stores values, counts and sets, such as a KVStore
+type readOnlyStore struct {
+	readOnlyKeyValue
+	readOnlyKeyedCounter
+	readOnlySet
+}
+
+func (r *readOnlyStore) Init() error { return nil }
+
+func (r *readOnlyStore) Close() error { return nil }
+
+type readOnlyKeyValue struct {
+	KeyValue
+}
+
+func (r readOnlyKeyValue) Init() error { return nil }
+
+func (r readOnlyKeyValue) Close() error { return nil }
+
+func (r readOnlyKeyValue) Set(key []byte, value []byte) error { return ErrReadOnly }
+
+func (r readOnlyKeyValue) Delete(key []byte) { ignoredWrite(r.KeyValue) }
+
+type readOnlyKeyedCounter struct {
+	KeyedCounter
+}
+
+func (r readOnlyKeyedCounter) Init() error { return nil }
+
+func (r readOnlyKeyedCounter) Close() error { return nil }
+
+func (r readOnlyKeyedCounter) Add(key []byte, delta int64) (int64, error) { return 0, ErrReadOnly }
+
+// readOnlySet doesn't embed Set, as its name would conflict with the Set method of a readOnlyStore
+type readOnlySet struct {
+	set Set
+}
+
+func (r readOnlySet) Init() error { return nil }
+
+func (r readOnlySet) Close() error { return nil }
+
+func (r readOnlySet) AddMember(key []byte, member []byte) error { return ErrReadOnly }
+
+func (r readOnlySet) RemoveMember(key []byte, member []byte) error { return ErrReadOnly }
+
+func (r readOnlySet) HasMember(key []byte, member []byte) (bool, error) {
+	return r.set.HasMember(key, member)
+}
+
+func (r readOnlySet) Members(key []byte) ([][]byte, error) { return r.set.Members(key) }
+
+type readOnlyDistinctCounter struct {
+	DistinctCounter
+}
+
+func (r readOnlyDistinctCounter) Init() error { return nil }
+
+func (r readOnlyDistinctCounter) Close() error { return nil }
+
+func (r readOnlyDistinctCounter) Add(item []byte) { ignoredWrite(r.DistinctCounter) }
+
+type readOnlyFrequencyCounter struct {
+	FrequencyCounter
+}
+
+func (r readOnlyFrequencyCounter) Init() error { return nil }
+
+func (r readOnlyFrequencyCounter) Close() error { return nil }
+
+func (r readOnlyFrequencyCounter) Add(item []byte, count uint64) { ignoredWrite(r.FrequencyCounter) }
+
+type readOnlyMembership struct {
+	Membership
+}
+
+func (r readOnlyMembership) Init() error { return nil }
+
+func (r readOnlyMembership) Close() error { return nil }
+
+func (r readOnlyMembership) Add(item []byte) { ignoredWrite(r.Membership) }
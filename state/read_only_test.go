@@ -0,0 +1,73 @@
+package state
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	kv := &KVStore{DbFileName: "read_only_test.db", BucketName: "Test"}
+	if err := kv.Init(); err != nil {
+		t.Fatalf("Error initialising store %s", err)
+	}
+	defer os.Remove("read_only_test.db")
+	defer kv.Close()
+	kv.Set([]byte("role"), []byte("bob"))
+	kv.AddMember([]byte("admins"), []byte("bob"))
+
+	s, err := ReadOnly(kv)
+	if err != nil {
+		t.Fatalf("Error wrapping store %s", err)
+	}
+	for _, requirement := range []Requirement{RequiresKeyValue, RequiresKeyedCounter, RequiresSet} {
+		if !requirement.SatisfiedBy(s) {
+			t.Errorf("Expected a read only KV state to implement %s", requirement)
+		}
+	}
+	// The pipeline initialises and closes the state, so these do nothing
+	if err := s.Init(); err != nil {
+		t.Errorf("Expected initialising a read only state to do nothing, got %s", err)
+	}
+	s.Close()
+
+	reader := s.(interface {
+		KeyValue
+		KeyedCounter
+		Set
+	})
+	if value := reader.Get([]byte("role")); string(value) != "bob" {
+		t.Errorf("Expected to read bob, got %s", value)
+	}
+	if ok, _ := reader.HasMember([]byte("admins"), []byte("bob")); !ok {
+		t.Errorf("Expected to read the admins set")
+	}
+	if err := reader.Set([]byte("role"), []byte("mallory")); err != ErrReadOnly {
+		t.Errorf("Expected setting a read only state to fail, got %v", err)
+	}
+	if _, err := reader.Add([]byte("logins"), 1); err != ErrReadOnly {
+		t.Errorf("Expected counting in a read only state to fail, got %v", err)
+	}
+	if err := reader.AddMember([]byte("admins"), []byte("mallory")); err != ErrReadOnly {
+		t.Errorf("Expected adding to a read only set to fail, got %v", err)
+	}
+	reader.Delete([]byte("role"))
+	if value := kv.Get([]byte("role")); string(value) != "bob" {
+		t.Errorf("Expected deleting from a read only state to be ignored, got %s", value)
+	}
+
+	bloom, _ := NewBloom(100, 0.01)
+	bloom.Add([]byte("example.com"))
+	s, err = ReadOnly(bloom)
+	if err != nil {
+		t.Fatalf("Error wrapping Bloom filter %s", err)
+	}
+	membership := s.(Membership)
+	membership.Add([]byte("example.org"))
+	if !membership.Test([]byte("example.com")) || bloom.Test([]byte("example.org")) {
+		t.Errorf("Expected a read only Bloom filter to be readable but not writable")
+	}
+
+	if _, err := ReadOnly(&MemoryCounter{}); err == nil {
+		t.Errorf("Expected a Count state to not be read only")
+	}
+}
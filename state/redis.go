@@ -0,0 +1,430 @@
+package state
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisConfig defines the configuration of a Redis store
+type RedisConfig struct {
+	Address  string `json:"address"`
+	Password string `json:"password,omitempty"`
+	DB       int    `json:"db,omitempty"`
+	// KeyPrefix is prepended to every key, so rules can share a database
+	KeyPrefix string `json:"keyPrefix"`
+	// TTLSeconds expires keys set without their own TTL after this many seconds, if it's 0 they never expire
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+	// PoolSize is the maximum number of connections, defaults to 10
+	PoolSize int `json:"poolSize,omitempty"`
+	// TimeoutMs is the timeout of connecting and of each command, defaults to 1000
+	TimeoutMs int `json:"timeoutMs,omitempty"`
+}
+
+// RedisStore provides a KeyValue store with counters and sets, persisted in Redis and shared by every go-fish instance using it
+type RedisStore struct {
+	RedisConfig
+	// TTL is the TTL of keys set without their own TTL
+	TTL     time.Duration
+	timeout time.Duration
+	// idle holds connections that can be reused, and slots limits the number of open connections
+	idle      chan *redisConn
+	slots     chan struct{}
+	mutex     sync.Mutex
+	closed    bool
+	lifecycle lifecycle
+}
+
+// redisError is an error reply from Redis
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// Init initialises the Redis store if it isn't already open, checking that Redis can be reached
+func (r *RedisStore) Init() error {
+	return r.lifecycle.init(r.open)
+}
+
+func (r *RedisStore) open() error {
+	if r.Address == "" {
+		return errors.New("Redis state requires an address")
+	}
+	if r.PoolSize == 0 {
+		r.PoolSize = 10
+	}
+	if r.TimeoutMs == 0 {
+		r.TimeoutMs = 1000
+	}
+	r.timeout = time.Duration(r.TimeoutMs) * time.Millisecond
+	r.idle = make(chan *redisConn, r.PoolSize)
+	r.slots = make(chan struct{}, r.PoolSize)
+	r.mutex.Lock()
+	r.closed = false
+	r.mutex.Unlock()
+
+	_, err := r.do("PING")
+	return err
+}
+
+// Close closes the connections to Redis once every Init has been matched by a Close,
+// connections in use are closed when they're returned to the pool
+func (r *RedisStore) Close() error {
+	return r.lifecycle.close(r.close)
+}
+
+func (r *RedisStore) close() error {
+	r.mutex.Lock()
+	r.closed = true
+	r.mutex.Unlock()
+	for {
+		select {
+		case conn := <-r.idle:
+			conn.Close()
+			<-r.slots
+		default:
+			return nil
+		}
+	}
+}
+
+// Set sets a Key to the defined value, expiring after the store's TTL
+func (r *RedisStore) Set(key []byte, value []byte) error {
+	return r.SetWithTTL(key, value, r.TTL)
+}
+
+// SetWithTTL sets a Key to the defined value, expiring after the TTL.
+// A TTL of 0 means the key never expires.
+func (r *RedisStore) SetWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	args := []interface{}{"SET", r.key(key), value}
+	if ttl > 0 {
+		args = append(args, "PX", milliseconds(ttl))
+	}
+	_, err := r.do(args...)
+	return err
+}
+
+// Get retrieves the specified value, or nil if it doesn't exist, has expired or Redis can't be reached
+func (r *RedisStore) Get(key []byte) []byte {
+	reply, err := r.do("GET", r.key(key))
+	if err != nil {
+		return nil
+	}
+	value, _ := reply.([]byte)
+	return value
+}
+
+// ForEach executes the function for each key/value pair, with the key prefix removed.
+// Keys are scanned in batches, so keys set or deleted while iterating may or may not be visited.
+func (r *RedisStore) ForEach(function func(k, v []byte) error) error {
+	cursor := "0"
+	for {
+		reply, err := r.do("SCAN", cursor, "MATCH", globEscape(r.KeyPrefix)+"*", "COUNT", "100")
+		if err != nil {
+			return err
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return fmt.Errorf("Invalid SCAN reply: %v", reply)
+		}
+		next, _ := page[0].([]byte)
+		keys, _ := page[1].([]interface{})
+
+		if len(keys) > 0 {
+			values, err := r.do(append([]interface{}{"MGET"}, keys...)...)
+			if err != nil {
+				return err
+			}
+			valueList, _ := values.([]interface{})
+			for i, key := range keys {
+				if i >= len(valueList) || valueList[i] == nil {
+					// Deleted or expired since it was scanned
+					continue
+				}
+				if err = function(key.([]byte)[len(r.KeyPrefix):], valueList[i].([]byte)); err != nil {
+					return err
+				}
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// Delete deletes the given key
+func (r *RedisStore) Delete(key []byte) {
+	r.do("DEL", r.key(key))
+}
+
+// Add atomically adds delta to the count of a key, which starts at 0, and returns the new count.
+// A new count expires after the store's TTL.
+func (r *RedisStore) Add(key []byte, delta int64) (int64, error) {
+	reply, err := r.do("INCRBY", r.key(key), strconv.FormatInt(delta, 10))
+	if err != nil {
+		return 0, err
+	}
+	count, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("Invalid INCRBY reply: %v", reply)
+	}
+	if count == delta && r.TTL > 0 {
+		// The key was created, so the new count expires after the store's TTL
+		_, err = r.Expire(key, r.TTL)
+	}
+	return count, err
+}
+
+// Count returns the count of a key, or 0 if it hasn't been counted
+func (r *RedisStore) Count(key []byte) (int64, error) {
+	reply, err := r.do("GET", r.key(key))
+	if err != nil || reply == nil {
+		return 0, err
+	}
+	count, err := strconv.ParseInt(string(reply.([]byte)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Value of %s is not a count", key)
+	}
+	return count, nil
+}
+
+// AddMember adds a member to the set of a key
+func (r *RedisStore) AddMember(key []byte, member []byte) error {
+	_, err := r.do("SADD", r.key(key), member)
+	return err
+}
+
+// RemoveMember removes a member from the set of a key
+func (r *RedisStore) RemoveMember(key []byte, member []byte) error {
+	_, err := r.do("SREM", r.key(key), member)
+	return err
+}
+
+// HasMember returns whether a member is in the set of a key
+func (r *RedisStore) HasMember(key []byte, member []byte) (bool, error) {
+	reply, err := r.do("SISMEMBER", r.key(key), member)
+	return reply == int64(1), err
+}
+
+// Members returns the members of the set of a key
+func (r *RedisStore) Members(key []byte) ([][]byte, error) {
+	reply, err := r.do("SMEMBERS", r.key(key))
+	if err != nil {
+		return nil, err
+	}
+	replies, _ := reply.([]interface{})
+	members := make([][]byte, 0, len(replies))
+	for _, member := range replies {
+		members = append(members, member.([]byte))
+	}
+	return members, nil
+}
+
+// Expire sets the TTL of an existing key, returning false if the key doesn't exist
+func (r *RedisStore) Expire(key []byte, ttl time.Duration) (bool, error) {
+	reply, err := r.do("PEXPIRE", r.key(key), milliseconds(ttl))
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+// KeyTTL returns how long until a key expires, 0 if it never expires, or -1 if it doesn't exist
+func (r *RedisStore) KeyTTL(key []byte) (time.Duration, error) {
+	reply, err := r.do("PTTL", r.key(key))
+	if err != nil {
+		return 0, err
+	}
+	ttl, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("Invalid PTTL reply: %v", reply)
+	}
+	switch ttl {
+	case -2:
+		return -1, nil
+	case -1:
+		return 0, nil
+	}
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
+func (r *RedisStore) key(key []byte) []byte {
+	return append([]byte(r.KeyPrefix), key...)
+}
+
+// do sends a command on a pooled connection and returns the reply
+func (r *RedisStore) do(args ...interface{}) (interface{}, error) {
+	conn, err := r.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(r.timeout, args...)
+	r.put(conn, err)
+	return reply, err
+}
+
+// get takes an idle connection, or opens a new one if the pool isn't full, waiting up to the timeout for one
+func (r *RedisStore) get() (*redisConn, error) {
+	select {
+	case conn := <-r.idle:
+		return conn, nil
+	default:
+	}
+
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+	select {
+	case conn := <-r.idle:
+		return conn, nil
+	case r.slots <- struct{}{}:
+	case <-timer.C:
+		return nil, errors.New("Timed out waiting for a Redis connection")
+	}
+
+	conn, err := r.dial()
+	if err != nil {
+		<-r.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+// put returns a connection to the pool, unless the store is closed or the connection failed in a way
+// that may leave it unusable
+func (r *RedisStore) put(conn *redisConn, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := err.(redisError); r.closed || err != nil && !ok {
+		conn.Close()
+		<-r.slots
+		return
+	}
+	r.idle <- conn
+}
+
+func (r *RedisStore) dial() (*redisConn, error) {
+	netConn, err := net.DialTimeout("tcp", r.Address, r.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	if r.Password != "" {
+		if _, err = conn.do(r.timeout, "AUTH", r.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.DB != 0 {
+		if _, err = conn.do(r.timeout, "SELECT", strconv.Itoa(r.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// redisConn speaks the Redis serialization protocol (RESP)
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// do writes a command as an array of bulk strings and reads the reply.
+// Replies are a string, int64, []byte, nil, []interface{} or redisError.
+func (c *redisConn) do(timeout time.Duration, args ...interface{}) (interface{}, error) {
+	c.SetDeadline(time.Now().Add(timeout))
+	command := []byte(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		var value []byte
+		switch v := arg.(type) {
+		case []byte:
+			value = v
+		case string:
+			value = []byte(v)
+		default:
+			value = []byte(fmt.Sprint(v))
+		}
+		command = append(command, fmt.Sprintf("$%d\r\n", len(value))...)
+		command = append(command, value...)
+		command = append(command, "\r\n"...)
+	}
+	if _, err := c.Write(command); err != nil {
+		return nil, err
+	}
+	reply, err := readReply(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if replyErr, ok := reply.(redisError); ok {
+		return nil, replyErr
+	}
+	return reply, nil
+}
+
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("Invalid Redis reply: %q", line)
+	}
+	value := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return value, nil
+	case '-':
+		return redisError(value), nil
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 {
+			return nil, err
+		}
+		bulk := make([]byte, length+2)
+		if _, err = io.ReadFull(reader, bulk); err != nil {
+			return nil, err
+		}
+		return bulk[:length], nil
+	case '*':
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 {
+			return nil, err
+		}
+		array := make([]interface{}, length)
+		for i := range array {
+			if array[i], err = readReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	}
+	return nil, fmt.Errorf("Invalid Redis reply: %q", line)
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Millisecond), 10)
+}
+
+// globEscape escapes the characters of a SCAN MATCH pattern
+func globEscape(prefix string) string {
+	var escaped strings.Builder
+	for _, c := range prefix {
+		if strings.ContainsRune(`*?[]\`, c) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(c)
+	}
+	return escaped.String()
+}
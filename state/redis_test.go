@@ -0,0 +1,328 @@
+package state
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process stand-in for Redis, implementing the commands used by RedisStore
+type fakeRedis struct {
+	sync.Mutex
+	listener    net.Listener
+	password    string
+	values      map[string]string
+	expires     map[string]time.Time
+	sets        map[string]map[string]bool
+	connections int
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting fake Redis %s", err)
+	}
+	f := &fakeRedis{
+		listener: listener,
+		password: password,
+		values:   make(map[string]string),
+		expires:  make(map[string]time.Time),
+		sets:     make(map[string]map[string]bool),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.Lock()
+			f.connections++
+			f.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := f.password == ""
+	for {
+		request, err := readReply(reader)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range request.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+		if strings.ToUpper(args[0]) == "AUTH" {
+			authenticated = args[1] == f.password
+		}
+		if !authenticated {
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+		conn.Write(f.execute(args))
+	}
+}
+
+func (f *fakeRedis) execute(args []string) []byte {
+	f.Lock()
+	defer f.Unlock()
+	for key, expires := range f.expires {
+		if !time.Now().Before(expires) {
+			delete(f.values, key)
+			delete(f.expires, key)
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return []byte("+PONG\r\n")
+	case "AUTH", "SELECT":
+		return []byte("+OK\r\n")
+	case "SET":
+		f.values[args[1]] = args[2]
+		delete(f.expires, args[1])
+		if len(args) == 5 && args[3] == "PX" {
+			ms, _ := strconv.Atoi(args[4])
+			f.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return []byte("+OK\r\n")
+	case "GET":
+		return bulk(f.values, args[1])
+	case "MGET":
+		reply := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			reply += string(bulk(f.values, key))
+		}
+		return []byte(reply)
+	case "DEL":
+		_, ok := f.values[args[1]]
+		delete(f.values, args[1])
+		delete(f.expires, args[1])
+		return integer(ok)
+	case "INCRBY":
+		count, err := strconv.Atoi(f.values[args[1]])
+		if _, ok := f.values[args[1]]; ok && err != nil {
+			return []byte("-ERR value is not an integer or out of range\r\n")
+		}
+		delta, _ := strconv.Atoi(args[2])
+		f.values[args[1]] = strconv.Itoa(count + delta)
+		return []byte(fmt.Sprintf(":%d\r\n", count+delta))
+	case "PEXPIRE":
+		_, ok := f.values[args[1]]
+		if ok {
+			ms, _ := strconv.Atoi(args[2])
+			f.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return integer(ok)
+	case "PTTL":
+		if _, ok := f.values[args[1]]; !ok {
+			return []byte(":-2\r\n")
+		}
+		expires, ok := f.expires[args[1]]
+		if !ok {
+			return []byte(":-1\r\n")
+		}
+		return []byte(fmt.Sprintf(":%d\r\n", time.Until(expires)/time.Millisecond))
+	case "SADD":
+		if f.sets[args[1]] == nil {
+			f.sets[args[1]] = make(map[string]bool)
+		}
+		f.sets[args[1]][args[2]] = true
+		return []byte(":1\r\n")
+	case "SREM":
+		delete(f.sets[args[1]], args[2])
+		return []byte(":1\r\n")
+	case "SISMEMBER":
+		return integer(f.sets[args[1]][args[2]])
+	case "SMEMBERS":
+		var members []string
+		for member := range f.sets[args[1]] {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		reply := fmt.Sprintf("*%d\r\n", len(members))
+		for _, member := range members {
+			reply += fmt.Sprintf("$%d\r\n%s\r\n", len(member), member)
+		}
+		return []byte(reply)
+	case "SCAN":
+		// Returns one key per call, with the cursor as the index of the next key
+		prefix := strings.Replace(strings.TrimSuffix(args[3], "*"), `\`, "", -1)
+		var keys []string
+		for key := range f.values {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		cursor, _ := strconv.Atoi(args[1])
+		if cursor >= len(keys) {
+			return []byte("*2\r\n$1\r\n0\r\n*0\r\n")
+		}
+		next := strconv.Itoa(cursor + 1)
+		if cursor+1 == len(keys) {
+			next = "0"
+		}
+		key := keys[cursor]
+		return []byte(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*1\r\n$%d\r\n%s\r\n", len(next), next, len(key), key))
+	}
+	return []byte("-ERR unknown command '" + args[0] + "'\r\n")
+}
+
+func bulk(values map[string]string, key string) []byte {
+	value, ok := values[key]
+	if !ok {
+		return []byte("$-1\r\n")
+	}
+	return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(value), value))
+}
+
+func integer(ok bool) []byte {
+	if ok {
+		return []byte(":1\r\n")
+	}
+	return []byte(":0\r\n")
+}
+
+func TestRedisStore(t *testing.T) {
+	server := startFakeRedis(t, "secret")
+	defer server.listener.Close()
+	store := &RedisStore{RedisConfig: RedisConfig{Address: server.listener.Addr().String(), Password: "secret", DB: 1, KeyPrefix: "rule/"}}
+	if err := store.Init(); err != nil {
+		t.Fatalf("Error initialising store %s", err)
+	}
+	defer store.Close()
+	server.values["other/foo"] = "other"
+
+	if err := store.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("Could not set key/value %s", err)
+	}
+	store.Set([]byte("baz"), []byte("qux"))
+	if server.values["rule/foo"] != "bar" {
+		t.Errorf("Expected the key to be prefixed, got %v", server.values)
+	}
+	if value := store.Get([]byte("foo")); string(value) != "bar" {
+		t.Errorf("Expected value at foo to be bar, got %s", value)
+	}
+
+	pairs := map[string]string{}
+	store.ForEach(func(k, v []byte) error {
+		pairs[string(k)] = string(v)
+		return nil
+	})
+	if !reflect.DeepEqual(pairs, map[string]string{"foo": "bar", "baz": "qux"}) {
+		t.Errorf("Expected ForEach to only visit the rule's keys, got %v", pairs)
+	}
+
+	store.Delete([]byte("foo"))
+	if value := store.Get([]byte("foo")); value != nil {
+		t.Errorf("Expected value at foo to be empty, got %s", value)
+	}
+	if server.connections != 1 {
+		t.Errorf("Expected the connection to be reused, got %d connections", server.connections)
+	}
+}
+
+func TestRedisStoreCountersAndTTLs(t *testing.T) {
+	server := startFakeRedis(t, "")
+	defer server.listener.Close()
+	store := &RedisStore{RedisConfig: RedisConfig{Address: server.listener.Addr().String()}, TTL: time.Hour}
+	if err := store.Init(); err != nil {
+		t.Fatalf("Error initialising store %s", err)
+	}
+	defer store.Close()
+
+	store.Add([]byte("logins"), 1)
+	if ttl, _ := store.KeyTTL([]byte("logins")); ttl <= 59*time.Minute {
+		t.Errorf("Expected a new counter to have the store's TTL, got %s", ttl)
+	}
+	store.Expire([]byte("logins"), 2*time.Hour)
+	if count, err := store.Add([]byte("logins"), 2); err != nil || count != 3 {
+		t.Errorf("Expected count to be 3, got %d %v", count, err)
+	}
+	store.Set([]byte("text"), []byte("a"))
+	if _, err := store.Add([]byte("text"), 1); err == nil || err.Error() != "ERR value is not an integer or out of range" {
+		t.Errorf("Expected incrementing text to raise an error, got %v", err)
+	}
+
+	if ttl, _ := store.KeyTTL([]byte("text")); ttl <= 59*time.Minute {
+		t.Errorf("Expected the store's TTL, got %s", ttl)
+	}
+	if ttl, _ := store.KeyTTL([]byte("logins")); ttl <= 119*time.Minute {
+		t.Errorf("Expected adding to a counter not to change its TTL, got %s", ttl)
+	}
+	if ttl, _ := store.KeyTTL([]byte("missing")); ttl != -1 {
+		t.Errorf("Expected a missing key to have a TTL of -1, got %s", ttl)
+	}
+	if ok, _ := store.Expire([]byte("logins"), time.Millisecond); !ok {
+		t.Error("Expected the counter's TTL to be set")
+	}
+	store.SetWithTTL([]byte("session"), []byte("bob"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if value := store.Get([]byte("session")); value != nil {
+		t.Errorf("Expected session to have expired, got %s", value)
+	}
+	if count, _ := store.Add([]byte("logins"), 1); count != 1 {
+		t.Errorf("Expected the expired counter to restart, got %d", count)
+	}
+}
+
+func TestRedisStoreCountersAndSets(t *testing.T) {
+	server := startFakeRedis(t, "")
+	defer server.listener.Close()
+	store := &RedisStore{RedisConfig: RedisConfig{Address: server.listener.Addr().String()}}
+	if err := store.Init(); err != nil {
+		t.Fatalf("Error initialising store %s", err)
+	}
+	defer store.Close()
+	testKeyedCounterAndSet(t, store)
+}
+
+func TestRedisStorePool(t *testing.T) {
+	server := startFakeRedis(t, "secret")
+	store := &RedisStore{RedisConfig: RedisConfig{Address: server.listener.Addr().String(), PoolSize: 2, TimeoutMs: 50}}
+	if err := store.Init(); err == nil {
+		t.Error("Expected a command without AUTH to raise an error")
+	}
+	server.listener.Close()
+
+	server = startFakeRedis(t, "")
+	defer server.listener.Close()
+	store.Address = server.listener.Addr().String()
+	if err := store.Init(); err != nil {
+		t.Fatalf("Error initialising store %s", err)
+	}
+	first, _ := store.get()
+	second, _ := store.get()
+	if _, err := store.get(); err == nil {
+		t.Error("Expected waiting for a connection from a full pool to time out")
+	}
+	store.put(first, nil)
+	store.put(second, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Add([]byte("count"), 1)
+		}()
+	}
+	wg.Wait()
+	if count := store.Get([]byte("count")); string(count) != "20" || server.connections > 2 {
+		t.Errorf("Expected 20 increments over at most 2 connections, got %s over %d", count, server.connections)
+	}
+	store.Close()
+}
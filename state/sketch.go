@@ -0,0 +1,77 @@
+package state
+
+import (
+	"encoding"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// sketchVersion is the first byte of a serialised sketch, so the format can change
+const sketchVersion = 1
+
+// sketch is a probabilistic data structure that can be serialised to a snapshot
+type sketch interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// hashItem returns two independent 64 bit hashes of an item.
+// The hashes are mixed with the murmur3 finaliser so every bit depends on every byte of the item.
+func hashItem(item []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(item)
+	h1 := mix64(h.Sum64())
+	h2 := mix64(h1 ^ 0x9e3779b97f4a7c15)
+	return h1, h2 | 1
+}
+
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// loadSnapshot restores a sketch from a snapshot file, if it exists
+func loadSnapshot(fileName string, s sketch) error {
+	if fileName == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.UnmarshalBinary(data)
+}
+
+// saveSnapshot writes a sketch to a snapshot file, replacing it atomically
+func saveSnapshot(fileName string, s sketch) error {
+	if fileName == "" {
+		return nil
+	}
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName))
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
@@ -2,18 +2,163 @@ package state
 
 import (
 	"fmt"
+	"sync"
+	"time"
 )
 
 // Config defines the configuration for a state
 type Config struct {
-	Type     string   `json:"type"`
-	KVConfig KVConfig `json:"kvConfig,omitempty"`
+	Type           string         `json:"type"`
+	KVConfig       KVConfig       `json:"kvConfig,omitempty"`
+	DynamoDBConfig DynamoDBConfig `json:"dynamoDBConfig,omitempty"`
+	RedisConfig    RedisConfig    `json:"redisConfig,omitempty"`
+	// KeyedCountConfig configures a KeyedCount state, which counts each key over a sliding window
+	KeyedCountConfig KeyedCountConfig `json:"keyedCountConfig,omitempty"`
+	// HyperLogLogConfig, CountMinConfig and BloomConfig configure probabilistic sketches
+	HyperLogLogConfig HyperLogLogConfig `json:"hyperLogLogConfig,omitempty"`
+	CountMinConfig    CountMinConfig    `json:"countMinConfig,omitempty"`
+	BloomConfig       BloomConfig       `json:"bloomConfig,omitempty"`
 }
 
-// State is the interface for stateful storage backings for a rule
+// State is the interface for stateful storage backings for a rule.
+// The pipeline initialises and closes states, but rules can still call Init and Close: a state is only
+// initialised by the first Init, and only closed once every Init has been matched by a Close.
 type State interface {
 	Init() error
-	Close()
+	Close() error
+}
+
+// lifecycle counts the Inits of a state that haven't been matched by a Close,
+// so a state shared by the pipeline and its rules is opened and closed once
+type lifecycle struct {
+	mutex sync.Mutex
+	users int
+}
+
+// init opens the state if it's the first user
+func (l *lifecycle) init(open func() error) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.users == 0 {
+		if err := open(); err != nil {
+			return err
+		}
+	}
+	l.users++
+	return nil
+}
+
+// close closes the state if it's the last user, closing a state that isn't open does nothing
+func (l *lifecycle) close(close func() error) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.users == 0 {
+		return nil
+	}
+	l.users--
+	if l.users > 0 {
+		return nil
+	}
+	return close()
+}
+
+// KeyValue is a State that stores values by key
+type KeyValue interface {
+	State
+	Get(key []byte) []byte
+	Set(key []byte, value []byte) error
+	Delete(key []byte)
+	ForEach(func(k, v []byte) error) error
+}
+
+// WindowCounter is a State that counts events in a window
+type WindowCounter interface {
+	State
+	Increment()
+	// Window returns the count and resets it
+	Window() int
+	// Value returns the count without resetting it
+	Value() int
+}
+
+// KeyedCounter is a State that keeps a count for each key
+type KeyedCounter interface {
+	State
+	// Add adds delta to the count of a key, which starts at 0, and returns the new count
+	Add(key []byte, delta int64) (int64, error)
+	Count(key []byte) (int64, error)
+}
+
+// Set is a State that keeps a set of members for each key
+type Set interface {
+	State
+	AddMember(key []byte, member []byte) error
+	RemoveMember(key []byte, member []byte) error
+	HasMember(key []byte, member []byte) (bool, error)
+	Members(key []byte) ([][]byte, error)
+}
+
+// DistinctCounter is a State that estimates the number of distinct items added to it
+type DistinctCounter interface {
+	State
+	Add(item []byte)
+	Count() uint64
+}
+
+// FrequencyCounter is a State that estimates how many times each item has been added
+type FrequencyCounter interface {
+	State
+	Add(item []byte, count uint64)
+	Estimate(item []byte) uint64
+}
+
+// Membership is a State that tests whether an item has been added, possibly with false positives
+type Membership interface {
+	State
+	Add(item []byte)
+	Test(item []byte) bool
+}
+
+// Requirement is the name of an interface a rule requires its state to implement
+type Requirement string
+
+// Requirements of a rule's state
+const (
+	RequiresKeyValue         Requirement = "KeyValue"
+	RequiresWindowCounter    Requirement = "WindowCounter"
+	RequiresKeyedCounter     Requirement = "KeyedCounter"
+	RequiresSet              Requirement = "Set"
+	RequiresDistinctCounter  Requirement = "DistinctCounter"
+	RequiresFrequencyCounter Requirement = "FrequencyCounter"
+	RequiresMembership       Requirement = "Membership"
+)
+
+// SatisfiedBy returns whether the state implements the required interface
+func (r Requirement) SatisfiedBy(s State) bool {
+	var ok bool
+	switch r {
+	case RequiresKeyValue:
+		_, ok = s.(KeyValue)
+	case RequiresWindowCounter:
+		_, ok = s.(WindowCounter)
+	case RequiresKeyedCounter:
+		_, ok = s.(KeyedCounter)
+	case RequiresSet:
+		_, ok = s.(Set)
+	case RequiresDistinctCounter:
+		_, ok = s.(DistinctCounter)
+	case RequiresFrequencyCounter:
+		_, ok = s.(FrequencyCounter)
+	case RequiresMembership:
+		_, ok = s.(Membership)
+	}
+	return ok
+}
+
+// Requirer is implemented by rules to declare the interfaces their state must implement,
+// so that a pipeline configured with the wrong type of state is rejected before it starts
+type Requirer interface {
+	StateRequirements() []Requirement
 }
 
 // Create creates the stateful backing
@@ -21,11 +166,31 @@ func Create(config Config) (State, error) {
 	switch config.Type {
 	case "KV":
 		return &KVStore{
-			DbFileName: config.KVConfig.DbFileName,
-			BucketName: config.KVConfig.BucketName,
+			DbFileName:    config.KVConfig.DbFileName,
+			BucketName:    config.KVConfig.BucketName,
+			TTL:           time.Duration(config.KVConfig.TTLSeconds) * time.Second,
+			SweepInterval: time.Duration(config.KVConfig.SweepIntervalSec) * time.Second,
+		}, nil
+	case "DynamoDB":
+		return &DynamoDBStore{
+			DynamoDBConfig: config.DynamoDBConfig,
+			TTL:            time.Duration(config.DynamoDBConfig.TTLSeconds) * time.Second,
+		}, nil
+	case "Redis":
+		return &RedisStore{
+			RedisConfig: config.RedisConfig,
+			TTL:         time.Duration(config.RedisConfig.TTLSeconds) * time.Second,
 		}, nil
+	case "KeyedCount":
+		return &KeyedCountStore{KeyedCountConfig: config.KeyedCountConfig}, nil
+	case "HyperLogLog":
+		return &HyperLogLog{HyperLogLogConfig: config.HyperLogLogConfig}, nil
+	case "CountMin":
+		return &CountMin{CountMinConfig: config.CountMinConfig}, nil
+	case "Bloom":
+		return &Bloom{BloomConfig: config.BloomConfig}, nil
 	case "Count":
-		return &Counter{}, nil
+		return &MemoryCounter{}, nil
 	}
 
 	return nil, fmt.Errorf("Invalid state type: %v", config.Type)
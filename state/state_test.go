@@ -0,0 +1,79 @@
+package state
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestStateRequirements(t *testing.T) {
+	expected := map[string][]Requirement{
+		"KV":          {RequiresKeyValue, RequiresKeyedCounter, RequiresSet},
+		"DynamoDB":    {RequiresKeyValue, RequiresKeyedCounter, RequiresSet},
+		"Redis":       {RequiresKeyValue, RequiresKeyedCounter, RequiresSet},
+		"Count":       {RequiresWindowCounter},
+		"KeyedCount":  {RequiresKeyedCounter},
+		"HyperLogLog": {RequiresDistinctCounter},
+		"CountMin":    {RequiresFrequencyCounter},
+		"Bloom":       {RequiresMembership},
+	}
+	for stateType, requirements := range expected {
+		s, err := Create(Config{Type: stateType})
+		if err != nil {
+			t.Fatalf("Error creating %s state %s", stateType, err)
+		}
+		var satisfied []Requirement
+		for _, requirement := range []Requirement{
+			RequiresKeyValue, RequiresWindowCounter, RequiresKeyedCounter, RequiresSet,
+			RequiresDistinctCounter, RequiresFrequencyCounter, RequiresMembership,
+		} {
+			if requirement.SatisfiedBy(s) {
+				satisfied = append(satisfied, requirement)
+			}
+		}
+		if !reflect.DeepEqual(satisfied, requirements) {
+			t.Errorf("Expected %s state to implement %v, got %v", stateType, requirements, satisfied)
+		}
+	}
+}
+
+// testKeyedCounterAndSet tests the KeyedCounter and Set implementations of a store
+func testKeyedCounterAndSet(t *testing.T, store interface {
+	KeyedCounter
+	Set
+}) {
+	if count, err := store.Count([]byte("logins")); err != nil || count != 0 {
+		t.Errorf("Expected an uncounted key to be 0, got %d %v", count, err)
+	}
+	store.Add([]byte("logins"), 1)
+	if count, err := store.Add([]byte("logins"), 2); err != nil || count != 3 {
+		t.Errorf("Expected count to be 3, got %d %v", count, err)
+	}
+	if count, err := store.Count([]byte("logins")); err != nil || count != 3 {
+		t.Errorf("Expected count to be 3, got %d %v", count, err)
+	}
+
+	store.AddMember([]byte("roles"), []byte("admin"))
+	store.AddMember([]byte("roles"), []byte("dev"))
+	store.AddMember([]byte("roles"), []byte("admin"))
+	store.AddMember([]byte("other"), []byte("ops"))
+	if ok, err := store.HasMember([]byte("roles"), []byte("dev")); err != nil || !ok {
+		t.Errorf("Expected dev to be a member, got %v", err)
+	}
+	if ok, _ := store.HasMember([]byte("roles"), []byte("ops")); ok {
+		t.Error("Expected ops not to be a member")
+	}
+	store.RemoveMember([]byte("roles"), []byte("dev"))
+	members, err := store.Members([]byte("roles"))
+	var names []string
+	for _, member := range members {
+		names = append(names, string(member))
+	}
+	sort.Strings(names)
+	if err != nil || !reflect.DeepEqual(names, []string{"admin"}) {
+		t.Errorf("Expected admin to be the only member, got %v %v", names, err)
+	}
+	if members, _ = store.Members([]byte("missing")); len(members) != 0 {
+		t.Errorf("Expected a missing set to have no members, got %v", members)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/patrobinson/go-fish/state"
+)
+
+var (
+	errStateNotFound = errors.New("State does not exist")
+	errKeyNotFound   = errors.New("Key does not exist")
+)
+
+// keyPage is a page of a state's keys, Next is the key to list the next page after
+type keyPage struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"`
+}
+
+// State gets an open state of a pipeline created by the manager. The state is initialised again,
+// so it stays open until the caller closes it, even if the pipeline is stopped in the meantime.
+func (pM *pipelineManager) State(id string, name string) (state.State, error) {
+	pM.Lock()
+	p, ok := pM.pipelines[id]
+	pM.Unlock()
+	if !ok {
+		return nil, errPipelineNotFound
+	}
+	p.Lock()
+	defer p.Unlock()
+	s, ok := p.states[name]
+	if !ok || p.stateStatuses[name].Status != stateOpen {
+		return nil, errStateNotFound
+	}
+	if err := s.Init(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// listKeys lists a page of the keys with a prefix, in order, starting after a key.
+// Not every backend can list keys in order, so the matching keys are sorted before the page is taken.
+func listKeys(kv state.KeyValue, prefix string, after string, limit int) (keyPage, error) {
+	var keys []string
+	err := kv.ForEach(func(k, v []byte) error {
+		key := string(k)
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return keyPage{}, err
+	}
+	sort.Strings(keys)
+	page := keyPage{Keys: keys}
+	if len(keys) > limit {
+		page.Keys = keys[:limit]
+		page.Next = keys[limit-1]
+	}
+	if page.Keys == nil {
+		page.Keys = []string{}
+	}
+	return page, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// State statuses
+const (
+	stateOpen   = "open"
+	stateClosed = "closed"
+	stateFailed = "failed"
+)
+
+// stateStatus reports whether a pipeline's state is open, and the error if it failed to close
+type stateStatus struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// pipelineStatus reports whether a pipeline is running and the status of its states
+type pipelineStatus struct {
+	ID       string                 `json:"id"`
+	Name     string                 `json:"name"`
+	Revision int                    `json:"revision"`
+	Running  bool                   `json:"running"`
+	States   map[string]stateStatus `json:"states,omitempty"`
+}
+
+// Status gets the status of a pipeline created by the manager
+func (pM *pipelineManager) Status(id string) (pipelineStatus, error) {
+	pM.Lock()
+	p, ok := pM.pipelines[id]
+	pM.Unlock()
+	if !ok {
+		return pipelineStatus{}, errPipelineNotFound
+	}
+	return p.Status(), nil
+}
+
+// Status gets the status of the pipeline
+func (p *pipeline) Status() pipelineStatus {
+	p.Lock()
+	defer p.Unlock()
+	status := pipelineStatus{
+		ID:       p.ID.String(),
+		Name:     p.Name,
+		Revision: p.Revision,
+		Running:  p.pipelineReady,
+		States:   make(map[string]stateStatus, len(p.stateStatuses)),
+	}
+	for name, s := range p.stateStatuses {
+		status.States[name] = s
+	}
+	return status
+}
+
+// openState records that a state has been initialised, so it's closed with the pipeline
+func (p *pipeline) openState(name string, s stateStatus) {
+	p.Lock()
+	defer p.Unlock()
+	s.Status = stateOpen
+	p.stateStatuses[name] = s
+}
+
+// closeStates closes the pipeline's open states, recording any errors in the pipeline's status
+func (p *pipeline) closeStates() {
+	p.Lock()
+	defer p.Unlock()
+	for name, s := range p.states {
+		status := p.stateStatuses[name]
+		if status.Status != stateOpen {
+			continue
+		}
+		if err := s.Close(); err != nil {
+			log.Errorf("Error closing state %s: %v", name, err)
+			status.Status = stateFailed
+			status.Error = err.Error()
+		} else {
+			status.Status = stateClosed
+		}
+		p.stateStatuses[name] = status
+	}
+}
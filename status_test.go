@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/patrobinson/go-fish/state"
+)
+
+type closeFailingState struct {
+	state.MemoryCounter
+}
+
+func (s *closeFailingState) Close() error {
+	return errors.New("Disk full")
+}
+
+func TestPipelineStateStatus(t *testing.T) {
+	p := &pipeline{
+		Name: "statusTest",
+		states: map[string]state.State{
+			"counter": &state.MemoryCounter{},
+			"failing": &closeFailingState{},
+		},
+		stateStatuses: make(map[string]stateStatus),
+	}
+	p.openState("counter", stateStatus{Type: "Count"})
+	p.openState("failing", stateStatus{Type: "Count"})
+
+	status := p.Status()
+	if status.Name != "statusTest" || status.Running || status.States["counter"] != (stateStatus{Type: "Count", Status: stateOpen}) {
+		t.Errorf("Expected the counter to be open, got %+v", status)
+	}
+
+	p.closeStates()
+	status = p.Status()
+	if status.States["counter"].Status != stateClosed {
+		t.Errorf("Expected the counter to be closed, got %+v", status.States["counter"])
+	}
+	if failing := status.States["failing"]; failing.Status != stateFailed || failing.Error != "Disk full" {
+		t.Errorf("Expected the failing state's error to be reported, got %+v", failing)
+	}
+
+	// Closing again does nothing, as the states aren't open
+	p.states["failing"] = &state.MemoryCounter{}
+	p.closeStates()
+	if failing := p.Status().States["failing"]; failing.Status != stateFailed {
+		t.Errorf("Expected a closed state to not be closed again, got %+v", failing)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/patrobinson/go-fish/ruleHelpers"
+	"github.com/patrobinson/go-fish/state"
+)
+
+type statefulRule struct {
+	rulehelpers.BasicRule
+}
+
+func (r *statefulRule) StateRequirements() []state.Requirement {
+	return []state.Requirement{state.RequiresKeyValue, state.RequiresSet}
+}
+
+func (r *statefulRule) Process(thing interface{}) interface{} { return nil }
+
+func (r *statefulRule) String() string { return "statefulRule" }
+
+var Rule statefulRule
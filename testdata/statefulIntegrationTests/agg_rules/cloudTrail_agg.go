@@ -11,20 +11,24 @@ import (
 
 type cloudTrailAggRule struct {
 	State   state.State
-	kvStore *state.KVStore
+	kvStore state.KeyValue
 }
 
 func (rule *cloudTrailAggRule) Init(s ...interface{}) error {
-	invalidStateError := fmt.Errorf("This rule expects a KVStore state, but got: %v", s)
+	invalidStateError := fmt.Errorf("This rule expects a KeyValue state, but got: %v", s)
 	if len(s) < 1 {
 		return invalidStateError
 	}
 	var ok bool
-	rule.kvStore, ok = s[0].(*state.KVStore)
+	rule.kvStore, ok = s[0].(state.KeyValue)
 	if !ok {
 		return invalidStateError
 	}
-	return rule.kvStore.Init()
+	return nil
+}
+
+func (rule *cloudTrailAggRule) StateRequirements() []state.Requirement {
+	return []state.Requirement{state.RequiresKeyValue}
 }
 
 func (rule *cloudTrailAggRule) Process(evt interface{}) interface{} {
@@ -117,7 +121,6 @@ func (rule *cloudTrailAggRule) generatePrincipalName(userIdentity es.UserIdentit
 func (rule *cloudTrailAggRule) String() string { return "cloudTrailAggRule" }
 
 func (rule *cloudTrailAggRule) Close() error {
-	rule.kvStore.Close()
 	return nil
 }
 
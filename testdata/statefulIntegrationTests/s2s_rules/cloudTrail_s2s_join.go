@@ -12,20 +12,24 @@ import (
 
 type cloudTrailRule struct {
 	State   state.State
-	kvStore *state.KVStore
+	kvStore state.KeyValue
 }
 
 func (rule *cloudTrailRule) Init(s ...interface{}) error {
-	invalidStateError := fmt.Errorf("This rule expects a KVStore state, but got: %v", s)
+	invalidStateError := fmt.Errorf("This rule expects a KeyValue state, but got: %v", s)
 	if len(s) < 1 {
 		return invalidStateError
 	}
 	var ok bool
-	rule.kvStore, ok = s[0].(*state.KVStore)
+	rule.kvStore, ok = s[0].(state.KeyValue)
 	if !ok {
 		return invalidStateError
 	}
-	return rule.kvStore.Init()
+	return nil
+}
+
+func (rule *cloudTrailRule) StateRequirements() []state.Requirement {
+	return []state.Requirement{state.RequiresKeyValue}
 }
 
 func (rule *cloudTrailRule) Window() ([]output.OutputEvent, error) {
@@ -59,7 +63,6 @@ func (rule *cloudTrailRule) Process(evt interface{}) interface{} {
 func (rule *cloudTrailRule) String() string { return "cloudTrailRule" }
 
 func (rule *cloudTrailRule) Close() error {
-	rule.kvStore.Close()
 	return nil
 }
 
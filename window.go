@@ -24,26 +24,35 @@ func (w *windowManager) start() {
 			w.windowRunner()
 			select {
 			case <-closeChan:
-				break
+				return
 			case <-time.After(1 * time.Second):
 			}
 		}
 	}(closeChan)
 }
 
+// stop stops calling Window(), it does nothing if the manager isn't started
 func (w *windowManager) stop() {
+	if w.closeChan == nil {
+		return
+	}
 	close(*w.closeChan)
+	w.closeChan = nil
 }
 
 func (w *windowManager) windowRunner() {
-	if time.Now().Sub(w.lastCalled).Seconds() > float64(w.interval) {
-		outputs, err := w.rule.Window()
-		if err != nil {
-			log.Errorf("Error calling Window() on rule %v: %v", w.rule.String(), err)
-		}
-		for _, o := range outputs {
-			*w.sinkChan <- o
-		}
-		w.lastCalled = time.Now()
+	w.RLock()
+	if time.Now().Sub(w.lastCalled).Seconds() <= float64(w.interval) {
+		w.RUnlock()
+		return
+	}
+	outputs, err := w.rule.Window()
+	if err != nil {
+		log.Errorf("Error calling Window() on rule %v: %v", w.rule.String(), err)
+	}
+	w.RUnlock()
+	for _, o := range outputs {
+		*w.sinkChan <- o
 	}
+	w.lastCalled = time.Now()
 }